@@ -0,0 +1,83 @@
+package cartridge
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testCart = `pico-8 cartridge // http://www.pico-8.com
+version 41
+__lua__
+print("old")
+-->8
+print("tab")
+__gfx__
+00000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000
+00700700000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000
+__label__
+00000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000
+__gff__
+0001000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000
+__map__
+0102030400000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000
+__sfx__
+000100000c0500e0501005011050130501505017050180501a0501c0501d0501f050210502305024050260502805029050000000000000000000000000000000000000000000000000000000000000000000000000
+__music__
+00 01424344
+`
+
+func Test_ParseRoundTrip(t *testing.T) {
+	cart, err := Parse([]byte(testCart))
+	require.NoError(t, err)
+	require.Equal(t, 41, cart.Version)
+	require.Len(t, cart.Sections, 7)
+	require.Equal(t, testCart, string(cart.Bytes()))
+}
+
+func Test_SetLuaPreservesAssets(t *testing.T) {
+	cart, err := Parse([]byte(testCart))
+	require.NoError(t, err)
+
+	cart.SetLua("print(\"new\")\n")
+
+	expected := strings.Replace(testCart, "print(\"old\")\n-->8\nprint(\"tab\")\n", "print(\"new\")\n", 1)
+	require.Equal(t, expected, string(cart.Bytes()))
+}
+
+func Test_SetSectionInsertsInOrder(t *testing.T) {
+	cart, err := Parse([]byte("pico-8 cartridge // http://www.pico-8.com\nversion 42\n__gfx__\n0000\n__sfx__\n0101\n"))
+	require.NoError(t, err)
+
+	cart.SetLua("x = 1")
+	cart.SetSection(Section_Map, "0102")
+
+	var names []string
+	for _, s := range cart.Sections {
+		names = append(names, s.Name)
+	}
+	require.Equal(t, []string{Section_Lua, Section_Gfx, Section_Map, Section_Sfx}, names)
+	require.Equal(t, "x = 1\n", cart.Lua())
+}
+
+func Test_NewCart(t *testing.T) {
+	cart := New()
+	cart.SetLua("print(1)\n")
+	require.Equal(t, "pico-8 cartridge // http://www.pico-8.com\nversion 42\n__lua__\nprint(1)\n", string(cart.Bytes()))
+}
+
+func Test_ParseInvalidHeader(t *testing.T) {
+	_, err := Parse([]byte("print(1)\n"))
+	require.ErrorIs(t, err, ErrInvalidHeader)
+}
+
+func Test_ParseKeepsMissingTrailingNewline(t *testing.T) {
+	src := "pico-8 cartridge // http://www.pico-8.com\nversion 42\n__lua__\nprint(1)\n__music__\n00 41424344"
+	cart, err := Parse([]byte(src))
+	require.NoError(t, err)
+	require.Equal(t, src, string(cart.Bytes()))
+
+	cart.SetSection("__meta:notes__", "hello")
+	require.True(t, strings.HasSuffix(string(cart.Bytes()), "00 41424344\n__meta:notes__\nhello\n"))
+}
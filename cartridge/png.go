@@ -0,0 +1,205 @@
+package cartridge
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"pixie/p8scii"
+	"strings"
+)
+
+// Layout of a .p8.png image. The cart's memory is hidden in the two lowest bits of each
+// colour channel, one byte per pixel in reading order, and the label is drawn in the
+// middle of the picture.
+const (
+	pngWidth    = 160
+	pngHeight   = 205
+	labelX      = 16
+	labelY      = 24
+	labelSize   = 128
+	versionAddr = romSize // versionAddr holds the cart format version, right after the cart's memory
+
+	labelChars = "0123456789abcdefghijklmnopqrstuv" // labelChars are the colour digits used by __label__
+)
+
+var (
+	// palette holds the 16 standard PICO-8 colours followed by the 16 secret ones,
+	// indexed the same way as the digits in a __label__ section.
+	palette = [32]color.NRGBA{
+		{0x00, 0x00, 0x00, 0xff}, {0x1d, 0x2b, 0x53, 0xff}, {0x7e, 0x25, 0x53, 0xff}, {0x00, 0x87, 0x51, 0xff},
+		{0xab, 0x52, 0x36, 0xff}, {0x5f, 0x57, 0x4f, 0xff}, {0xc2, 0xc3, 0xc7, 0xff}, {0xff, 0xf1, 0xe8, 0xff},
+		{0xff, 0x00, 0x4d, 0xff}, {0xff, 0xa3, 0x00, 0xff}, {0xff, 0xec, 0x27, 0xff}, {0x00, 0xe4, 0x36, 0xff},
+		{0x29, 0xad, 0xff, 0xff}, {0x83, 0x76, 0x9c, 0xff}, {0xff, 0x77, 0xa8, 0xff}, {0xff, 0xcc, 0xaa, 0xff},
+		{0x29, 0x18, 0x14, 0xff}, {0x11, 0x1d, 0x35, 0xff}, {0x42, 0x21, 0x36, 0xff}, {0x12, 0x53, 0x59, 0xff},
+		{0x74, 0x2f, 0x29, 0xff}, {0x49, 0x33, 0x3b, 0xff}, {0xa2, 0x88, 0x79, 0xff}, {0xf3, 0xef, 0x7d, 0xff},
+		{0xbe, 0x12, 0x50, 0xff}, {0xff, 0x6c, 0x24, 0xff}, {0xa8, 0xe7, 0x2e, 0xff}, {0x00, 0xb5, 0x43, 0xff},
+		{0x06, 0x5a, 0xb5, 0xff}, {0x75, 0x46, 0x65, 0xff}, {0xff, 0x6e, 0x59, 0xff}, {0xff, 0x9d, 0x81, 0xff},
+	}
+)
+
+var (
+	ErrInvalidPNG      = errors.New("not a PICO-8 cartridge image")       // ErrInvalidPNG is returned when an image has the wrong dimensions
+	ErrCodeTooLarge    = errors.New("compressed code is too large")       // ErrCodeTooLarge is returned when the code does not fit in a .p8.png
+	errInvalidLabelRow = errors.New("label row has an invalid character") // errInvalidLabelRow is returned when a __label__ line cannot be drawn
+)
+
+// ReadPNG decodes a .p8.png cartridge. The code is decompressed and converted to text,
+// the assets are converted to their .p8 sections and the picture is kept so writing the
+// cart back out preserves its artwork.
+func ReadPNG(r io.Reader) (cart *Cart, err error) {
+	img, err := png.Decode(r)
+	if err != nil {
+		err = fmt.Errorf("failed to decode png: %w", err)
+		return
+	}
+
+	bounds := img.Bounds()
+	if bounds.Dx() != pngWidth || bounds.Dy() != pngHeight {
+		err = fmt.Errorf("%w: image is %dx%d, want %dx%d", ErrInvalidPNG, bounds.Dx(), bounds.Dy(), pngWidth, pngHeight)
+		return
+	}
+
+	picture := image.NewNRGBA(image.Rect(0, 0, pngWidth, pngHeight))
+	draw.Draw(picture, picture.Bounds(), img, bounds.Min, draw.Src)
+
+	data := make([]byte, pngWidth*pngHeight)
+	for i := range data {
+		c := picture.NRGBAAt(i%pngWidth, i/pngWidth)
+		data[i] = (c.A&3)<<6 | (c.R&3)<<4 | (c.G&3)<<2 | c.B&3
+	}
+
+	code, err := DecompressCode(data[romCode:romSize])
+	if err != nil {
+		err = fmt.Errorf("failed to decompress code: %w", err)
+		return
+	}
+
+	cart = &Cart{
+		Version: int(data[versionAddr]),
+		picture: picture,
+	}
+	cart.SetLua(p8scii.Decode(code))
+	cart.setROMData(data[:romCode])
+	if label, ok := readLabel(picture); ok {
+		cart.SetSection(Section_Label, label)
+	}
+
+	return cart, nil
+}
+
+// WritePNG encodes the cart as a .p8.png. If the cart was read from a .p8.png its
+// picture is reused, otherwise a plain one is generated. The __label__ section, when
+// present, is drawn into the picture.
+func (c *Cart) WritePNG(w io.Writer) (err error) {
+	rom, err := c.romData()
+	if err != nil {
+		err = fmt.Errorf("failed to convert assets: %w", err)
+		return
+	}
+
+	code, err := p8scii.Encode(c.Lua())
+	if err != nil {
+		err = fmt.Errorf("failed to encode code: %w", err)
+		return
+	}
+
+	compressed, err := CompressCode(code)
+	if err != nil {
+		err = fmt.Errorf("failed to compress code: %w", err)
+		return
+	}
+	if len(compressed) > CodeLimit {
+		err = fmt.Errorf("%w: %d bytes, limit is %d", ErrCodeTooLarge, len(compressed), CodeLimit)
+		return
+	}
+
+	data := make([]byte, pngWidth*pngHeight)
+	copy(data, rom)
+	copy(data[romCode:], compressed)
+	data[versionAddr] = byte(c.Version)
+
+	picture := image.NewNRGBA(image.Rect(0, 0, pngWidth, pngHeight))
+	if c.picture != nil {
+		draw.Draw(picture, picture.Bounds(), c.picture, c.picture.Bounds().Min, draw.Src)
+	} else {
+		draw.Draw(picture, picture.Bounds(), image.NewUniform(palette[1]), image.Point{}, draw.Src)
+		draw.Draw(picture, image.Rect(labelX, labelY, labelX+labelSize, labelY+labelSize), image.NewUniform(palette[0]), image.Point{}, draw.Src)
+	}
+
+	if label, ok := c.Section(Section_Label); ok {
+		if err = drawLabel(picture, label); err != nil {
+			err = fmt.Errorf("failed to draw label: %w", err)
+			return
+		}
+	}
+
+	for i, b := range data {
+		x, y := i%pngWidth, i/pngWidth
+		p := picture.NRGBAAt(x, y)
+		picture.SetNRGBA(x, y, color.NRGBA{
+			R: p.R&^3 | b>>4&3,
+			G: p.G&^3 | b>>2&3,
+			B: p.B&^3 | b&3,
+			A: p.A&^3 | b>>6&3,
+		})
+	}
+
+	if err = png.Encode(w, picture); err != nil {
+		err = fmt.Errorf("failed to encode png: %w", err)
+		return
+	}
+	return nil
+}
+
+// drawLabel draws a __label__ section into the label area of the picture.
+func drawLabel(picture *image.NRGBA, label string) (err error) {
+	for y, line := range strings.Split(strings.TrimRight(label, "\r\n"), "\n") {
+		if y >= labelSize {
+			break
+		}
+		line = strings.TrimSpace(line)
+		for x := 0; x < len(line) && x < labelSize; x++ {
+			index := strings.IndexByte(labelChars, line[x])
+			if index < 0 {
+				err = fmt.Errorf("%w: %q on line %d", errInvalidLabelRow, line[x], y+1)
+				return
+			}
+			picture.SetNRGBA(labelX+x, labelY+y, palette[index])
+		}
+	}
+	return nil
+}
+
+// readLabel reads the label area of the picture back into a __label__ section, matching
+// each pixel to the nearest palette colour. It reports false when the label is blank.
+func readLabel(picture *image.NRGBA) (label string, ok bool) {
+	var sb strings.Builder
+	for y := 0; y < labelSize; y++ {
+		for x := 0; x < labelSize; x++ {
+			index := nearestColor(picture.NRGBAAt(labelX+x, labelY+y))
+			ok = ok || index != 0
+			sb.WriteByte(labelChars[index])
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String(), ok
+}
+
+// nearestColor returns the palette index closest to c, ignoring the two lowest bits of
+// each channel as those carry cart data.
+func nearestColor(c color.NRGBA) (index int) {
+	best := -1
+	for i, p := range palette {
+		dr := int(c.R&^3) - int(p.R&^3)
+		dg := int(c.G&^3) - int(p.G&^3)
+		db := int(c.B&^3) - int(p.B&^3)
+		if d := dr*dr + dg*dg + db*db; best < 0 || d < best {
+			best, index = d, i
+		}
+	}
+	return index
+}
@@ -0,0 +1,154 @@
+package cartridge
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CompressCodeRoundTrip(t *testing.T) {
+	random := make([]byte, 4000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	tests := map[string][]byte{
+		"empty":      {},
+		"single":     []byte("a"),
+		"repetitive": []byte(strings.Repeat("print(\"hello world\")\n", 200)),
+		"random":     random,
+		"all_bytes": func() (b []byte) {
+			for i := 0; i < 256; i++ {
+				b = append(b, byte(i))
+			}
+			return
+		}(),
+	}
+
+	for name, code := range tests {
+		t.Run(name, func(t *testing.T) {
+			data, err := CompressCode(code)
+			require.NoError(t, err)
+			require.Equal(t, pxaHeader, string(data[:4]))
+
+			decoded, err := DecompressCode(data)
+			require.NoError(t, err)
+			require.Equal(t, code, decoded)
+		})
+	}
+}
+
+func Test_CompressCodeShrinksRepetition(t *testing.T) {
+	code := []byte(strings.Repeat("spr(1,x,y)\n", 500))
+	data, err := CompressCode(code)
+	require.NoError(t, err)
+	require.Less(t, len(data), len(code)/10)
+}
+
+func Test_DecompressLegacy(t *testing.T) {
+	// "abc abc": three table characters, a space, then a three byte back reference
+	data := []byte(legacyHeader + "\x00\x07\x00\x00" + "\x0d\x0e\x0f\x02\x3c\x14")
+	code, err := DecompressCode(data)
+	require.NoError(t, err)
+	require.Equal(t, "abc abc", string(code))
+}
+
+func Test_DecompressPlain(t *testing.T) {
+	code, err := DecompressCode([]byte("print(1)\x00\x00garbage"))
+	require.NoError(t, err)
+	require.Equal(t, "print(1)", string(code))
+}
+
+func Test_DecompressCorrupt(t *testing.T) {
+	_, err := DecompressCode([]byte(pxaHeader + "\x00\x10\x00\x09\x00"))
+	require.ErrorIs(t, err, ErrCorruptCode)
+}
+
+// fullCart returns a .p8 cart whose asset sections are already in the canonical form
+// ReadPNG produces, so a round trip through a .p8.png can be compared exactly.
+func fullCart() *Cart {
+	cart := New()
+	cart.SetLua("-- glyphs survive: ⬅️➡️\nfunction _draw()\ncls(1)\nspr(0,60,60)\nend\n")
+
+	gfx := strings.Repeat("0123456789abcdef", 8) + "\n" + strings.Repeat("f", 128) + "\n"
+	cart.SetSection(Section_Gfx, gfx)
+
+	var label strings.Builder
+	for y := 0; y < labelSize; y++ {
+		for x := 0; x < labelSize; x++ {
+			label.WriteByte(labelChars[(x+y)%len(labelChars)])
+		}
+		label.WriteByte('\n')
+	}
+	cart.SetSection(Section_Label, label.String())
+	cart.SetSection(Section_Gff, "0001020304"+strings.Repeat("0", 246)+"\n")
+	cart.SetSection(Section_Map, strings.Repeat("0", 254)+"ff\n"+strings.Repeat("01", 128)+"\n")
+	cart.SetSection(Section_Sfx, "00100020"+strings.Repeat("0c573", 31)+"18a41\n")
+	cart.SetSection(Section_Music, "00 01424344\n07 00010203\n")
+	return cart
+}
+
+func Test_PNGRoundTrip(t *testing.T) {
+	cart := fullCart()
+
+	var buf bytes.Buffer
+	require.NoError(t, cart.WritePNG(&buf))
+
+	img, err := png.Decode(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, pngWidth, img.Bounds().Dx())
+	require.Equal(t, pngHeight, img.Bounds().Dy())
+
+	decoded, err := ReadPNG(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, cart.Version, decoded.Version)
+	require.Equal(t, cart.Sections, decoded.Sections)
+	require.Equal(t, string(cart.Bytes()), string(decoded.Bytes()))
+}
+
+func Test_PNGReplaceCodeKeepsAssets(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, fullCart().WritePNG(&buf))
+
+	cart, err := ReadPNG(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	cart.SetLua("print(\"replaced\")\n")
+
+	var out bytes.Buffer
+	require.NoError(t, cart.WritePNG(&out))
+
+	decoded, err := ReadPNG(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, "print(\"replaced\")\n", decoded.Lua())
+
+	expected := fullCart()
+	expected.SetLua("print(\"replaced\")\n")
+	require.Equal(t, expected.Sections, decoded.Sections)
+}
+
+func Test_PNGCodeTooLarge(t *testing.T) {
+	random := make([]byte, 30000)
+	rand.New(rand.NewSource(2)).Read(random)
+	for i := range random {
+		random[i] = 'a' + random[i]%26
+	}
+
+	cart := New()
+	cart.SetLua(string(random))
+	err := cart.WritePNG(&bytes.Buffer{})
+	require.ErrorIs(t, err, ErrCodeTooLarge)
+}
+
+func Test_ReadPNGWrongSize(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, newTestImage(10, 10)))
+	_, err := ReadPNG(&buf)
+	require.ErrorIs(t, err, ErrInvalidPNG)
+}
+
+func newTestImage(w, h int) *image.NRGBA {
+	return image.NewNRGBA(image.Rect(0, 0, w, h))
+}
@@ -0,0 +1,434 @@
+package cartridge
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	pxaHeader    = "\x00pxa" // pxaHeader starts code compressed with the current (PXA) scheme
+	legacyHeader = ":c:\x00" // legacyHeader starts code compressed with the pre-0.2.0 scheme
+	headerSize   = 8         // headerSize is the length of both compressed code headers
+
+	pxaMinMatch  = 3       // pxaMinMatch is the shortest back reference PXA can encode
+	pxaMaxOffset = 1 << 15 // pxaMaxOffset is the furthest back a PXA back reference can reach
+	pxaHashSize  = 1 << 14 // pxaHashSize is the number of hash chains used to find matches
+	pxaMaxChain  = 256     // pxaMaxChain limits how many candidates are tried per position
+)
+
+var (
+	// legacyChars is the character table used by the legacy compression scheme.
+	// Index 0 is unused.
+	legacyChars = []byte("#\n 0123456789abcdefghijklmnopqrstuvwxyz!#%(){}[]<>+=/*:;.,~_")
+)
+
+var (
+	ErrCorruptCode = errors.New("corrupt compressed code") // ErrCorruptCode is returned when compressed code cannot be decoded
+)
+
+// DecompressCode decodes the code section of a cart's memory. It accepts the current PXA
+// format, the legacy ":c:" format and plain uncompressed code terminated by a zero byte.
+func DecompressCode(data []byte) (code []byte, err error) {
+	switch {
+	case len(data) >= headerSize && string(data[:4]) == pxaHeader:
+		return decompressPXA(data)
+	case len(data) >= headerSize && string(data[:4]) == legacyHeader:
+		return decompressLegacy(data)
+	}
+
+	for i, b := range data {
+		if b == 0 {
+			return data[:i], nil
+		}
+	}
+	return data, nil
+}
+
+// bitReader reads a PXA bit stream, lowest bit of each byte first.
+type bitReader struct {
+	data []byte
+	pos  int // Position in bits
+}
+
+func (r *bitReader) bit() (bit int, err error) {
+	if r.pos >= len(r.data)*8 {
+		err = fmt.Errorf("%w: unexpected end of data", ErrCorruptCode)
+		return
+	}
+	bit = int(r.data[r.pos/8]>>(r.pos%8)) & 1
+	r.pos++
+	return bit, nil
+}
+
+// bits reads an n bit value, least significant bit first.
+func (r *bitReader) bits(n int) (value int, err error) {
+	for i := 0; i < n; i++ {
+		var bit int
+		if bit, err = r.bit(); err != nil {
+			return
+		}
+		value |= bit << i
+	}
+	return value, nil
+}
+
+func decompressPXA(data []byte) (code []byte, err error) {
+	size := int(data[4])<<8 | int(data[5])
+	r := &bitReader{data: data[headerSize:]}
+
+	var mtf [256]byte
+	for i := range mtf {
+		mtf[i] = byte(i)
+	}
+
+	code = make([]byte, 0, size)
+	for len(code) < size {
+		var bit int
+		if bit, err = r.bit(); err != nil {
+			return
+		}
+
+		if bit == 1 {
+			// Literal: a move-to-front index with a unary encoded width
+			unary := 0
+			for {
+				if bit, err = r.bit(); err != nil {
+					return
+				}
+				if bit == 0 {
+					break
+				}
+				unary++
+			}
+
+			var index int
+			if index, err = r.bits(4 + unary); err != nil {
+				return
+			}
+			index += ((1 << unary) - 1) << 4
+			if index >= len(mtf) {
+				err = fmt.Errorf("%w: literal index %d out of range", ErrCorruptCode, index)
+				return
+			}
+
+			ch := mtf[index]
+			copy(mtf[1:index+1], mtf[:index])
+			mtf[0] = ch
+			code = append(code, ch)
+			continue
+		}
+
+		// Back reference: the offset width is chosen by one or two bits
+		offsetBits := 15
+		if bit, err = r.bit(); err != nil {
+			return
+		}
+		if bit == 1 {
+			offsetBits = 5
+		} else {
+			if bit, err = r.bit(); err != nil {
+				return
+			}
+			if bit == 1 {
+				offsetBits = 10
+			}
+		}
+
+		var offset int
+		if offset, err = r.bits(offsetBits); err != nil {
+			return
+		}
+		offset++
+
+		if offsetBits == 10 && offset == 1 {
+			// Uncompressed run of bytes terminated by a zero
+			for {
+				var ch int
+				if ch, err = r.bits(8); err != nil {
+					return
+				}
+				if ch == 0 {
+					break
+				}
+				code = append(code, byte(ch))
+			}
+			continue
+		}
+
+		length := pxaMinMatch
+		for {
+			var part int
+			if part, err = r.bits(3); err != nil {
+				return
+			}
+			length += part
+			if part != 7 {
+				break
+			}
+		}
+
+		if offset > len(code) {
+			err = fmt.Errorf("%w: back reference before start of code", ErrCorruptCode)
+			return
+		}
+		for i := 0; i < length; i++ {
+			code = append(code, code[len(code)-offset])
+		}
+	}
+
+	return code[:size], nil
+}
+
+func decompressLegacy(data []byte) (code []byte, err error) {
+	size := int(data[4])<<8 | int(data[5])
+	code = make([]byte, 0, size)
+
+	pos := headerSize
+	next := func() (b byte, err error) {
+		if pos >= len(data) {
+			err = fmt.Errorf("%w: unexpected end of data", ErrCorruptCode)
+			return
+		}
+		b = data[pos]
+		pos++
+		return b, nil
+	}
+
+	for len(code) < size {
+		var b byte
+		if b, err = next(); err != nil {
+			return
+		}
+
+		switch {
+		case b == 0:
+			if b, err = next(); err != nil {
+				return
+			}
+			code = append(code, b)
+		case int(b) < len(legacyChars):
+			code = append(code, legacyChars[b])
+		default:
+			var lo byte
+			if lo, err = next(); err != nil {
+				return
+			}
+			offset := (int(b)-len(legacyChars))*16 + int(lo&0xf)
+			length := int(lo>>4) + 2
+			if offset <= 0 || offset > len(code) {
+				err = fmt.Errorf("%w: back reference before start of code", ErrCorruptCode)
+				return
+			}
+			for i := 0; i < length; i++ {
+				code = append(code, code[len(code)-offset])
+			}
+		}
+	}
+
+	return code[:size], nil
+}
+
+// bitWriter writes a PXA bit stream, lowest bit of each byte first.
+type bitWriter struct {
+	data []byte
+	pos  int // Position in bits
+}
+
+func (w *bitWriter) bit(bit int) {
+	if w.pos%8 == 0 {
+		w.data = append(w.data, 0)
+	}
+	w.data[len(w.data)-1] |= byte(bit&1) << (w.pos % 8)
+	w.pos++
+}
+
+// bits writes an n bit value, least significant bit first.
+func (w *bitWriter) bits(n, value int) {
+	for i := 0; i < n; i++ {
+		w.bit(value >> i)
+	}
+}
+
+// CompressCode compresses code with the PXA scheme used by .p8.png carts, including the
+// eight byte header. The result is what PICO-8 stores at the start of the code section,
+// so its length is what counts against the compressed code limit.
+func CompressCode(code []byte) (data []byte, err error) {
+	if len(code) > 0xffff {
+		err = fmt.Errorf("code is %d bytes, more than the 65535 PXA can hold", len(code))
+		return
+	}
+
+	w := &bitWriter{}
+	var mtf [256]byte
+	for i := range mtf {
+		mtf[i] = byte(i)
+	}
+
+	literalBits := func(ch byte) (bits int) {
+		index := 0
+		for mtf[index] != ch {
+			index++
+		}
+		width := 4
+		for index >= 1<<width {
+			index -= 1 << width
+			width++
+		}
+		return 1 + (width - 4) + 1 + width
+	}
+
+	writeLiteral := func(ch byte) {
+		index := 0
+		for mtf[index] != ch {
+			index++
+		}
+		copy(mtf[1:index+1], mtf[:index])
+		mtf[0] = ch
+
+		w.bit(1)
+		width := 4
+		for index >= 1<<width {
+			w.bit(1)
+			index -= 1 << width
+			width++
+		}
+		w.bit(0)
+		w.bits(width, index)
+	}
+
+	writeMatch := func(offset, length int) {
+		w.bit(0)
+		offset--
+		switch {
+		case offset < 1<<5:
+			w.bit(1)
+			w.bits(5, offset)
+		case offset < 1<<10:
+			w.bit(0)
+			w.bit(1)
+			w.bits(10, offset)
+		default:
+			w.bit(0)
+			w.bit(0)
+			w.bits(15, offset)
+		}
+
+		length -= pxaMinMatch
+		for length >= 7 {
+			w.bits(3, 7)
+			length -= 7
+		}
+		w.bits(3, length)
+	}
+
+	matcher := newMatcher(code)
+	for pos := 0; pos < len(code); {
+		offset, length := matcher.longest(pos)
+
+		if length >= pxaMinMatch {
+			// Only use the match when it is cheaper than spelling the bytes out.
+			cost := 0
+			for _, ch := range code[pos : pos+length] {
+				cost += literalBits(ch)
+			}
+			if matchBits(offset, length) < cost {
+				writeMatch(offset, length)
+				for i := 0; i < length; i++ {
+					matcher.insert(pos)
+					pos++
+				}
+				continue
+			}
+		}
+
+		writeLiteral(code[pos])
+		matcher.insert(pos)
+		pos++
+	}
+
+	size := headerSize + len(w.data)
+	if size > 0xffff {
+		err = fmt.Errorf("compressed code is %d bytes, more than the 65535 PXA can hold", size)
+		return
+	}
+
+	data = make([]byte, 0, size)
+	data = append(data, pxaHeader...)
+	data = append(data, byte(len(code)>>8), byte(len(code)), byte(size>>8), byte(size))
+	data = append(data, w.data...)
+	return data, nil
+}
+
+// matchBits returns the number of bits a PXA back reference takes.
+func matchBits(offset, length int) int {
+	bits := 1
+	switch {
+	case offset-1 < 1<<5:
+		bits += 1 + 5
+	case offset-1 < 1<<10:
+		bits += 2 + 10
+	default:
+		bits += 2 + 15
+	}
+	return bits + 3*((length-pxaMinMatch)/7+1)
+}
+
+// matcher finds earlier occurrences of the bytes at a position using hash chains over
+// the three byte prefix of every position.
+type matcher struct {
+	data []byte
+	head []int // Most recent position for each hash, or -1
+	prev []int // Previous position with the same hash, or -1
+}
+
+func newMatcher(data []byte) *matcher {
+	m := &matcher{
+		data: data,
+		head: make([]int, pxaHashSize),
+		prev: make([]int, len(data)),
+	}
+	for i := range m.head {
+		m.head[i] = -1
+	}
+	return m
+}
+
+func (m *matcher) hash(pos int) int {
+	d := m.data[pos:]
+	return (int(d[0])<<10 ^ int(d[1])<<5 ^ int(d[2])) % pxaHashSize
+}
+
+// insert records pos so later positions can refer back to it.
+func (m *matcher) insert(pos int) {
+	if pos+pxaMinMatch > len(m.data) {
+		m.prev[pos] = -1
+		return
+	}
+	h := m.hash(pos)
+	m.prev[pos] = m.head[h]
+	m.head[h] = pos
+}
+
+// longest returns the offset and length of the longest earlier match for pos.
+func (m *matcher) longest(pos int) (offset, length int) {
+	if pos+pxaMinMatch > len(m.data) {
+		return 0, 0
+	}
+
+	for candidate, tries := m.head[m.hash(pos)], 0; candidate >= 0 && tries < pxaMaxChain; candidate, tries = m.prev[candidate], tries+1 {
+		if pos-candidate > pxaMaxOffset {
+			break
+		}
+
+		n := 0
+		for pos+n < len(m.data) && m.data[candidate+n] == m.data[pos+n] {
+			n++
+		}
+
+		// Prefer the nearest candidate on ties as its offset is cheaper.
+		if n > length {
+			offset, length = pos-candidate, n
+		}
+	}
+	return offset, length
+}
@@ -0,0 +1,238 @@
+package cartridge
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Layout of a cart's memory. Everything below romCode is the same data the .p8 asset
+// sections describe; romCode onwards holds the (usually compressed) Lua source.
+const (
+	romGfx      = 0x0000 // romGfx is the start of the sprite sheet
+	romMap      = 0x2000 // romMap is the start of the upper half of the map
+	romGff      = 0x3000 // romGff is the start of the sprite flags
+	romMusic    = 0x3100 // romMusic is the start of the music patterns
+	romSfx      = 0x3200 // romSfx is the start of the sound effects
+	romCode     = 0x4300 // romCode is the start of the code section
+	romSize     = 0x8000 // romSize is the size of the cart's memory
+	romCodeSize = romSize - romCode
+
+	// CodeLimit is the most bytes the compressed code of a .p8.png can take.
+	CodeLimit = romCodeSize
+
+	sfxSize = 68 // sfxSize is the number of bytes a single sound effect takes
+)
+
+// romLayout describes how a hex encoded asset section maps to memory.
+type romLayout struct {
+	name    string
+	addr    int
+	rows    int // Number of rows in memory
+	rowSize int // Number of bytes each row takes in memory
+}
+
+var (
+	// hexLayouts are the sections that are plain hex dumps of memory, one byte per two
+	// characters. The sprite sheet is also a hex dump but stores one pixel per character.
+	hexLayouts = []romLayout{
+		{name: Section_Map, addr: romMap, rows: 32, rowSize: 128},
+		{name: Section_Gff, addr: romGff, rows: 2, rowSize: 128},
+	}
+)
+
+// romData converts the asset sections of the cart into the memory layout stored in a
+// .p8.png, excluding the code section.
+func (c *Cart) romData() (rom []byte, err error) {
+	rom = make([]byte, romCode)
+
+	if err = c.readSectionLines(Section_Gfx, 128, func(y int, line string) error {
+		for x := 0; x+1 < len(line) && x < 128; x += 2 {
+			lo, err := parseHex(line[x : x+1])
+			if err != nil {
+				return err
+			}
+			hi, err := parseHex(line[x+1 : x+2])
+			if err != nil {
+				return err
+			}
+			rom[romGfx+y*64+x/2] = byte(lo | hi<<4)
+		}
+		return nil
+	}); err != nil {
+		return
+	}
+
+	for _, layout := range hexLayouts {
+		if err = c.readSectionLines(layout.name, layout.rows, func(y int, line string) error {
+			for i := 0; i+1 < len(line) && i/2 < layout.rowSize; i += 2 {
+				b, err := parseHex(line[i : i+2])
+				if err != nil {
+					return err
+				}
+				rom[layout.addr+y*layout.rowSize+i/2] = byte(b)
+			}
+			return nil
+		}); err != nil {
+			return
+		}
+	}
+
+	if err = c.readSectionLines(Section_Music, 64, func(n int, line string) error {
+		if len(line) < 11 {
+			return fmt.Errorf("music pattern %d is too short", n)
+		}
+		flags, err := parseHex(line[0:2])
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 4; i++ {
+			ch, err := parseHex(line[3+i*2 : 5+i*2])
+			if err != nil {
+				return err
+			}
+			rom[romMusic+n*4+i] = byte(ch&0x7f | (flags>>i&1)<<7)
+		}
+		return nil
+	}); err != nil {
+		return
+	}
+
+	if err = c.readSectionLines(Section_Sfx, 64, func(n int, line string) error {
+		if len(line) < 168 {
+			return fmt.Errorf("sfx %d is too short", n)
+		}
+		base := romSfx + n*sfxSize
+		for i := 0; i < 4; i++ {
+			b, err := parseHex(line[i*2 : i*2+2])
+			if err != nil {
+				return err
+			}
+			rom[base+64+i] = byte(b)
+		}
+		for i := 0; i < 32; i++ {
+			note := line[8+i*5 : 13+i*5]
+			pitch, err := parseHex(note[0:2])
+			if err != nil {
+				return err
+			}
+			wave, err := parseHex(note[2:3])
+			if err != nil {
+				return err
+			}
+			volume, err := parseHex(note[3:4])
+			if err != nil {
+				return err
+			}
+			effect, err := parseHex(note[4:5])
+			if err != nil {
+				return err
+			}
+			value := pitch&0x3f | (wave&7)<<6 | (volume&7)<<9 | (effect&7)<<12 | (wave>>3&1)<<15
+			rom[base+i*2] = byte(value)
+			rom[base+i*2+1] = byte(value >> 8)
+		}
+		return nil
+	}); err != nil {
+		return
+	}
+
+	return rom, nil
+}
+
+// readSectionLines calls fn for each of the first maxRows lines of the named section.
+func (c *Cart) readSectionLines(name string, maxRows int, fn func(row int, line string) error) (err error) {
+	body, ok := c.Section(name)
+	if !ok {
+		return nil
+	}
+
+	for row, line := range strings.Split(strings.TrimRight(body, "\r\n"), "\n") {
+		if row >= maxRows {
+			break
+		}
+		if err = fn(row, strings.TrimSpace(line)); err != nil {
+			err = fmt.Errorf("failed to read %s line %d: %w", name, row+1, err)
+			return
+		}
+	}
+	return nil
+}
+
+// setROMData replaces the asset sections of the cart with the contents of rom.
+func (c *Cart) setROMData(rom []byte) {
+	c.setSectionRows(Section_Gfx, 128, func(y int) (line string, empty bool) {
+		var sb strings.Builder
+		empty = true
+		for x := 0; x < 64; x++ {
+			b := rom[romGfx+y*64+x]
+			fmt.Fprintf(&sb, "%x%x", b&0xf, b>>4)
+			empty = empty && b == 0
+		}
+		return sb.String(), empty
+	})
+
+	for _, layout := range hexLayouts {
+		c.setSectionRows(layout.name, layout.rows, func(y int) (line string, empty bool) {
+			row := rom[layout.addr+y*layout.rowSize : layout.addr+(y+1)*layout.rowSize]
+			return fmt.Sprintf("%x", row), isZero(row)
+		})
+	}
+
+	c.setSectionRows(Section_Sfx, 64, func(n int) (line string, empty bool) {
+		sfx := rom[romSfx+n*sfxSize : romSfx+(n+1)*sfxSize]
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "%02x", sfx[64:68])
+		for i := 0; i < 32; i++ {
+			value := int(sfx[i*2]) | int(sfx[i*2+1])<<8
+			wave := value>>6&7 | (value>>15&1)<<3
+			fmt.Fprintf(&sb, "%02x%x%x%x", value&0x3f, wave, value>>9&7, value>>12&7)
+		}
+		return sb.String(), isZero(sfx)
+	})
+
+	c.setSectionRows(Section_Music, 64, func(n int) (line string, empty bool) {
+		pattern := rom[romMusic+n*4 : romMusic+(n+1)*4]
+		flags := 0
+		channels := make([]byte, 4)
+		for i, b := range pattern {
+			flags |= int(b>>7) << i
+			channels[i] = b & 0x7f
+		}
+		return fmt.Sprintf("%02x %02x", flags, channels), isZero(pattern)
+	})
+}
+
+// setSectionRows rebuilds a section from rows produced by fn, dropping trailing empty
+// rows. The section is removed entirely when every row is empty.
+func (c *Cart) setSectionRows(name string, rows int, fn func(row int) (line string, empty bool)) {
+	lines := make([]string, rows)
+	last := -1
+	for row := 0; row < rows; row++ {
+		var empty bool
+		lines[row], empty = fn(row)
+		if !empty {
+			last = row
+		}
+	}
+
+	if last < 0 {
+		c.RemoveSection(name)
+		return
+	}
+	c.SetSection(name, strings.Join(lines[:last+1], "\n")+"\n")
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func parseHex(s string) (int, error) {
+	v, err := strconv.ParseUint(s, 16, 8)
+	return int(v), err
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"pixie/compiler"
+	"sort"
+	"text/tabwriter"
+)
+
+// What to do when generated code is over PICO-8's limits.
+const (
+	limits_Error = "error" // limits_Error fails the build
+	limits_Warn  = "warn"  // limits_Warn writes the output and prints a warning
+	limits_Off   = "off"   // limits_Off skips measuring the code altogether
+)
+
+var (
+	errOverBudget = errors.New("over PICO-8 budget") // errOverBudget is returned when code is over a limit and limits are limits_Error
+)
+
+func validLimits(limits string) bool {
+	switch limits {
+	case limits_Error, limits_Warn, limits_Off:
+		return true
+	}
+	return false
+}
+
+// printBudget writes a budget report for the named input: the totals, then the cost of
+// each top-level statement, most expensive first.
+func printBudget(w io.Writer, name string, budget compiler.Budget) {
+	fmt.Fprintf(w, "%s: %s\n", name, budget)
+
+	decls := make([]compiler.DeclBudget, len(budget.Decls))
+	copy(decls, budget.Decls)
+	sort.SliceStable(decls, func(i, j int) bool {
+		return decls[i].Tokens > decls[j].Tokens
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "\ttokens\tchars\t")
+	for _, decl := range decls {
+		fmt.Fprintf(tw, "\t%d\t%d\t  %s (%s)\n", decl.Tokens, decl.Chars, decl.Name, decl.Pos)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"pixie/cartridge"
+	"pixie/compiler"
+	"pixie/lexer"
+	"pixie/parser"
+	"pixie/sourcemap"
+	"sort"
+	"strings"
+)
+
+const (
+	sourceExt = ".pixie" // sourceExt is the file extension of pixie source files
+	stdio     = "-"      // stdio is the path used to mean stdin for inputs and stdout for outputs
+)
+
+// Output formats supported by the build command.
+const (
+	format_Lua = "lua" // format_Lua writes the bare generated Lua
+	format_P8  = "p8"  // format_P8 writes a .p8 text cartridge
+	format_PNG = "png" // format_PNG writes a .p8.png image cartridge
+)
+
+var (
+	// formatExt maps each output format to the file extension it is written with.
+	formatExt = map[string]string{
+		format_Lua: ".lua",
+		format_P8:  ".p8",
+		format_PNG: ".p8.png",
+	}
+)
+
+// buildJob describes a single source to compile and where its output goes.
+type buildJob struct {
+	input  string // Path of the pixie source, or stdio to read from stdin
+	output string // Path of the generated file, or stdio to write to stdout
+	format string // The output format, one of the format_* constants
+	limits string // What to do when the code is over PICO-8's limits, one of the limits_* constants
+	srcMap bool   // Whether to write a source map next to the output
+	budget bool   // Whether to measure the budget even when limits are off
+}
+
+func runBuild(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("build", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	output := fs.String("o", "", "write output to `path`; a directory when building several files, \"-\" for stdout")
+	format := fs.String("format", "", "output `format`, \"lua\", \"p8\" or \"png\"; defaults to the extension of -o, then \"lua\"")
+	limits := fs.String("limits", limits_Error, "what to do when the code is over PICO-8's limits: \"error\", \"warn\" or \"off\"")
+	report := fs.Bool("budget", false, "print the token, character and compressed size budget of each file")
+	srcMap := fs.Bool("map", false, "write a source map next to each output, for use with \"pixie trace\"")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: pixie build [-o path] [-format lua|p8|png] [-limits error|warn|off] [-budget] [-map] [file.pixie | dir | -] ...")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Build compiles pixie files into PICO-8 Lua. With no inputs, or an input of \"-\",")
+		fmt.Fprintln(stderr, "the source is read from stdin. A single file is written to stdout unless -o is")
+		fmt.Fprintln(stderr, "given; directories and multiple files are written next to their sources, or")
+		fmt.Fprintln(stderr, "into the directory named by -o.")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "The p8 format writes a complete .p8 cartridge. When the output cartridge already")
+		fmt.Fprintln(stderr, "exists only its __lua__ section is replaced; graphics, map, sound and label")
+		fmt.Fprintln(stderr, "sections are kept exactly as they were. The png format does the same for .p8.png")
+		fmt.Fprintln(stderr, "cartridges, keeping the existing cart's picture.")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "The generated code is checked against PICO-8's token, character and compressed")
+		fmt.Fprintln(stderr, "size limits. -budget prints how much of each limit is used, broken down by")
+		fmt.Fprintln(stderr, "top-level statement.")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "-map writes a source map named after the output with "+sourcemap.Ext+" appended,")
+		fmt.Fprintln(stderr, "mapping every Lua line back to the pixie source it came from.")
+		fmt.Fprintln(stderr)
+		fs.PrintDefaults()
+	}
+
+	inputs, err := parseInterspersed(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		return exitUsage
+	}
+
+	if !validLimits(*limits) {
+		fmt.Fprintf(stderr, "pixie build: unknown limits mode %q\n", *limits)
+		return exitUsage
+	}
+
+	jobs, err := planBuild(inputs, *output, *format)
+	if err != nil {
+		fmt.Fprintf(stderr, "pixie build: %s\n", err)
+		return exitUsage
+	}
+
+	if *srcMap {
+		for _, job := range jobs {
+			if job.output == stdio {
+				fmt.Fprintln(stderr, "pixie build: -map needs an output file")
+				return exitUsage
+			}
+		}
+	}
+
+	code := exitOK
+	for _, job := range jobs {
+		job.limits = *limits
+		job.srcMap = *srcMap
+		job.budget = *report
+		_, budget, err := build(job, stdin, stdout)
+		if err != nil {
+			fmt.Fprintln(stderr, errorMessage(job.input, err))
+			code = exitError
+			continue
+		}
+
+		if *report {
+			printBudget(stderr, displayName(job.input), budget)
+		}
+		if job.limits == limits_Warn {
+			for _, exceeded := range budget.Exceeded() {
+				fmt.Fprintf(stderr, "%s: warning: over budget: %s\n", displayName(job.input), exceeded)
+			}
+		}
+	}
+	return code
+}
+
+// planBuild expands the command line inputs into build jobs. Directories are expanded to
+// the pixie files they directly contain, in lexical order.
+func planBuild(inputs []string, output, format string) (jobs []buildJob, err error) {
+	if len(inputs) == 0 {
+		inputs = []string{stdio}
+	}
+
+	if format == "" {
+		format = formatFromPath(output)
+	}
+	if _, ok := formatExt[format]; !ok {
+		err = fmt.Errorf("unknown output format %q", format)
+		return
+	}
+
+	var sources []string
+	multiple := len(inputs) > 1
+	for _, input := range inputs {
+		if input == stdio {
+			if len(inputs) > 1 {
+				err = fmt.Errorf("stdin cannot be combined with other inputs")
+				return
+			}
+			sources = append(sources, input)
+			continue
+		}
+
+		var info os.FileInfo
+		info, err = os.Stat(input)
+		if err != nil {
+			return
+		}
+
+		if !info.IsDir() {
+			sources = append(sources, input)
+			continue
+		}
+
+		multiple = true
+		var files []string
+		files, err = sourceFiles(input)
+		if err != nil {
+			return
+		}
+		if len(files) == 0 {
+			err = fmt.Errorf("no %s files in %s", sourceExt, input)
+			return
+		}
+		sources = append(sources, files...)
+	}
+
+	for _, source := range sources {
+		job := buildJob{input: source, format: format}
+		switch {
+		case !multiple && output == "":
+			job.output = stdio
+		case !multiple:
+			job.output = output
+		case output == stdio:
+			err = fmt.Errorf("cannot write several files to stdout")
+			return
+		case output == "":
+			job.output = outputName(source, format)
+		default:
+			job.output = filepath.Join(output, filepath.Base(outputName(source, format)))
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// sourceFiles returns the pixie files directly inside dir, sorted by name.
+func sourceFiles(dir string) (files []string, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != sourceExt {
+			continue
+		}
+		files = append(files, filepath.Join(dir, entry.Name()))
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// outputName returns the default output path for a source file: the same path with the
+// pixie extension replaced by the one for the output format.
+func outputName(source, format string) string {
+	return strings.TrimSuffix(source, filepath.Ext(source)) + formatExt[format]
+}
+
+// formatFromPath infers the output format from an output path's extension.
+func formatFromPath(path string) string {
+	for format, ext := range formatExt {
+		if strings.HasSuffix(path, ext) {
+			return format
+		}
+	}
+	return format_Lua
+}
+
+// build compiles a single job and writes the generated Lua to its destination. It returns
+// the source files the build read, even when it fails, so callers can watch them, and the
+// budget of the generated code, which is only measured when limits are checked or
+// job.budget is set. When the job's limits are limits_Error, code over budget
+// is not written.
+func build(job buildJob, stdin io.Reader, stdout io.Writer) (files []string, budget compiler.Budget, err error) {
+	var src []byte
+	if job.input == stdio {
+		src, err = io.ReadAll(stdin)
+	} else {
+		files = append(files, job.input)
+		src, err = os.ReadFile(job.input)
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to read source: %w", err)
+		return
+	}
+
+	name := job.input
+	if name == stdio {
+		name = ""
+	}
+	program, err := compileSource(name, string(src))
+	files = append(files, program.Files...)
+	if err != nil {
+		return
+	}
+	lua := program.Lua
+
+	if job.limits != limits_Off || job.budget {
+		budget, err = compiler.MeasureBudget(program)
+		if err != nil {
+			err = fmt.Errorf("failed to measure budget: %w", err)
+			return
+		}
+	}
+	if job.limits == limits_Error {
+		if exceeded := budget.Exceeded(); len(exceeded) > 0 {
+			err = fmt.Errorf("%w: %s", errOverBudget, strings.Join(exceeded, "; "))
+			return
+		}
+	}
+
+	out := []byte(lua)
+	if job.format != format_Lua {
+		out, err = buildCart(job.output, job.format, lua)
+		if err != nil {
+			return
+		}
+	}
+
+	if job.output == stdio {
+		_, err = stdout.Write(out)
+		return
+	}
+
+	if err = writeFileAtomic(job.output, out); err != nil {
+		err = fmt.Errorf("failed to write output: %w", err)
+		return
+	}
+
+	if job.srcMap {
+		if err = writeSourceMap(job.output, program); err != nil {
+			err = fmt.Errorf("failed to write source map: %w", err)
+			return
+		}
+	}
+	return files, budget, nil
+}
+
+// writeSourceMap writes the source map of the Lua generated at output next to it. Source
+// paths are stored relative to the map, so the output and its map can be moved together.
+func writeSourceMap(output string, program compiler.Program) (err error) {
+	dir, err := filepath.Abs(filepath.Dir(output))
+	if err != nil {
+		return
+	}
+
+	relative := make(map[string]string)
+	mappings := make([]sourcemap.Mapping, len(program.Mappings))
+	for i, mapping := range program.Mappings {
+		file := mapping.Source.File
+		if _, ok := relative[file]; !ok {
+			relative[file] = file
+			if abs, absErr := filepath.Abs(file); absErr == nil && file != "" {
+				if rel, relErr := filepath.Rel(dir, abs); relErr == nil {
+					relative[file] = filepath.ToSlash(rel)
+				}
+			}
+		}
+		mapping.Source.File = relative[file]
+		mappings[i] = mapping
+	}
+
+	m := sourcemap.New(filepath.Base(output), program.Lua, mappings)
+	return writeFileAtomic(output+sourcemap.Ext, m.Bytes())
+}
+
+// writeFileAtomic writes data to path by writing a temporary file next to it and renaming
+// it into place, so a program reading path (such as PICO-8 reloading a cart) never sees a
+// partially written file.
+func writeFileAtomic(path string, data []byte) (err error) {
+	dir := filepath.Dir(path)
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	if err = os.Chmod(tmp.Name(), 0o644); err != nil {
+		return
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// buildCart returns the cartridge to write at path for the given Lua, in either the
+// .p8 or .p8.png format. If a cart already exists at path only its code is replaced.
+func buildCart(path, format, lua string) (out []byte, err error) {
+	cart, err := loadCart(path, format)
+	if err != nil {
+		return
+	}
+
+	cart.SetLua(lua)
+	if format == format_P8 {
+		return cart.Bytes(), nil
+	}
+
+	var buf bytes.Buffer
+	if err = cart.WritePNG(&buf); err != nil {
+		err = fmt.Errorf("failed to write cartridge: %w", err)
+		return
+	}
+	return buf.Bytes(), nil
+}
+
+// loadCart reads the cart at path, or returns a new empty cart when there is none.
+func loadCart(path, format string) (cart *cartridge.Cart, err error) {
+	if path == stdio {
+		return cartridge.New(), nil
+	}
+
+	src, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cartridge.New(), nil
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to read cartridge: %w", err)
+		return
+	}
+
+	if format == format_PNG {
+		cart, err = cartridge.ReadPNG(bytes.NewReader(src))
+	} else {
+		cart, err = cartridge.Parse(src)
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to parse cartridge: %w", err)
+		return
+	}
+	return cart, nil
+}
+
+// compileSource runs the lexer, parser and compiler over src, the contents of the named
+// file, and returns the compiled program. The program lists the files src imports even
+// when compilation fails. Parse errors are a parser.ErrorList and compile errors are
+// *lexer.Error values.
+func compileSource(name, src string) (program compiler.Program, err error) {
+	l := lexer.NewFile(name, src)
+	p := parser.New(l)
+	node, err := p.Parse()
+	if err != nil {
+		return
+	}
+	return compiler.CompileProgram(node)
+}
+
+// errorMessage formats an error that happened while processing input. Errors at a
+// position in the source are written as file:line:column: message, like other compilers,
+// with each error of a parser.ErrorList on a line of its own. Syntax errors in an imported
+// file are reported on their own, as they carry the file they are in.
+func errorMessage(input string, err error) string {
+	var errList parser.ErrorList
+	if errors.As(err, &errList) {
+		messages := make([]string, len(errList))
+		for i, posErr := range errList {
+			messages[i] = errorMessage(input, posErr)
+		}
+		return strings.Join(messages, "\n")
+	}
+	if posErr, ok := err.(*lexer.Error); ok {
+		if posErr.Pos.File == "" {
+			return displayName(input) + ":" + posErr.Error()
+		}
+		return posErr.Error()
+	}
+	return displayName(input) + ": " + err.Error()
+}
+
+// displayName returns the name used for an input in diagnostics.
+func displayName(input string) string {
+	if input == stdio {
+		return "<stdin>"
+	}
+	return input
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"pixie/cartridge"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+}
+
+func Test_Build_StdinToStdout(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"build"}, strings.NewReader(`print("hello")`), &stdout, &stderr)
+
+	require.Equal(t, exitOK, code, stderr.String())
+	require.Equal(t, "print(\"hello\")\n", stdout.String())
+}
+
+func Test_Build_FileToOutput(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.pixie")
+	out := filepath.Join(dir, "out.lua")
+	writeFile(t, in, "x num = 1")
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"build", in, "-o", out}, nil, &stdout, &stderr)
+	require.Equal(t, exitOK, code, stderr.String())
+	require.Empty(t, stdout.String())
+
+	lua, err := os.ReadFile(out)
+	require.NoError(t, err)
+	require.Equal(t, "x = 1\n", string(lua))
+}
+
+func Test_Build_Directory(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeFile(t, filepath.Join(src, "a.pixie"), "a num = 1")
+	writeFile(t, filepath.Join(src, "b.pixie"), "b str = \"b\"")
+	writeFile(t, filepath.Join(src, "notes.txt"), "not pixie")
+
+	t.Run("alongside_sources", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"build", src}, nil, &stdout, &stderr)
+		require.Equal(t, exitOK, code, stderr.String())
+		require.FileExists(t, filepath.Join(src, "a.lua"))
+		require.FileExists(t, filepath.Join(src, "b.lua"))
+		require.NoFileExists(t, filepath.Join(src, "notes.lua"))
+	})
+
+	t.Run("output_directory", func(t *testing.T) {
+		out := filepath.Join(dir, "out")
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"build", "-o", out, src}, nil, &stdout, &stderr)
+		require.Equal(t, exitOK, code, stderr.String())
+
+		lua, err := os.ReadFile(filepath.Join(out, "b.lua"))
+		require.NoError(t, err)
+		require.Equal(t, "b = \"b\"\n", string(lua))
+	})
+}
+
+func Test_Build_ExitCodes(t *testing.T) {
+	t.Run("compile_error", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"build"}, strings.NewReader("s str = \"a\"\ns = 1"), &stdout, &stderr)
+		require.Equal(t, exitError, code)
+		require.True(t, strings.HasPrefix(stderr.String(), "<stdin>:2:1: "), stderr.String())
+	})
+
+	t.Run("syntax_errors", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"build"}, strings.NewReader("print(1,,)\nx num = 1\nif x > {\n\tprint(x\n}\nprint("), &stdout, &stderr)
+		require.Equal(t, exitError, code)
+		require.Equal(t, "<stdin>:1:9: expected expression, got \"Comma\"\n"+
+			"<stdin>:5:1: unexpected token \"CloseBrace\"\n"+
+			"<stdin>:6:7: unexpected end of file\n", stderr.String())
+	})
+
+	t.Run("missing_input", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"build", filepath.Join(t.TempDir(), "missing.pixie")}, nil, &stdout, &stderr)
+		require.Equal(t, exitUsage, code)
+	})
+
+	t.Run("unknown_command", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"frobnicate"}, nil, &stdout, &stderr)
+		require.Equal(t, exitUsage, code)
+	})
+
+	t.Run("continues_after_error", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "bad.pixie"), "s str = \"a\"\ns = 1")
+		writeFile(t, filepath.Join(dir, "good.pixie"), "n num = 1")
+
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"build", dir}, nil, &stdout, &stderr)
+		require.Equal(t, exitError, code)
+		require.True(t, strings.HasPrefix(stderr.String(), filepath.Join(dir, "bad.pixie")+":2:1: "), stderr.String())
+		require.FileExists(t, filepath.Join(dir, "good.lua"))
+		require.NoFileExists(t, filepath.Join(dir, "bad.lua"))
+	})
+}
+
+func Test_Build_Budget(t *testing.T) {
+	overBudget := strings.Repeat("print(1)\n", 3000)
+
+	t.Run("report", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"build", "-budget"}, strings.NewReader("x num = 1\nprint(x)"), &stdout, &stderr)
+		require.Equal(t, exitOK, code, stderr.String())
+		require.Contains(t, stderr.String(), "<stdin>: tokens 6/8192, chars 15/65535, compressed ")
+		require.Contains(t, stderr.String(), "var x")
+		require.Contains(t, stderr.String(), "call print")
+	})
+
+	t.Run("over_limit_fails", func(t *testing.T) {
+		dir := t.TempDir()
+		out := filepath.Join(dir, "out.lua")
+
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"build", "-o", out}, strings.NewReader(overBudget), &stdout, &stderr)
+		require.Equal(t, exitError, code)
+		require.Contains(t, stderr.String(), "9000 tokens, limit is 8192")
+		require.NoFileExists(t, out)
+	})
+
+	t.Run("over_limit_warns", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"build", "-limits", "warn"}, strings.NewReader(overBudget), &stdout, &stderr)
+		require.Equal(t, exitOK, code)
+		require.Contains(t, stderr.String(), "warning: over budget: 9000 tokens")
+		require.NotEmpty(t, stdout.String())
+	})
+
+	t.Run("limits_off", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"build", "-limits", "off"}, strings.NewReader(overBudget), &stdout, &stderr)
+		require.Equal(t, exitOK, code)
+		require.Empty(t, stderr.String())
+	})
+
+	t.Run("report_with_limits_off", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"build", "-budget", "-limits", "off"}, strings.NewReader(overBudget), &stdout, &stderr)
+		require.Equal(t, exitOK, code, stderr.String())
+		require.Contains(t, stderr.String(), "<stdin>: tokens 9000/8192")
+		require.Contains(t, stderr.String(), "call print")
+		require.NotContains(t, stderr.String(), "warning")
+	})
+
+	t.Run("unknown_mode", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"build", "-limits", "sometimes"}, strings.NewReader("print(1)"), &stdout, &stderr)
+		require.Equal(t, exitUsage, code)
+	})
+}
+
+func Test_Build_Cartridge(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "game.pixie")
+	writeFile(t, in, "print(1)")
+
+	t.Run("new_cart", func(t *testing.T) {
+		out := filepath.Join(dir, "new.p8")
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"build", in, "-o", out}, nil, &stdout, &stderr)
+		require.Equal(t, exitOK, code, stderr.String())
+
+		cart, err := os.ReadFile(out)
+		require.NoError(t, err)
+		require.Equal(t, "pico-8 cartridge // http://www.pico-8.com\nversion 42\n__lua__\nprint(1)\n", string(cart))
+	})
+
+	t.Run("existing_cart", func(t *testing.T) {
+		out := filepath.Join(dir, "existing.p8")
+		writeFile(t, out, "pico-8 cartridge // http://www.pico-8.com\nversion 41\n__lua__\nprint(0)\n__gfx__\n0770\n__sfx__\n0001\n")
+
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"build", in, "-o", out}, nil, &stdout, &stderr)
+		require.Equal(t, exitOK, code, stderr.String())
+
+		cart, err := os.ReadFile(out)
+		require.NoError(t, err)
+		require.Equal(t, "pico-8 cartridge // http://www.pico-8.com\nversion 41\n__lua__\nprint(1)\n__gfx__\n0770\n__sfx__\n0001\n", string(cart))
+	})
+
+	t.Run("stdout", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"build", "-format", "p8"}, strings.NewReader("print(2)"), &stdout, &stderr)
+		require.Equal(t, exitOK, code, stderr.String())
+		require.Equal(t, "pico-8 cartridge // http://www.pico-8.com\nversion 42\n__lua__\nprint(2)\n", stdout.String())
+	})
+
+	t.Run("unknown_format", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"build", "-format", "p9", in}, nil, &stdout, &stderr)
+		require.Equal(t, exitUsage, code)
+	})
+}
+
+func Test_Build_PNGCartridge(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "game.pixie")
+	out := filepath.Join(dir, "game.p8.png")
+
+	writeFile(t, in, "print(1)")
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"build", in, "-o", out}, nil, &stdout, &stderr)
+	require.Equal(t, exitOK, code, stderr.String())
+
+	f, err := os.Open(out)
+	require.NoError(t, err)
+	cart, err := cartridge.ReadPNG(f)
+	f.Close()
+	require.NoError(t, err)
+	require.Equal(t, "print(1)\n", cart.Lua())
+
+	// Rebuilding into the same cart replaces only the code
+	cart.SetSection(cartridge.Section_Gfx, strings.Repeat("7", 128)+"\n")
+	var buf bytes.Buffer
+	require.NoError(t, cart.WritePNG(&buf))
+	require.NoError(t, os.WriteFile(out, buf.Bytes(), 0o644))
+
+	writeFile(t, in, "print(2)")
+	code = run([]string{"build", in, "-o", out}, nil, &stdout, &stderr)
+	require.Equal(t, exitOK, code, stderr.String())
+
+	f, err = os.Open(out)
+	require.NoError(t, err)
+	cart, err = cartridge.ReadPNG(f)
+	f.Close()
+	require.NoError(t, err)
+	require.Equal(t, "print(2)\n", cart.Lua())
+	gfx, ok := cart.Section(cartridge.Section_Gfx)
+	require.True(t, ok)
+	require.Equal(t, strings.Repeat("7", 128)+"\n", gfx)
+}
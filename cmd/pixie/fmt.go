@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"pixie/format"
+)
+
+func runFmt(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	check := fs.Bool("check", false, "list files that are not formatted instead of rewriting them, and exit 1 if there are any")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: pixie fmt [-check] [file.pixie | dir | -] ...")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Fmt rewrites pixie files in the canonical layout: one statement per line, single")
+		fmt.Fprintln(stderr, "spaces around operators, and indented obj fields and multi-line list and map")
+		fmt.Fprintln(stderr, "literals. Comments are kept. With no inputs, or an input of \"-\", the source is")
+		fmt.Fprintln(stderr, "read from stdin and the formatted source is written to stdout.")
+		fmt.Fprintln(stderr)
+		fs.PrintDefaults()
+	}
+
+	inputs, err := parseInterspersed(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		return exitUsage
+	}
+	if len(inputs) == 0 {
+		inputs = []string{stdio}
+	}
+
+	var files []string
+	for _, input := range inputs {
+		if input == stdio {
+			files = append(files, stdio)
+			continue
+		}
+
+		info, err := os.Stat(input)
+		if err != nil {
+			fmt.Fprintf(stderr, "pixie fmt: %s\n", err)
+			return exitUsage
+		}
+		if !info.IsDir() {
+			files = append(files, input)
+			continue
+		}
+
+		dirFiles, err := sourceFiles(input)
+		if err != nil {
+			fmt.Fprintf(stderr, "pixie fmt: %s\n", err)
+			return exitUsage
+		}
+		files = append(files, dirFiles...)
+	}
+
+	code := exitOK
+	for _, file := range files {
+		formatted, err := formatFile(file, *check, stdin, stdout)
+		if err != nil {
+			fmt.Fprintln(stderr, errorMessage(file, err))
+			code = exitError
+			continue
+		}
+		if *check && !formatted {
+			fmt.Fprintln(stdout, displayName(file))
+			code = exitError
+		}
+	}
+	return code
+}
+
+// formatFile formats a single source and reports whether it was already formatted. Files
+// are rewritten in place when they change, and stdin is written to stdout. In check mode
+// nothing is written.
+func formatFile(file string, check bool, stdin io.Reader, stdout io.Writer) (formatted bool, err error) {
+	var src []byte
+	if file == stdio {
+		src, err = io.ReadAll(stdin)
+	} else {
+		src, err = os.ReadFile(file)
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to read source: %w", err)
+		return
+	}
+
+	out, err := format.Source(src)
+	if err != nil {
+		return
+	}
+
+	formatted = bytes.Equal(src, out)
+	if check {
+		return formatted, nil
+	}
+
+	if file == stdio {
+		_, err = stdout.Write(out)
+		return
+	}
+	if formatted {
+		return true, nil
+	}
+	if err = writeFileAtomic(file, out); err != nil {
+		err = fmt.Errorf("failed to write source: %w", err)
+	}
+	return
+}
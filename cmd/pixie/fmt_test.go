@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Fmt(t *testing.T) {
+	const (
+		unformatted = "x num=1+2 // one\nprint( x )"
+		formatted   = "x num = 1 + 2 // one\nprint(x)\n"
+	)
+
+	t.Run("stdin_to_stdout", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"fmt"}, strings.NewReader(unformatted), &stdout, &stderr)
+		require.Equal(t, exitOK, code, stderr.String())
+		require.Equal(t, formatted, stdout.String())
+	})
+
+	t.Run("rewrite_directory", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "a.pixie"), unformatted)
+		writeFile(t, filepath.Join(dir, "b.pixie"), formatted)
+
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"fmt", dir}, nil, &stdout, &stderr)
+		require.Equal(t, exitOK, code, stderr.String())
+
+		for _, name := range []string{"a.pixie", "b.pixie"} {
+			src, err := os.ReadFile(filepath.Join(dir, name))
+			require.NoError(t, err)
+			require.Equal(t, formatted, string(src), name)
+		}
+	})
+
+	t.Run("check", func(t *testing.T) {
+		dir := t.TempDir()
+		a := filepath.Join(dir, "a.pixie")
+		b := filepath.Join(dir, "b.pixie")
+		writeFile(t, a, unformatted)
+		writeFile(t, b, formatted)
+
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"fmt", "--check", a, b}, nil, &stdout, &stderr)
+		require.Equal(t, exitError, code, stderr.String())
+		require.Equal(t, a+"\n", stdout.String())
+
+		src, err := os.ReadFile(a)
+		require.NoError(t, err)
+		require.Equal(t, unformatted, string(src), "check must not rewrite files")
+
+		stdout.Reset()
+		code = run([]string{"fmt", "-check", b}, nil, &stdout, &stderr)
+		require.Equal(t, exitOK, code, stderr.String())
+		require.Empty(t, stdout.String())
+	})
+
+	t.Run("parse_error", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"fmt"}, strings.NewReader("x num ="), &stdout, &stderr)
+		require.Equal(t, exitError, code)
+		require.True(t, strings.HasPrefix(stderr.String(), "<stdin>:1:8: "), stderr.String())
+	})
+}
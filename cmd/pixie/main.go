@@ -0,0 +1,84 @@
+// Command pixie is the command-line driver for the pixie programming language.
+// It wires the lexer, parser and compiler together so pixie source files can be
+// turned into PICO-8 Lua without writing any Go glue.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Exit codes returned by the pixie command.
+const (
+	exitOK    = 0 // exitOK is returned when every requested file compiled successfully
+	exitError = 1 // exitError is returned when at least one file failed to compile or could not be written
+	exitUsage = 2 // exitUsage is returned when the command line itself is invalid
+)
+
+const usage = `pixie is a tool for compiling pixie programs into PICO-8 Lua.
+
+Usage:
+
+	pixie <command> [arguments]
+
+The commands are:
+
+	build    compile pixie files into Lua
+	fmt      format pixie source files
+	watch    rebuild a cartridge every time its source changes
+	trace    translate a PICO-8 error message into pixie source locations
+
+Use "pixie <command> -h" for more information about a command.
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the pixie command described by args and returns the process exit code.
+// It is separated from main so the command can be exercised from tests.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "build":
+		return runBuild(args[1:], stdin, stdout, stderr)
+	case "fmt":
+		return runFmt(args[1:], stdin, stdout, stderr)
+	case "watch":
+		return runWatch(args[1:], stdout, stderr)
+	case "trace":
+		return runTrace(args[1:], stdin, stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "pixie: unknown command %q\n", args[0])
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+}
+
+// parseInterspersed parses flags that may appear before, between or after positional
+// arguments, so both "pixie build -o out.lua in.pixie" and "pixie build in.pixie -o out.lua"
+// work. It returns the positional arguments in the order they were given.
+func parseInterspersed(fs *flag.FlagSet, args []string) (positional []string, err error) {
+	for {
+		if err = fs.Parse(args); err != nil {
+			return nil, err
+		}
+
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"pixie/sourcemap"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// luaLineRef matches the line references in PICO-8 error messages, such as
+	// "line 12 tab 0" in "runtime error line 12 tab 0" and "line 11 (tab 0)" in a traceback.
+	luaLineRef = regexp.MustCompile(`line (\d+)(?:\s*\(?tab (\d+)\)?)?`)
+)
+
+func runTrace(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("trace", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: pixie trace cart [message ...]")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Trace translates the Lua line numbers in a PICO-8 error message into the pixie")
+		fmt.Fprintln(stderr, "source locations they were compiled from. cart is a file built with -map, or its")
+		fmt.Fprintln(stderr, sourcemap.Ext+" file. The message is read from stdin when it is not given as arguments.")
+		fmt.Fprintln(stderr)
+		fs.PrintDefaults()
+	}
+
+	positional, err := parseInterspersed(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	mapPath := positional[0]
+	if !strings.HasSuffix(mapPath, sourcemap.Ext) {
+		mapPath += sourcemap.Ext
+	}
+
+	src, err := os.ReadFile(mapPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "pixie trace: %s\n", err)
+		return exitError
+	}
+	m, err := sourcemap.Parse(src)
+	if err != nil {
+		fmt.Fprintf(stderr, "pixie trace: %s: %s\n", mapPath, err)
+		return exitError
+	}
+
+	message := strings.Join(positional[1:], " ")
+	if len(positional) == 1 {
+		input, err := io.ReadAll(stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "pixie trace: failed to read message: %s\n", err)
+			return exitError
+		}
+		message = string(input)
+	}
+
+	traced, found := trace(m, filepath.Dir(mapPath), message)
+	fmt.Fprint(stdout, traced)
+	if !strings.HasSuffix(traced, "\n") {
+		fmt.Fprintln(stdout)
+	}
+	if !found {
+		fmt.Fprintln(stderr, "pixie trace: no line numbers in the message could be traced")
+		return exitError
+	}
+	return exitOK
+}
+
+// trace annotates every Lua line reference in message with the pixie location it maps
+// to. Source paths in the map are relative to dir. It reports whether any reference
+// could be traced.
+func trace(m *sourcemap.Map, dir, message string) (traced string, found bool) {
+	traced = luaLineRef.ReplaceAllStringFunc(message, func(ref string) string {
+		groups := luaLineRef.FindStringSubmatch(ref)
+		line, _ := strconv.Atoi(groups[1])
+		tab := 0
+		if groups[2] != "" {
+			tab, _ = strconv.Atoi(groups[2])
+		}
+
+		pos, ok := m.LookupTab(tab, line)
+		if !ok {
+			return ref
+		}
+		found = true
+
+		if pos.File != "" {
+			pos.File = filepath.Join(dir, filepath.FromSlash(pos.File))
+		}
+		return fmt.Sprintf("%s [%s]", ref, pos)
+	})
+	return traced, found
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Trace(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "src", "game.pixie")
+	out := filepath.Join(dir, "carts", "game.p8")
+	writeFile(t, in, "x num = 1\n\n// comment\ny num = x + 2\nprint(y)")
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"build", "-map", in, "-o", out}, nil, &stdout, &stderr)
+	require.Equal(t, exitOK, code, stderr.String())
+	require.FileExists(t, out+".map")
+
+	src, err := os.ReadFile(out + ".map")
+	require.NoError(t, err)
+	require.Contains(t, string(src), `"sources":["../src/game.pixie"]`)
+
+	t.Run("arguments", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"trace", out, "runtime error line 3 tab 0"}, nil, &stdout, &stderr)
+		require.Equal(t, exitOK, code, stderr.String())
+		require.Equal(t, "runtime error line 3 tab 0 ["+in+":5:1]\n", stdout.String())
+	})
+
+	t.Run("stdin", func(t *testing.T) {
+		message := "runtime error line 2 tab 0\nattempt to perform arithmetic\nat line 2 (tab 0)\n"
+
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"trace", out + ".map"}, strings.NewReader(message), &stdout, &stderr)
+		require.Equal(t, exitOK, code, stderr.String())
+		require.Equal(t, "runtime error line 2 tab 0 ["+in+":4:1]\nattempt to perform arithmetic\nat line 2 (tab 0) ["+in+":4:1]\n", stdout.String())
+	})
+
+	t.Run("nothing_to_trace", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"trace", out, "out of memory"}, nil, &stdout, &stderr)
+		require.Equal(t, exitError, code)
+		require.Equal(t, "out of memory\n", stdout.String())
+	})
+
+	t.Run("missing_map", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"trace", filepath.Join(dir, "missing.p8"), "line 1"}, nil, &stdout, &stderr)
+		require.Equal(t, exitError, code)
+	})
+
+	t.Run("map_needs_output_file", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"build", "-map"}, strings.NewReader("print(1)"), &stdout, &stderr)
+		require.Equal(t, exitUsage, code)
+	})
+}
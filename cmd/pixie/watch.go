@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+)
+
+const (
+	defaultWatchInterval = 250 * time.Millisecond // defaultWatchInterval is how often watched files are checked for changes
+	timeFormat           = "15:04:05"             // timeFormat is used to timestamp watch messages
+)
+
+// fileStamp identifies a version of a file. A missing file has the zero stamp.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func runWatch(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	output := fs.String("o", "", "write the cartridge to `path`; defaults to the entry file with the format's extension")
+	format := fs.String("format", "", "output `format`, \"lua\", \"p8\" or \"png\"; defaults to the extension of -o, then \"p8\"")
+	interval := fs.Duration("interval", defaultWatchInterval, "how often to check for changes")
+	limits := fs.String("limits", limits_Error, "what to do when the code is over PICO-8's limits: \"error\", \"warn\" or \"off\"")
+	srcMap := fs.Bool("map", false, "write a source map next to the output, for use with \"pixie trace\"")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: pixie watch [-o path] [-format lua|p8|png] [-interval duration] [-limits error|warn|off] [-map] entry.pixie")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Watch builds the entry file, then rebuilds it every time it or a file it depends")
+		fmt.Fprintln(stderr, "on changes. The output is replaced atomically, so a cart open in PICO-8 can be")
+		fmt.Fprintln(stderr, "reloaded with Ctrl+R after each save. Press Ctrl+C to stop.")
+		fmt.Fprintln(stderr)
+		fs.PrintDefaults()
+	}
+
+	inputs, err := parseInterspersed(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		return exitUsage
+	}
+
+	if len(inputs) != 1 || inputs[0] == stdio {
+		fmt.Fprintln(stderr, "pixie watch: expected a single entry file")
+		return exitUsage
+	}
+	if *interval <= 0 {
+		fmt.Fprintln(stderr, "pixie watch: interval must be positive")
+		return exitUsage
+	}
+	if !validLimits(*limits) {
+		fmt.Fprintf(stderr, "pixie watch: unknown limits mode %q\n", *limits)
+		return exitUsage
+	}
+
+	if info, err := os.Stat(inputs[0]); err != nil || info.IsDir() {
+		fmt.Fprintf(stderr, "pixie watch: %s is not a file\n", inputs[0])
+		return exitUsage
+	}
+
+	job := buildJob{input: inputs[0], output: *output, format: *format, limits: *limits, srcMap: *srcMap}
+	if job.format == "" {
+		job.format = format_P8
+		if job.output != "" {
+			job.format = formatFromPath(job.output)
+		}
+	}
+	if _, ok := formatExt[job.format]; !ok {
+		fmt.Fprintf(stderr, "pixie watch: unknown output format %q\n", job.format)
+		return exitUsage
+	}
+	if job.output == "" {
+		job.output = outputName(job.input, job.format)
+	}
+	if job.output == stdio {
+		fmt.Fprintln(stderr, "pixie watch: cannot write to stdout")
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	watch(ctx, job, *interval, stdout, stderr)
+	return exitOK
+}
+
+// watch builds job, then polls every file the build read and rebuilds whenever one of
+// them changes, until ctx is cancelled.
+func watch(ctx context.Context, job buildJob, interval time.Duration, stdout, stderr io.Writer) {
+	var stamps map[string]fileStamp
+	rebuild := func() {
+		files, budget, err := build(job, nil, nil)
+		now := time.Now().Format(timeFormat)
+		switch {
+		case err != nil:
+			fmt.Fprintf(stderr, "[%s] %s\n", now, errorMessage(job.input, err))
+		case job.limits == limits_Off:
+			fmt.Fprintf(stdout, "[%s] built %s\n", now, job.output)
+		default:
+			fmt.Fprintf(stdout, "[%s] built %s (%s)\n", now, job.output, budget)
+			for _, exceeded := range budget.Exceeded() {
+				fmt.Fprintf(stderr, "[%s] %s: warning: over budget: %s\n", now, job.input, exceeded)
+			}
+		}
+
+		// Keep watching the entry file even if the build failed before reading it.
+		if len(files) == 0 {
+			files = []string{job.input}
+		}
+		stamps = stampFiles(files)
+	}
+
+	rebuild()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if changed(stamps) {
+				rebuild()
+			}
+		}
+	}
+}
+
+// stampFiles records the current stamp of every file.
+func stampFiles(files []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp, len(files))
+	for _, file := range files {
+		stamps[file] = stampFile(file)
+	}
+	return stamps
+}
+
+func stampFile(file string) fileStamp {
+	info, err := os.Stat(file)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}
+}
+
+// changed reports whether any file differs from its recorded stamp.
+func changed(stamps map[string]fileStamp) bool {
+	for file, stamp := range stamps {
+		if stampFile(file) != stamp {
+			return true
+		}
+	}
+	return false
+}
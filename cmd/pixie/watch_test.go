@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is a bytes.Buffer that can be written by the watcher while a test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func Test_Watch(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "game.pixie")
+	out := filepath.Join(dir, "game.p8")
+	writeFile(t, in, "print(1)")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	var stdout, stderr syncBuffer
+	go func() {
+		watch(ctx, buildJob{input: in, output: out, format: format_P8}, 10*time.Millisecond, &stdout, &stderr)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	cartContains := func(lua string) func() bool {
+		return func() bool {
+			cart, err := os.ReadFile(out)
+			return err == nil && bytes.Contains(cart, []byte("__lua__\n"+lua))
+		}
+	}
+
+	require.Eventually(t, cartContains("print(1)\n"), time.Second, 5*time.Millisecond)
+
+	// A broken edit reports a diagnostic and leaves the last good cart in place
+	writeFile(t, in, "s str = \"a\"\ns = 1 ")
+	require.Eventually(t, func() bool { return stderr.String() != "" }, time.Second, 5*time.Millisecond)
+	require.True(t, cartContains("print(1)\n")())
+
+	writeFile(t, in, "print(2)")
+	require.Eventually(t, cartContains("print(2)\n"), time.Second, 5*time.Millisecond)
+	require.Contains(t, stdout.String(), "built "+out)
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+}
+
+func Test_Watch_Usage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	require.Equal(t, exitUsage, run([]string{"watch"}, nil, &stdout, &stderr))
+	require.Equal(t, exitUsage, run([]string{"watch", t.TempDir()}, nil, &stdout, &stderr))
+}
+
+func Test_Watch_Imports(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "game.pixie")
+	lib := filepath.Join(dir, "lib", "player.pixie")
+	out := filepath.Join(dir, "game.lua")
+	writeFile(t, in, "import \"lib/player.pixie\"\nprint(speed)")
+	writeFile(t, lib, "speed num = 1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	var stdout, stderr syncBuffer
+	go func() {
+		watch(ctx, buildJob{input: in, output: out, format: format_Lua}, 10*time.Millisecond, &stdout, &stderr)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	luaEquals := func(lua string) func() bool {
+		return func() bool {
+			src, err := os.ReadFile(out)
+			return err == nil && string(src) == lua
+		}
+	}
+
+	require.Eventually(t, luaEquals("speed = 1\nprint(speed)\n"), time.Second, 5*time.Millisecond)
+
+	// Editing only the imported file triggers a rebuild
+	writeFile(t, lib, "speed num = 2")
+	require.Eventually(t, luaEquals("speed = 2\nprint(speed)\n"), time.Second, 5*time.Millisecond)
+}
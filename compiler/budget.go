@@ -0,0 +1,319 @@
+package compiler
+
+import (
+	"fmt"
+	"pixie/cartridge"
+	"pixie/lexer"
+	"pixie/p8scii"
+	"pixie/parser"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits PICO-8 places on the code of a cartridge.
+const (
+	TokenLimit      = 8192                // TokenLimit is the maximum number of code tokens
+	CharLimit       = 65535               // CharLimit is the maximum number of code characters
+	CompressedLimit = cartridge.CodeLimit // CompressedLimit is the maximum size of the compressed code in bytes
+)
+
+// Budget is how much of PICO-8's code limits a program uses.
+type Budget struct {
+	Tokens     int          // Tokens is the number of tokens, counted the way PICO-8 counts them
+	Chars      int          // Chars is the number of P8SCII characters
+	Compressed int          // Compressed is the size of the code once compressed into a .p8.png, in bytes
+	Decls      []DeclBudget // Decls breaks Tokens and Chars down per top-level statement
+}
+
+// DeclBudget is the part of a Budget used by a single top-level statement.
+type DeclBudget struct {
+	Name   string
+	Pos    lexer.Position
+	Tokens int
+	Chars  int
+}
+
+// MeasureBudget measures how much of PICO-8's code limits the program uses. Characters
+// PICO-8 cannot represent are measured by their UTF-8 encoding.
+func MeasureBudget(program Program) (budget Budget, err error) {
+	code, encodeErr := p8scii.Encode(program.Lua)
+	if encodeErr != nil {
+		code = []byte(program.Lua)
+	}
+
+	compressed, err := cartridge.CompressCode(code)
+	if err != nil {
+		err = fmt.Errorf("failed to compress code: %w", err)
+		return
+	}
+
+	budget = Budget{
+		Tokens:     CountTokens(program.Lua),
+		Chars:      countChars(program.Lua),
+		Compressed: len(compressed),
+		Decls:      make([]DeclBudget, 0, len(program.Decls)),
+	}
+	for _, decl := range program.Decls {
+		lua := program.Lua[decl.Start:decl.End]
+		budget.Decls = append(budget.Decls, DeclBudget{
+			Name:   decl.Name,
+			Pos:    decl.Pos,
+			Tokens: CountTokens(lua),
+			Chars:  countChars(lua),
+		})
+	}
+	return budget, nil
+}
+
+// Exceeded returns a description of every limit the budget goes over.
+func (b Budget) Exceeded() (exceeded []string) {
+	if b.Tokens > TokenLimit {
+		exceeded = append(exceeded, fmt.Sprintf("%d tokens, limit is %d", b.Tokens, TokenLimit))
+	}
+	if b.Chars > CharLimit {
+		exceeded = append(exceeded, fmt.Sprintf("%d characters, limit is %d", b.Chars, CharLimit))
+	}
+	if b.Compressed > CompressedLimit {
+		exceeded = append(exceeded, fmt.Sprintf("%d compressed bytes, limit is %d", b.Compressed, CompressedLimit))
+	}
+	return
+}
+
+// String summarises the budget on a single line.
+func (b Budget) String() string {
+	return fmt.Sprintf("tokens %d/%d, chars %d/%d, compressed %d/%d",
+		b.Tokens, TokenLimit, b.Chars, CharLimit, b.Compressed, CompressedLimit)
+}
+
+// countChars counts characters the way PICO-8 does, where a glyph and its variation
+// selector are a single character.
+func countChars(lua string) int {
+	if code, err := p8scii.Encode(lua); err == nil {
+		return len(code)
+	}
+	return utf8.RuneCountInString(lua)
+}
+
+// freeTokens are the Lua tokens PICO-8 does not count towards the token limit.
+var freeTokens = map[string]bool{
+	",":     true,
+	".":     true,
+	":":     true,
+	";":     true,
+	"::":    true,
+	")":     true,
+	"]":     true,
+	"}":     true,
+	"end":   true,
+	"local": true,
+}
+
+// luaOperators are the multi-character operators of PICO-8 Lua, longest first so the
+// first match is the longest one.
+var luaOperators = []string{
+	">>>=", "<<>=", ">><=",
+	"...", "..=", ">>>", "<<>", ">><", "<<=", ">>=", "^^=",
+	"..", "==", "~=", "!=", "<=", ">=", "<<", ">>", "::", "+=", "-=", "*=", "/=", "\\=", "%=", "^=", "&=", "|=", "^^",
+}
+
+type luaTokenKind int
+
+const (
+	luaToken_Name luaTokenKind = iota
+	luaToken_Number
+	luaToken_String
+	luaToken_Symbol
+)
+
+type luaToken struct {
+	kind luaTokenKind
+	text string
+}
+
+// CountTokens counts the tokens in a piece of Lua the way PICO-8 does. Closing
+// brackets, separators, "end" and "local" are free, and a minus or tilde directly in
+// front of a number literal counts as part of the number.
+func CountTokens(lua string) (count int) {
+	tokens := tokenizeLua(lua)
+	for i, token := range tokens {
+		if token.kind == luaToken_Symbol || token.kind == luaToken_Name {
+			if freeTokens[token.text] {
+				continue
+			}
+		}
+
+		if (token.text == "-" || token.text == "~") && i+1 < len(tokens) && tokens[i+1].kind == luaToken_Number {
+			if i == 0 || !endsOperand(tokens[i-1]) {
+				continue
+			}
+		}
+
+		count++
+	}
+	return count
+}
+
+// endsOperand reports whether token can end an operand, in which case a following minus
+// is a binary operator rather than a sign.
+func endsOperand(token luaToken) bool {
+	switch token.kind {
+	case luaToken_Number, luaToken_String:
+		return true
+	case luaToken_Name:
+		switch token.text {
+		case "and", "or", "not", "if", "then", "else", "elseif", "while", "do", "until",
+			"repeat", "return", "in", "for", "local", "function", "goto":
+			return false
+		}
+		return true
+	default:
+		switch token.text {
+		case ")", "]", "}", "...":
+			return true
+		}
+		return false
+	}
+}
+
+// tokenizeLua splits Lua into tokens, dropping whitespace and comments.
+func tokenizeLua(lua string) (tokens []luaToken) {
+	for i := 0; i < len(lua); {
+		r, size := utf8.DecodeRuneInString(lua[i:])
+		rest := lua[i:]
+
+		switch {
+		case r == ' ' || r == '\t' || r == '\r' || r == '\n':
+			i += size
+
+		case strings.HasPrefix(rest, "--"):
+			if n := longBracketLen(rest[2:]); n > 0 {
+				i += 2 + n
+			} else if end := strings.IndexByte(rest, '\n'); end >= 0 {
+				i += end + 1
+			} else {
+				i = len(lua)
+			}
+
+		case r == '"' || r == '\'':
+			n := quotedStringLen(rest)
+			tokens = append(tokens, luaToken{kind: luaToken_String, text: rest[:n]})
+			i += n
+
+		case r == '[' && longBracketLen(rest) > 0:
+			n := longBracketLen(rest)
+			tokens = append(tokens, luaToken{kind: luaToken_String, text: rest[:n]})
+			i += n
+
+		case isLuaDigit(r) || r == '.' && len(rest) > 1 && isLuaDigit(rune(rest[1])):
+			n := 1
+			for n < len(rest) && (isLuaNameByte(rest[n]) || rest[n] == '.') {
+				n++
+			}
+			tokens = append(tokens, luaToken{kind: luaToken_Number, text: rest[:n]})
+			i += n
+
+		case r == '_' || r >= utf8.RuneSelf || isLuaNameByte(byte(r)):
+			n := size
+			for n < len(rest) {
+				next, nextSize := utf8.DecodeRuneInString(rest[n:])
+				if next < utf8.RuneSelf && !isLuaNameByte(byte(next)) {
+					break
+				}
+				n += nextSize
+			}
+			tokens = append(tokens, luaToken{kind: luaToken_Name, text: rest[:n]})
+			i += n
+
+		default:
+			text := rest[:size]
+			for _, op := range luaOperators {
+				if strings.HasPrefix(rest, op) {
+					text = op
+					break
+				}
+			}
+			tokens = append(tokens, luaToken{kind: luaToken_Symbol, text: text})
+			i += len(text)
+		}
+	}
+	return tokens
+}
+
+// quotedStringLen returns the length of the quoted string at the start of s, including
+// its quotes. An unterminated string runs to the end of the line.
+func quotedStringLen(s string) int {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1
+		case '\n':
+			return i
+		}
+	}
+	return len(s)
+}
+
+// longBracketLen returns the length of the long bracket string, such as [[...]] or
+// [==[...]==], at the start of s, or 0 if s does not start with one.
+func longBracketLen(s string) int {
+	if len(s) < 2 || s[0] != '[' {
+		return 0
+	}
+	level := 1
+	for level < len(s) && s[level] == '=' {
+		level++
+	}
+	if level >= len(s) || s[level] != '[' {
+		return 0
+	}
+
+	closing := "]" + strings.Repeat("=", level-1) + "]"
+	end := strings.Index(s[level+1:], closing)
+	if end < 0 {
+		return len(s)
+	}
+	return level + 1 + end + len(closing)
+}
+
+func isLuaDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isLuaNameByte(b byte) bool {
+	return b == '_' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9'
+}
+
+// declName describes a top-level statement for the budget breakdown.
+func declName(stmt parser.Stmt) string {
+	switch n := stmt.(type) {
+	case parser.StmtVarDeclare:
+		return "var " + n.VariableName
+	case parser.StmtVarAssign:
+		return "assign " + n.VariableName
+	case parser.StmtObjDefine:
+		return "obj " + n.Name
+	case parser.StmtCallFunction:
+		return "call " + n.FunctionName
+	case parser.StmtExpr:
+		return "call"
+	case parser.StmtImport:
+		return "import " + n.Path
+	case parser.StmtIf:
+		return "if"
+	case parser.StmtWhile:
+		return "while"
+	case parser.StmtFor:
+		return "for " + n.Variable
+	case parser.StmtForEach:
+		return "for " + strings.Join(n.Variables, ", ")
+	case parser.StmtFunction:
+		return "fn " + n.Name
+	case parser.StmtBlock:
+		return "block"
+	default:
+		return fmt.Sprintf("%T", stmt)
+	}
+}
@@ -3,9 +3,13 @@ package compiler
 import (
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"pixie/lexer"
+	"pixie/p8scii"
 	"pixie/parser"
 	"pixie/shared"
+	"pixie/sourcemap"
 	"strings"
 )
 
@@ -15,9 +19,74 @@ const (
 
 var (
 	ErrInvalidTypeAssign = fmt.Errorf("invalid type assign")
+	ErrImportCycle       = fmt.Errorf("import cycle")
+	ErrInvalidOperand    = fmt.Errorf("invalid operand")
+	ErrInvalidCondition  = fmt.Errorf("invalid condition")
+	ErrInvalidRange      = fmt.Errorf("invalid range")
+	ErrNotInLoop         = fmt.Errorf("not in a loop")
+	ErrNotIterable       = fmt.Errorf("not iterable")
+	ErrInvalidReturn     = fmt.Errorf("invalid return")
+	ErrInvalidArgument   = fmt.Errorf("invalid argument")
+	ErrNoValue           = fmt.Errorf("no value")
 )
 
+// luaEscapes maps the P8SCII characters that cannot appear as they are in a PICO-8 string
+// literal to the escape sequence PICO-8 reads them from. Control codes 1-6 have
+// PICO-8's own escapes, the rest are written in decimal.
+var luaEscapes = map[byte]string{
+	0:    "\\000",
+	1:    "\\*",
+	2:    "\\#",
+	3:    "\\-",
+	4:    "\\|",
+	5:    "\\+",
+	6:    "\\^",
+	7:    "\\a",
+	8:    "\\b",
+	9:    "\\t",
+	10:   "\\n",
+	11:   "\\v",
+	12:   "\\f",
+	13:   "\\r",
+	14:   "\\014",
+	15:   "\\015",
+	'"':  "\\\"",
+	'\\': "\\\\",
+}
+
 func Compile(node parser.Node) (lua string, err error) {
+	program, err := CompileProgram(node)
+	if err != nil {
+		return
+	}
+	return program.Lua, nil
+}
+
+// Program is the result of compiling a pixie program.
+type Program struct {
+	Lua      string              // The generated Lua
+	Decls    []Decl              // The top-level statements of the program, in order
+	Mappings []sourcemap.Mapping // Where each part of the Lua was generated from, in Lua order
+	Files    []string            // The files imported by the program, in the order they were first imported
+}
+
+// Decl is a top-level pixie statement and the range of Lua generated for it.
+type Decl struct {
+	Name  string         // A short description of the statement, such as "var x" or "obj person"
+	Pos   lexer.Position // Where the statement is in the source
+	Start int            // Byte offset in Lua where the statement's output starts
+	End   int            // Byte offset in Lua where the statement's output ends
+}
+
+// CompileProgram compiles node like Compile, additionally recording which part of the
+// generated Lua each top-level statement produced and which source position every
+// statement and expression was generated from.
+//
+// Imports are resolved relative to the file of the import statement, as recorded in its
+// position, and read from disk. Program.Files is set even when compilation fails, so
+// callers can tell which files the failure may depend on. Compilation errors are
+// *lexer.Error values reporting the statement or expression that could not be compiled.
+func CompileProgram(node parser.Node) (program Program, err error) {
 	stmt, ok := node.(parser.Stmt)
 	if !ok {
 		err = fmt.Errorf("expected statement, got: %v", node)
@@ -29,12 +98,54 @@ func Compile(node parser.Node) (lua string, err error) {
 		sb:        &sb,
 		variables: make(map[string]variable, 0),
 		objects:   make(map[string]object, 0),
+		functions: make(map[string]function, 0),
+		imported:  make(map[string]bool, 0),
+	}
+	if file := stmt.Position().File; file != "" {
+		if abs, absErr := filepath.Abs(file); absErr == nil {
+			c.importing = append(c.importing, importFrame{path: abs, name: file})
+			c.imported[abs] = true
+		}
 	}
+
 	if err = c.compileStmt(stmt); err != nil {
-		err = fmt.Errorf("failed to compile statement: %w", err)
+		err = lexer.Locate(withoutContext(err), stmt.Position())
+		program.Files = c.files
 		return
 	}
-	return sb.String(), nil
+	return Program{
+		Lua:      sb.String(),
+		Decls:    c.decls,
+		Mappings: c.mappings,
+		Files:    c.files,
+	}, nil
+}
+
+// contextError is a compilation error whose message leaves out the context it was wrapped
+// in on its way up, such as "failed to compile statement block: ", since its position
+// already says where it happened. errors.Is and errors.As still see the whole error.
+type contextError struct {
+	message string
+	err     error
+}
+
+func (e *contextError) Error() string {
+	return e.message
+}
+
+func (e *contextError) Unwrap() error {
+	return e.err
+}
+
+// withoutContext returns err with the message of the first error it wraps that is not
+// "failed to ..." context.
+func withoutContext(err error) error {
+	for inner := err; inner != nil; inner = errors.Unwrap(inner) {
+		if message := inner.Error(); !strings.HasPrefix(message, "failed to ") {
+			return &contextError{message: message, err: err}
+		}
+	}
+	return err
 }
 
 type compiler struct {
@@ -42,6 +153,71 @@ type compiler struct {
 	scope     int
 	variables map[string]variable
 	objects   map[string]object
+	functions map[string]function
+	function  *function // The function being compiled, nil outside of functions
+	decls     []Decl
+	mappings  []sourcemap.Mapping
+	lua       luaPosition
+	imported  map[string]bool // Absolute paths of the files compiled so far
+	importing []importFrame   // The chain of files being compiled, outermost first
+	files     []string        // Imported files in the order they were first imported
+	loops     []loop          // The loops being compiled, innermost last
+}
+
+// loop is a loop being compiled. PICO-8 Lua has no continue, so a continue jumps to a
+// label at the end of the loop body instead, which is only written if it is used.
+type loop struct {
+	label       string
+	continued   bool
+	finalReturn lexer.Position // Where the return that ends the body is, if it ends with one
+}
+
+// importFrame is a file being compiled while the files it imports are compiled.
+type importFrame struct {
+	path string // Absolute path, used to detect cycles
+	name string // Path as it was imported, used in errors
+}
+
+// luaPosition tracks the line and column at the end of the Lua written so far. It is only
+// brought up to date when a mapping is recorded.
+type luaPosition struct {
+	offset int
+	line   int
+	column int
+}
+
+// mark records that the Lua written next is generated from the source at pos. When
+// nested nodes start at the same place in the Lua, the innermost one wins.
+func (c *compiler) mark(pos lexer.Position) {
+	if !pos.IsValid() {
+		return
+	}
+
+	if c.lua.line == 0 {
+		c.lua = luaPosition{line: 1, column: 1}
+	}
+	for _, r := range c.sb.String()[c.lua.offset:] {
+		if r == '\n' {
+			c.lua.line++
+			c.lua.column = 1
+		} else {
+			c.lua.column++
+		}
+	}
+	c.lua.offset = c.sb.Len()
+
+	mapping := sourcemap.Mapping{Line: c.lua.line, Column: c.lua.column, Source: pos}
+	if n := len(c.mappings); n > 0 {
+		last := c.mappings[n-1]
+		if last.Line == mapping.Line && last.Column == mapping.Column {
+			c.mappings[n-1] = mapping
+			return
+		}
+		if last.Line == mapping.Line && last.Source == mapping.Source {
+			return
+		}
+	}
+	c.mappings = append(c.mappings, mapping)
 }
 
 type variable struct {
@@ -53,7 +229,24 @@ type object struct {
 	fields []parser.FieldTypePair
 }
 
+type function struct {
+	name       string
+	params     []parser.FieldTypePair
+	returnType shared.DataType // nil if the function returns nothing
+	defined    bool            // Whether the declaration has been compiled, so the function exists in the Lua
+	calls      []string        // The functions declared with fn that the body calls
+}
+
 func (c *compiler) compileStmt(stmt parser.Stmt) (err error) {
+	if stmt != nil {
+		c.mark(stmt.Position())
+		defer func() {
+			if err != nil {
+				err = lexer.ErrorAt(stmt.Position(), err)
+			}
+		}()
+	}
+
 	switch n := stmt.(type) {
 	case parser.StmtBlock:
 		if err = c.compileStmtBlock(n); err != nil {
@@ -80,6 +273,61 @@ func (c *compiler) compileStmt(stmt parser.Stmt) (err error) {
 			err = fmt.Errorf("failed to compile statement object define: %w", err)
 			return
 		}
+	case parser.StmtImport:
+		if err = c.compileStmtImport(n); err != nil {
+			err = fmt.Errorf("failed to compile statement import: %w", err)
+			return
+		}
+	case parser.StmtIf:
+		if err = c.compileStmtIf(n); err != nil {
+			err = fmt.Errorf("failed to compile statement if: %w", err)
+			return
+		}
+	case parser.StmtWhile:
+		if err = c.compileStmtWhile(n); err != nil {
+			err = fmt.Errorf("failed to compile statement while: %w", err)
+			return
+		}
+	case parser.StmtFor:
+		if err = c.compileStmtFor(n); err != nil {
+			err = fmt.Errorf("failed to compile statement for: %w", err)
+			return
+		}
+	case parser.StmtForEach:
+		if err = c.compileStmtForEach(n); err != nil {
+			err = fmt.Errorf("failed to compile statement for: %w", err)
+			return
+		}
+	case parser.StmtFunction:
+		if err = c.compileStmtFunction(n); err != nil {
+			err = fmt.Errorf("failed to compile statement function: %w", err)
+			return
+		}
+	case parser.StmtReturn:
+		if err = c.compileStmtReturn(n); err != nil {
+			err = fmt.Errorf("failed to compile statement return: %w", err)
+			return
+		}
+	case parser.StmtExpr:
+		if err = c.compileStmtExpr(n); err != nil {
+			err = fmt.Errorf("failed to compile statement expression: %w", err)
+			return
+		}
+	case parser.StmtBreak:
+		if len(c.loops) == 0 {
+			err = fmt.Errorf("%w: break", ErrNotInLoop)
+			return
+		}
+		c.sb.WriteString("break")
+	case parser.StmtContinue:
+		if len(c.loops) == 0 {
+			err = fmt.Errorf("%w: continue", ErrNotInLoop)
+			return
+		}
+		innermost := &c.loops[len(c.loops)-1]
+		innermost.continued = true
+		c.sb.WriteString("goto ")
+		c.sb.WriteString(innermost.label)
 	default:
 		err = fmt.Errorf("expected statement, got: %v", n)
 		return
@@ -89,6 +337,15 @@ func (c *compiler) compileStmt(stmt parser.Stmt) (err error) {
 }
 
 func (c *compiler) compileExpr(expr parser.Expr) (err error) {
+	if expr != nil {
+		c.mark(expr.Position())
+		defer func() {
+			if err != nil {
+				err = lexer.ErrorAt(expr.Position(), err)
+			}
+		}()
+	}
+
 	switch n := expr.(type) {
 	case parser.ExprBlock:
 		if err = c.compileExprBlock(n); err != nil {
@@ -140,6 +397,26 @@ func (c *compiler) compileExpr(expr parser.Expr) (err error) {
 			err = fmt.Errorf("failed to compile expression binary: %w", err)
 			return
 		}
+	case parser.ExprUnary:
+		if err = c.compileExprUnary(n); err != nil {
+			err = fmt.Errorf("failed to compile expression unary: %w", err)
+			return
+		}
+	case parser.ExprCall:
+		if err = c.compileExprCall(n); err != nil {
+			err = fmt.Errorf("failed to compile expression call: %w", err)
+			return
+		}
+	case parser.ExprMemberCall:
+		if err = c.compileExprMemberCall(n); err != nil {
+			err = fmt.Errorf("failed to compile expression member call: %w", err)
+			return
+		}
+	case parser.ExprConditional:
+		if err = c.compileExprConditional(n); err != nil {
+			err = fmt.Errorf("failed to compile expression conditional: %w", err)
+			return
+		}
 	default:
 		err = fmt.Errorf("expected expr, got: %v", n)
 		return
@@ -150,13 +427,8 @@ func (c *compiler) compileExpr(expr parser.Expr) (err error) {
 
 func (c *compiler) compileStmtBlock(stmt parser.StmtBlock) (err error) {
 	c.scope += 1
-	for _, s := range stmt.Stmts {
-		err = c.compileStmt(s)
-		if err != nil {
-			err = fmt.Errorf("failed to compile stmt: %w", err)
-			return
-		}
-		c.sb.WriteRune('\n')
+	if err = c.compileStmts(stmt.Stmts); err != nil {
+		return
 	}
 
 	variablesToRemove := make([]string, 0, len(c.variables))
@@ -174,6 +446,501 @@ func (c *compiler) compileStmtBlock(stmt parser.StmtBlock) (err error) {
 	return nil
 }
 
+// compileStmtIf compiles an if statement and its else if and else branches into a single
+// Lua if ... elseif ... else ... end. Each branch is a block with its own scope.
+func (c *compiler) compileStmtIf(stmt parser.StmtIf) (err error) {
+	c.sb.WriteString("if ")
+	for {
+		if err = c.checkCondition(stmt.Cond); err != nil {
+			return
+		}
+		if err = c.compileExpr(stmt.Cond); err != nil {
+			err = fmt.Errorf("failed to compile condition: %w", err)
+			return
+		}
+		c.sb.WriteString(" then\n")
+		if err = c.compileStmtBlock(stmt.Then); err != nil {
+			return
+		}
+
+		elseIf, ok := stmt.Else.(parser.StmtIf)
+		if !ok {
+			break
+		}
+		c.mark(elseIf.Pos)
+		c.sb.WriteString("elseif ")
+		stmt = elseIf
+	}
+
+	if stmt.Else != nil {
+		c.sb.WriteString("else\n")
+		if err = c.compileStmt(stmt.Else); err != nil {
+			err = fmt.Errorf("failed to compile else block: %w", err)
+			return
+		}
+	}
+	c.sb.WriteString("end")
+	return nil
+}
+
+func (c *compiler) compileStmtWhile(stmt parser.StmtWhile) (err error) {
+	if err = c.checkCondition(stmt.Cond); err != nil {
+		return
+	}
+
+	c.sb.WriteString("while ")
+	if err = c.compileExpr(stmt.Cond); err != nil {
+		err = fmt.Errorf("failed to compile condition: %w", err)
+		return
+	}
+	c.sb.WriteString(" do\n")
+	if err = c.compileLoopBody(stmt.Body); err != nil {
+		return
+	}
+	c.sb.WriteString("end")
+	return nil
+}
+
+// compileStmtFor compiles a numeric for loop. The loop variable is a num declared in the
+// scope of the body.
+func (c *compiler) compileStmtFor(stmt parser.StmtFor) (err error) {
+	if _, ok := c.variables[stmt.Variable]; ok {
+		err = lexer.Errorf(stmt.VariablePos, "variable %q already exists", stmt.Variable)
+		return
+	}
+
+	bounds := []parser.Expr{stmt.Start, stmt.End}
+	if stmt.Step != nil {
+		bounds = append(bounds, stmt.Step)
+	}
+	for _, bound := range bounds {
+		if err = c.checkExpressionValidNumberForBinary(bound); err != nil {
+			err = lexer.ErrorAt(bound.Position(), fmt.Errorf("%w: expected num: %v", ErrInvalidRange, err))
+			return
+		}
+	}
+
+	c.sb.WriteString("for ")
+	c.sb.WriteString(stmt.Variable)
+	c.sb.WriteString(" = ")
+	for i, bound := range bounds {
+		if i > 0 {
+			c.sb.WriteString(", ")
+		}
+		if err = c.compileExpr(bound); err != nil {
+			err = fmt.Errorf("failed to compile range: %w", err)
+			return
+		}
+	}
+	c.sb.WriteString(" do\n")
+
+	// The body's block opens the next scope, so the variable is dropped along with it
+	c.variables[stmt.Variable] = variable{
+		scope:    c.scope + 1,
+		dataType: shared.Number{},
+	}
+	if err = c.compileLoopBody(stmt.Body); err != nil {
+		return
+	}
+	c.sb.WriteString("end")
+	return nil
+}
+
+// compileStmtForEach compiles a loop over a list or map. Lists are iterated with all, or
+// with ipairs when the index is wanted, shifting the index down so it counts from 0 like
+// list indexing does. Maps are iterated with pairs. A variable named _ is not declared.
+func (c *compiler) compileStmtForEach(stmt parser.StmtForEach) (err error) {
+	collectionType, err := c.collectionDataType(stmt.Collection)
+	if err != nil {
+		err = lexer.ErrorAt(stmt.Collection.Position(), fmt.Errorf("%w: %v", ErrNotIterable, err))
+		return
+	}
+
+	var types []shared.DataType
+	var iterator string
+	switch t := collectionType.(type) {
+	case shared.List:
+		if len(stmt.Variables) == 1 {
+			types, iterator = []shared.DataType{t.ListType}, "all"
+		} else {
+			types, iterator = []shared.DataType{shared.Number{}, t.ListType}, "ipairs"
+		}
+	case shared.Map:
+		types, iterator = []shared.DataType{t.KeyType, t.ValueType}[:len(stmt.Variables)], "pairs"
+	default:
+		err = lexer.ErrorAt(stmt.Collection.Position(), fmt.Errorf("%w: expected list or map, got %s", ErrNotIterable, collectionType.String()))
+		return
+	}
+
+	for i, name := range stmt.Variables {
+		if name == "_" {
+			continue
+		}
+		if _, ok := c.variables[name]; ok || i > 0 && name == stmt.Variables[0] {
+			err = lexer.Errorf(stmt.VariablePos, "variable %q already exists", name)
+			return
+		}
+	}
+
+	c.sb.WriteString("for ")
+	c.sb.WriteString(strings.Join(stmt.Variables, ", "))
+	c.sb.WriteString(" in ")
+	c.sb.WriteString(iterator)
+	c.sb.WriteRune('(')
+	if err = c.compileExpr(stmt.Collection); err != nil {
+		err = fmt.Errorf("failed to compile collection: %w", err)
+		return
+	}
+	c.sb.WriteString(") do\n")
+	if iterator == "ipairs" && stmt.Variables[0] != "_" {
+		c.sb.WriteString(fmt.Sprintf("local %s = %s - 1\n", stmt.Variables[0], stmt.Variables[0]))
+	}
+
+	// The body's block opens the next scope, so the variables are dropped along with it
+	for i, name := range stmt.Variables {
+		if name == "_" {
+			continue
+		}
+		c.variables[name] = variable{
+			scope:    c.scope + 1,
+			dataType: types[i],
+		}
+	}
+	if err = c.compileLoopBody(stmt.Body); err != nil {
+		return
+	}
+	c.sb.WriteString("end")
+	return nil
+}
+
+// collectionDataType returns the data type of the collection a for loop iterates over.
+func (c *compiler) collectionDataType(expr parser.Expr) (dataType shared.DataType, err error) {
+	if block, isBlock := expr.(parser.ExprBlock); isBlock {
+		return c.collectionDataType(block.Value)
+	}
+	return c.accessDataType(expr)
+}
+
+// compileLoopBody compiles the body of a loop, followed by the label continue jumps to
+// if the body has a continue.
+func (c *compiler) compileLoopBody(body parser.StmtBlock) (err error) {
+	innermost := loop{label: fmt.Sprintf("continue%d", len(c.loops)+1)}
+	if n := len(body.Stmts); n > 0 {
+		if ret, ok := body.Stmts[n-1].(parser.StmtReturn); ok {
+			innermost.finalReturn = ret.Pos
+		}
+	}
+	c.loops = append(c.loops, innermost)
+	defer func() {
+		c.loops = c.loops[:len(c.loops)-1]
+	}()
+
+	if err = c.compileStmtBlock(body); err != nil {
+		return
+	}
+	if innermost := c.loops[len(c.loops)-1]; innermost.continued {
+		c.sb.WriteString("::" + innermost.label + "::\n")
+	}
+	return nil
+}
+
+// checkCondition checks that the condition of an if statement or loop is a bool.
+func (c *compiler) checkCondition(cond parser.Expr) (err error) {
+	if err = c.checkExpressionValidDataType(shared.Boolean{}, cond); err != nil {
+		err = lexer.ErrorAt(cond.Position(), fmt.Errorf("%w: expected bool: %v", ErrInvalidCondition, err))
+	}
+	return
+}
+
+// declareFunctions registers the functions declared in the top-level statements of a file
+// before any of them are compiled, so calls are checked against functions declared later
+// in the file too.
+func (c *compiler) declareFunctions(stmts []parser.Stmt) (err error) {
+	for _, s := range stmts {
+		stmt, ok := s.(parser.StmtFunction)
+		if !ok {
+			continue
+		}
+		if _, ok := c.functions[stmt.Name]; ok {
+			err = lexer.Errorf(stmt.Pos, "function %q already exists", stmt.Name)
+			return
+		}
+		c.functions[stmt.Name] = function{
+			name:       stmt.Name,
+			params:     stmt.Params,
+			returnType: stmt.ReturnType,
+		}
+	}
+	return nil
+}
+
+// compileStmtFunction compiles a function declaration. The function was registered by
+// declareFunctions, and is marked as defined before its body is compiled so it can call
+// itself. Its parameters are variables in the scope of the body.
+func (c *compiler) compileStmtFunction(stmt parser.StmtFunction) (err error) {
+	if c.scope != globalScope {
+		err = fmt.Errorf("function %q must be declared at the top level of a file", stmt.Name)
+		return
+	}
+	if _, ok := c.variables[stmt.Name]; ok {
+		err = fmt.Errorf("variable %q already exists", stmt.Name)
+		return
+	}
+	for i, param := range stmt.Params {
+		if _, ok := c.variables[param.Field]; ok {
+			err = lexer.Errorf(param.Pos, "variable %q already exists", param.Field)
+			return
+		}
+		for _, other := range stmt.Params[:i] {
+			if other.Field == param.Field {
+				err = lexer.Errorf(param.Pos, "parameter %q is declared twice", param.Field)
+				return
+			}
+		}
+	}
+	fn := function{
+		name:       stmt.Name,
+		params:     stmt.Params,
+		returnType: stmt.ReturnType,
+		defined:    true,
+	}
+	c.functions[stmt.Name] = fn
+	c.function = &fn
+	defer func() {
+		c.function = nil
+	}()
+
+	c.sb.WriteString("function ")
+	c.sb.WriteString(stmt.Name)
+	c.sb.WriteRune('(')
+	for i, param := range stmt.Params {
+		if i > 0 {
+			c.sb.WriteRune(',')
+		}
+		c.sb.WriteString(param.Field)
+	}
+	c.sb.WriteString(")\n")
+
+	// The body's block opens the next scope, so the parameters are dropped along with it
+	for _, param := range stmt.Params {
+		c.variables[param.Field] = variable{
+			scope:    c.scope + 1,
+			dataType: param.Type,
+		}
+	}
+	if err = c.compileStmtBlock(stmt.Body); err != nil {
+		return
+	}
+	c.functions[stmt.Name] = fn
+	// Checked after the body, so a statement after a return is reported as unreachable
+	// rather than as the end of the function
+	if stmt.ReturnType != nil && !blockReturns(stmt.Body) {
+		err = fmt.Errorf("%w: function %q must end by returning %s", ErrInvalidReturn, stmt.Name, stmt.ReturnType.String())
+		return
+	}
+	c.sb.WriteString("end")
+	return nil
+}
+
+// compileStmtReturn compiles a return, checking its value against the return type of the
+// enclosing function. Lua only allows a return at the end of a block, so one that the
+// continue label of a loop may follow is wrapped in a block of its own.
+func (c *compiler) compileStmtReturn(stmt parser.StmtReturn) (err error) {
+	if c.function == nil {
+		err = fmt.Errorf("%w: return outside of a function", ErrInvalidReturn)
+		return
+	}
+
+	switch {
+	case c.function.returnType == nil && stmt.Expr != nil:
+		err = lexer.ErrorAt(stmt.Expr.Position(), fmt.Errorf("%w: function %q does not return a value", ErrInvalidReturn, c.function.name))
+		return
+	case c.function.returnType != nil && stmt.Expr == nil:
+		err = fmt.Errorf("%w: function %q must return %s", ErrInvalidReturn, c.function.name, c.function.returnType.String())
+		return
+	case stmt.Expr != nil:
+		if err = c.checkExpressionAssignable(c.function.returnType, stmt.Expr); err != nil {
+			err = lexer.ErrorAt(stmt.Expr.Position(), fmt.Errorf("%w: %w", ErrInvalidReturn, err))
+			return
+		}
+	}
+
+	wrap := len(c.loops) > 0 && c.loops[len(c.loops)-1].finalReturn == stmt.Pos
+	if wrap {
+		c.sb.WriteString("do ")
+	}
+	c.sb.WriteString("return")
+	if stmt.Expr != nil {
+		c.sb.WriteRune(' ')
+		if err = c.compileExprOfType(c.function.returnType, stmt.Expr); err != nil {
+			err = fmt.Errorf("failed to compile return value: %w", err)
+			return
+		}
+	}
+	if wrap {
+		c.sb.WriteString(" end")
+	}
+	return nil
+}
+
+// blockReturns reports whether every path through a block ends in a return.
+func blockReturns(block parser.StmtBlock) bool {
+	if len(block.Stmts) == 0 {
+		return false
+	}
+
+	switch last := block.Stmts[len(block.Stmts)-1].(type) {
+	case parser.StmtReturn:
+		return true
+	case parser.StmtIf:
+		for {
+			if !blockReturns(last.Then) {
+				return false
+			}
+			switch e := last.Else.(type) {
+			case parser.StmtIf:
+				last = e
+			case parser.StmtBlock:
+				return blockReturns(e)
+			default:
+				return false
+			}
+		}
+	default:
+		return false
+	}
+}
+
+// checkExpressionAssignable checks that an expression can be stored as dataType, as when
+// it is assigned, passed as an argument or returned. Variables and properties are compared
+// by type directly.
+func (c *compiler) checkExpressionAssignable(dataType shared.DataType, expr parser.Expr) (err error) {
+	switch expr.(type) {
+	case parser.ExprVariable, parser.ExprPropertyAccess:
+	default:
+		return c.checkExpressionValidDataType(dataType, expr)
+	}
+
+	exprType, err := c.accessDataType(expr)
+	if err != nil {
+		return
+	}
+	if dataType.String() != exprType.String() {
+		err = fmt.Errorf("wanted %q got %q", dataType.String(), exprType.String())
+		return
+	}
+	return nil
+}
+
+// compileStmts compiles statements in the current scope, one per line. Nothing may follow
+// a return, since Lua only allows it at the end of a block.
+func (c *compiler) compileStmts(stmts []parser.Stmt) (err error) {
+	if c.scope == globalScope {
+		if err = c.declareFunctions(stmts); err != nil {
+			return
+		}
+	}
+
+	for i, s := range stmts {
+		if _, ok := s.(parser.StmtReturn); ok && i < len(stmts)-1 {
+			err = lexer.Errorf(stmts[i+1].Position(), "unreachable statement after return")
+			return
+		}
+
+		// An import's statements are written, and recorded as declarations, by the import.
+		if _, ok := s.(parser.StmtImport); ok {
+			if err = c.compileStmt(s); err != nil {
+				err = fmt.Errorf("failed to compile stmt: %w", err)
+				return
+			}
+			continue
+		}
+
+		start := c.sb.Len()
+		err = c.compileStmt(s)
+		if err != nil {
+			err = fmt.Errorf("failed to compile stmt: %w", err)
+			return
+		}
+		c.sb.WriteRune('\n')
+
+		if c.scope == globalScope {
+			c.decls = append(c.decls, Decl{
+				Name:  declName(s),
+				Pos:   s.Position(),
+				Start: start,
+				End:   c.sb.Len(),
+			})
+		}
+	}
+	return nil
+}
+
+// compileStmtImport compiles the statements of an imported file in place, at the top
+// level, so its objects and variables are shared with the importing file. A file is only
+// compiled the first time it is imported.
+func (c *compiler) compileStmtImport(stmt parser.StmtImport) (err error) {
+	if c.scope != globalScope {
+		err = fmt.Errorf("import %q must be at the top level of a file", stmt.Path)
+		return
+	}
+
+	path := filepath.FromSlash(stmt.Path)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(stmt.Pos.File), path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		err = fmt.Errorf("failed to resolve %q: %w", stmt.Path, err)
+		return
+	}
+
+	for i, frame := range c.importing {
+		if frame.path == abs {
+			chain := make([]string, 0, len(c.importing)-i+1)
+			for _, f := range c.importing[i:] {
+				chain = append(chain, f.name)
+			}
+			err = fmt.Errorf("%w: %s -> %s", ErrImportCycle, strings.Join(chain, " -> "), path)
+			return
+		}
+	}
+
+	if c.imported[abs] {
+		return nil
+	}
+	c.imported[abs] = true
+	c.files = append(c.files, path)
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		err = fmt.Errorf("failed to read %q: %w", stmt.Path, err)
+		return
+	}
+
+	node, err := parser.New(lexer.NewFile(path, string(src))).Parse()
+	if err != nil {
+		err = fmt.Errorf("failed to parse %q: %w", stmt.Path, err)
+		return
+	}
+
+	c.importing = append(c.importing, importFrame{path: abs, name: path})
+	defer func() {
+		c.importing = c.importing[:len(c.importing)-1]
+	}()
+
+	block, ok := node.(parser.StmtBlock)
+	if !ok {
+		err = fmt.Errorf("expected statement block, got: %v", node)
+		return
+	}
+	if err = c.compileStmts(block.Stmts); err != nil {
+		err = fmt.Errorf("failed to compile %q: %w", stmt.Path, err)
+		return
+	}
+	return nil
+}
+
 func (c *compiler) compileCommaSeparatedExpressions(exprs []parser.Expr) (err error) {
 	argsLen := len(exprs)
 	for i, arg := range exprs {
@@ -190,9 +957,171 @@ func (c *compiler) compileCommaSeparatedExpressions(exprs []parser.Expr) (err er
 }
 
 func (c *compiler) compileStmtCallFunction(stmt parser.StmtCallFunction) (err error) {
+	if err = c.checkArguments(stmt.FunctionName, stmt.Args); err != nil {
+		return
+	}
+
 	c.sb.WriteString(stmt.FunctionName)
 	c.sb.WriteRune('(')
-	if err = c.compileCommaSeparatedExpressions(stmt.Args); err != nil {
+	if err = c.compileArguments(stmt.FunctionName, stmt.Args); err != nil {
+		err = fmt.Errorf("failed to compile arguments: %w", err)
+		return
+	}
+	c.sb.WriteRune(')')
+	return nil
+}
+
+// compileArguments compiles the arguments of a call. The arguments of a function declared
+// with fn are compiled as the types of its parameters, which checkArguments has checked
+// them against.
+func (c *compiler) compileArguments(name string, args []parser.Expr) (err error) {
+	fn, ok := c.functions[name]
+	if !ok {
+		return c.compileCommaSeparatedExpressions(args)
+	}
+
+	for i, arg := range args {
+		if i > 0 {
+			c.sb.WriteRune(',')
+		}
+		if err = c.compileExprOfType(fn.params[i].Type, arg); err != nil {
+			err = fmt.Errorf("failed to compile argument %d: %w", i, err)
+			return
+		}
+	}
+	return nil
+}
+
+// checkArguments checks the arguments of a call to a function declared with fn against
+// its parameters. Calls to PICO-8's own functions are not checked. Outside of a function
+// body the call runs as soon as it is reached, so the function must already be defined,
+// along with every function it calls in turn.
+func (c *compiler) checkArguments(name string, args []parser.Expr) (err error) {
+	fn, ok := c.functions[name]
+	if !ok {
+		return nil
+	}
+	if c.function != nil {
+		c.function.calls = append(c.function.calls, name)
+	} else if undefined := c.undefinedFunction(name, make(map[string]bool)); undefined == name {
+		err = fmt.Errorf("function %q is called before it is defined", name)
+		return
+	} else if undefined != "" {
+		err = fmt.Errorf("function %q is called before %q, which it calls, is defined", name, undefined)
+		return
+	}
+
+	if len(args) != len(fn.params) {
+		err = fmt.Errorf("%w: function %q takes %d arguments, got %d", ErrInvalidArgument, name, len(fn.params), len(args))
+		return
+	}
+	for i, arg := range args {
+		if err = c.checkExpressionAssignable(fn.params[i].Type, arg); err != nil {
+			err = lexer.ErrorAt(arg.Position(), fmt.Errorf("%w: parameter %q: %w", ErrInvalidArgument, fn.params[i].Field, err))
+			return
+		}
+	}
+	return nil
+}
+
+// undefinedFunction returns the first function that is not defined yet out of name and the
+// functions it calls, directly or through others, or "" when they are all defined.
+func (c *compiler) undefinedFunction(name string, seen map[string]bool) string {
+	if seen[name] {
+		return ""
+	}
+	seen[name] = true
+
+	fn := c.functions[name]
+	if !fn.defined {
+		return name
+	}
+	for _, callee := range fn.calls {
+		if undefined := c.undefinedFunction(callee, seen); undefined != "" {
+			return undefined
+		}
+	}
+	return ""
+}
+
+// compileExprCall compiles a call whose result is used as a value. A function declared
+// with fn must have a return type to be used this way.
+func (c *compiler) compileExprCall(expr parser.ExprCall) (err error) {
+	if _, ok := c.functions[expr.FunctionName]; ok {
+		if _, err = c.callDataType(expr); err != nil {
+			return
+		}
+	}
+	if err = c.checkArguments(expr.FunctionName, expr.Args); err != nil {
+		return
+	}
+
+	c.sb.WriteString(expr.FunctionName)
+	c.sb.WriteRune('(')
+	if err = c.compileArguments(expr.FunctionName, expr.Args); err != nil {
+		err = fmt.Errorf("failed to compile arguments: %w", err)
+		return
+	}
+	c.sb.WriteRune(')')
+	return nil
+}
+
+// callDataType returns the return type of a call to a function declared with fn. The
+// types PICO-8's own functions return are not known.
+func (c *compiler) callDataType(expr parser.ExprCall) (dataType shared.DataType, err error) {
+	fn, ok := c.functions[expr.FunctionName]
+	if !ok {
+		err = fmt.Errorf("return type of %q is not known", expr.FunctionName)
+		return
+	}
+	if fn.returnType == nil {
+		err = fmt.Errorf("%w: function %q does not return a value", ErrNoValue, expr.FunctionName)
+		return
+	}
+	return fn.returnType, nil
+}
+
+// checkExpressionValidCall checks that a call returns dataType. Calls to PICO-8's own
+// functions are not checked.
+func (c *compiler) checkExpressionValidCall(dataType shared.DataType, expr parser.ExprCall) (err error) {
+	if _, ok := c.functions[expr.FunctionName]; !ok {
+		return nil
+	}
+
+	returnType, err := c.callDataType(expr)
+	if err != nil {
+		return
+	}
+	if returnType.String() != dataType.String() {
+		return fmt.Errorf("expected %s got call to %q returning %s", dataType.String(), expr.FunctionName, returnType.String())
+	}
+	return nil
+}
+
+// compileStmtExpr compiles an expression used as a statement. Lua only allows calls as
+// statements, and so does the parser.
+func (c *compiler) compileStmtExpr(stmt parser.StmtExpr) (err error) {
+	if _, ok := stmt.Expr.(parser.ExprMemberCall); !ok {
+		err = fmt.Errorf("expected call, got %T", stmt.Expr)
+		return
+	}
+	return c.compileExpr(stmt.Expr)
+}
+
+// compileExprMemberCall compiles a call of a function stored in a property. Pixie has no
+// function types, so the function is one given to the object outside pixie, and like
+// PICO-8's own functions its arguments and result are not checked.
+func (c *compiler) compileExprMemberCall(expr parser.ExprMemberCall) (err error) {
+	if err = c.checkExpressionValidMemberCall(expr); err != nil {
+		return
+	}
+
+	if err = c.compileExpr(expr.Callee); err != nil {
+		err = fmt.Errorf("failed to compile called expression: %w", err)
+		return
+	}
+	c.sb.WriteRune('(')
+	if err = c.compileCommaSeparatedExpressions(expr.Args); err != nil {
 		err = fmt.Errorf("failed to compile comma separated expressions: %w", err)
 		return
 	}
@@ -200,12 +1129,42 @@ func (c *compiler) compileStmtCallFunction(stmt parser.StmtCallFunction) (err er
 	return nil
 }
 
+// checkExpressionValidMemberCall checks that a member call is made on a property of an
+// object that is not one of its declared fields, which all hold values rather than
+// functions.
+func (c *compiler) checkExpressionValidMemberCall(expr parser.ExprMemberCall) (err error) {
+	property, isProperty := expr.Callee.(parser.ExprPropertyAccess)
+	if !isProperty {
+		dataType, err := c.accessDataType(expr.Callee)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: cannot call a value of type %s", ErrInvalidOperand, dataType.String())
+	}
+
+	objectType, err := c.accessDataType(property.Left)
+	if err != nil {
+		return
+	}
+	if _, isCustom := objectType.(shared.Custom); !isCustom {
+		return fmt.Errorf("%w: cannot call property %q of type %s", ErrInvalidOperand, property.Property, objectType.String())
+	}
+	if dataType, fieldErr := c.fieldDataType(objectType, property.Property); fieldErr == nil {
+		return fmt.Errorf("%w: cannot call field %q of type %s", ErrInvalidOperand, property.Property, dataType.String())
+	}
+	return nil
+}
+
 func (c *compiler) compileStmtVarDeclare(stmt parser.StmtVarDeclare) (err error) {
 	_, ok := c.variables[stmt.VariableName]
 	if ok {
 		err = fmt.Errorf("variable %q already exists", stmt.VariableName)
 		return
 	}
+	if _, ok := c.functions[stmt.VariableName]; ok {
+		err = fmt.Errorf("function %q already exists", stmt.VariableName)
+		return
+	}
 
 	variable := variable{
 		scope:    c.scope,
@@ -230,6 +1189,13 @@ func (c *compiler) compileStmtVarDeclare(stmt parser.StmtVarDeclare) (err error)
 			return
 		}
 	} else {
+		if _, isConditional := stmt.Expr.(parser.ExprConditional); isConditional || isEmptyLiteral(stmt.Expr) {
+			if err = c.checkExpressionValidDataType(variable.dataType, stmt.Expr); err != nil {
+				err = fmt.Errorf("%w: %w", ErrInvalidTypeAssign, err)
+				return
+			}
+		}
+
 		// Check if this is an incomplete object assignment that needs to be filled with zero values
 		if exprTable, isTable := stmt.Expr.(parser.ExprTable); isTable {
 			if customType, isCustom := variable.dataType.(shared.Custom); isCustom {
@@ -245,7 +1211,7 @@ func (c *compiler) compileStmtVarDeclare(stmt parser.StmtVarDeclare) (err error)
 			}
 		}
 
-		if err = c.compileExpr(stmt.Expr); err != nil {
+		if err = c.compileExprOfType(variable.dataType, stmt.Expr); err != nil {
 			err = fmt.Errorf("failed to parse expression: %w", err)
 			return
 		}
@@ -254,27 +1220,48 @@ func (c *compiler) compileStmtVarDeclare(stmt parser.StmtVarDeclare) (err error)
 	return nil
 }
 
+// isEmptyLiteral reports whether expr is an empty list or map literal, [] or {}. An empty
+// literal has no values to give it a type, so it takes the type it is declared as, which
+// must be a list, a map or an object.
+func isEmptyLiteral(expr parser.Expr) bool {
+	switch e := expr.(type) {
+	case parser.ExprList:
+		return len(e.Values) == 0
+	case parser.ExprTable:
+		return len(e.Pairs) == 0
+	default:
+		return false
+	}
+}
+
 func (c *compiler) compileStmtVarAssign(stmt parser.StmtVarAssign) (err error) {
 	v, ok := c.variables[stmt.VariableName]
 	if !ok {
-		err = fmt.Errorf("variable %q does not exist", stmt.VariableName)
+		err = lexer.Errorf(stmt.Pos, "variable %q does not exist", stmt.VariableName)
 		return
 	}
 
+	if _, isVariable := stmt.Target.(parser.ExprVariable); !isVariable {
+		return c.compileStmtTargetAssign(stmt)
+	}
+	if stmt.Operator != lexer.TokenType_Undefined {
+		return c.compileStmtVarCompoundAssign(stmt, v.dataType)
+	}
+
 	switch e := stmt.Expr.(type) {
 	case parser.ExprVariable:
 		ev, ok := c.variables[e.Name]
 		if !ok {
-			err = fmt.Errorf("variable %q does not exist", e.Name)
+			err = lexer.Errorf(e.Pos, "variable %q does not exist", e.Name)
 			return
 		}
 		if v.dataType.String() != ev.dataType.String() {
-			err = errors.Join(ErrInvalidTypeAssign, fmt.Errorf("wanted %q got %q", v.dataType.String(), ev.dataType.String()))
+			err = fmt.Errorf("%w: wanted %q got %q", ErrInvalidTypeAssign, v.dataType.String(), ev.dataType.String())
 			return
 		}
 	default:
 		if err = c.checkExpressionValidDataType(v.dataType, stmt.Expr); err != nil {
-			err = errors.Join(ErrInvalidTypeAssign, fmt.Errorf("%s", err.Error())) // for some reason it wouldn't show the second error when I joined it with the err variable
+			err = fmt.Errorf("%w: %w", ErrInvalidTypeAssign, err)
 			return
 		}
 	}
@@ -297,6 +1284,41 @@ func (c *compiler) compileStmtVarAssign(stmt parser.StmtVarAssign) (err error) {
 		}
 	}
 
+	if err = c.compileExprOfType(v.dataType, stmt.Expr); err != nil {
+		err = fmt.Errorf("failed to parse expression: %w", err)
+		return
+	}
+
+	return nil
+}
+
+// compileStmtVarCompoundAssign compiles an assignment such as x += 1 into PICO-8's
+// compound form, which costs fewer tokens than x = x + 1. It is type checked as that
+// longer assignment, so += on a str concatenates.
+func (c *compiler) compileStmtVarCompoundAssign(stmt parser.StmtVarAssign, dataType shared.DataType) (err error) {
+	expanded := parser.ExprBinary{
+		Left:     stmt.Target,
+		Operator: stmt.Operator,
+		Right:    stmt.Expr,
+	}
+	if err = c.checkExpressionValidDataType(dataType, expanded); err != nil {
+		err = fmt.Errorf("%w: %w", ErrInvalidTypeAssign, err)
+		return
+	}
+
+	operator, err := c.luaBinaryOperator(expanded)
+	if err != nil {
+		return
+	}
+
+	if err = c.compileExpr(stmt.Target); err != nil {
+		err = fmt.Errorf("failed to compile assignment target: %w", err)
+		return
+	}
+	c.sb.WriteRune(' ')
+	c.sb.WriteString(operator)
+	c.sb.WriteString("= ")
+
 	if err = c.compileExpr(stmt.Expr); err != nil {
 		err = fmt.Errorf("failed to parse expression: %w", err)
 		return
@@ -305,6 +1327,116 @@ func (c *compiler) compileStmtVarAssign(stmt parser.StmtVarAssign) (err error) {
 	return nil
 }
 
+// compileStmtTargetAssign compiles an assignment to an element of a list or map, or to a
+// field of an object. The target is written the way it is read, so list indices are
+// shifted to count from 1 just as they are by compileExprIndex.
+func (c *compiler) compileStmtTargetAssign(stmt parser.StmtVarAssign) (err error) {
+	dataType, err := c.accessDataType(stmt.Target)
+	if err != nil {
+		return
+	}
+	if index, isIndex := stmt.Target.(parser.ExprIndex); isIndex {
+		leftType, _ := c.accessDataType(index.Left)
+		if _, isString := leftType.(shared.String); isString {
+			err = fmt.Errorf("%w: the characters of a str cannot be assigned to", ErrInvalidTypeAssign)
+			return
+		}
+	}
+
+	if stmt.Operator != lexer.TokenType_Undefined {
+		return c.compileStmtVarCompoundAssign(stmt, dataType)
+	}
+
+	if err = c.checkExpressionAssignable(dataType, stmt.Expr); err != nil {
+		err = fmt.Errorf("%w: %w", ErrInvalidTypeAssign, err)
+		return
+	}
+
+	if err = c.compileExpr(stmt.Target); err != nil {
+		err = fmt.Errorf("failed to compile assignment target: %w", err)
+		return
+	}
+	c.sb.WriteString(" = ")
+
+	// Check if this is an incomplete object assignment that needs to be filled with zero values
+	if exprTable, isTable := stmt.Expr.(parser.ExprTable); isTable {
+		if customType, isCustom := dataType.(shared.Custom); isCustom {
+			if _, isObject := c.objects[customType.Name]; isObject {
+				if err = c.compileExprTableWithZeroValues(exprTable, customType); err != nil {
+					err = fmt.Errorf("failed to compile expression table with zero values: %w", err)
+					return
+				}
+				return nil
+			}
+		}
+	}
+
+	if err = c.compileExprOfType(dataType, stmt.Expr); err != nil {
+		err = fmt.Errorf("failed to parse expression: %w", err)
+		return
+	}
+	return nil
+}
+
+// accessDataType returns the data type of a variable or of an element or field reached
+// from it by indexing and property access, as in grid[y][x] or enemies[0].pos.x. List
+// indices must be numbers and map keys must match the key type of the map.
+func (c *compiler) accessDataType(expr parser.Expr) (dataType shared.DataType, err error) {
+	switch e := expr.(type) {
+	case parser.ExprVariable:
+		varInfo, exists := c.variables[e.Name]
+		if !exists {
+			err = lexer.Errorf(e.Pos, "variable %q does not exist", e.Name)
+			return
+		}
+		return varInfo.dataType, nil
+	case parser.ExprCall:
+		return c.callDataType(e)
+	case parser.ExprMemberCall:
+		err = fmt.Errorf("return type of a member call is not known")
+		return
+	case parser.ExprConditional:
+		return c.conditionalDataType(e)
+	case parser.ExprPropertyAccess:
+		var leftType shared.DataType
+		if leftType, err = c.accessDataType(e.Left); err != nil {
+			return
+		}
+		return c.fieldDataType(leftType, e.Property)
+	case parser.ExprIndex:
+		var leftType shared.DataType
+		if leftType, err = c.accessDataType(e.Left); err != nil {
+			return
+		}
+		switch t := leftType.(type) {
+		case shared.List:
+			if err = c.checkExpressionAssignable(shared.Number{}, e.Index); err != nil {
+				err = fmt.Errorf("list index must be a number: %v", err)
+				return
+			}
+			return t.ListType, nil
+		case shared.Map:
+			if err = c.checkExpressionAssignable(t.KeyType, e.Index); err != nil {
+				err = fmt.Errorf("map key must be %s: %v", t.KeyType.String(), err)
+				return
+			}
+			return t.ValueType, nil
+		case shared.String:
+			if err = c.checkExpressionAssignable(shared.Number{}, e.Index); err != nil {
+				err = fmt.Errorf("string index must be a number: %v", err)
+				return
+			}
+			return shared.String{}, nil
+		default:
+			err = fmt.Errorf("indexing is not supported on type %s", leftType.String())
+			return
+		}
+	default:
+		err = fmt.Errorf("expected a variable, index or property, got %T", expr)
+		return
+	}
+}
+
 func (c *compiler) compileStmtObjDefine(stmt parser.StmtObjDefine) (err error) {
 	if _, ok := c.objects[stmt.Name]; ok {
 		err = fmt.Errorf("object definition %q already exists", stmt.Name)
@@ -327,17 +1459,46 @@ func (c *compiler) compileExprBlock(expr parser.ExprBlock) (err error) {
 }
 
 func (c *compiler) compileExprNumber(expr parser.ExprNumber) (err error) {
-	c.sb.WriteString(expr.Value)
+	lua, err := luaNumber(expr.Value)
+	if err != nil {
+		return
+	}
+	c.sb.WriteString(lua)
 	return nil
 }
 
 func (c *compiler) compileExprString(expr parser.ExprString) (err error) {
-	c.sb.WriteRune('"')
-	c.sb.WriteString(expr.Value)
-	c.sb.WriteRune('"')
+	lua, err := luaString(expr.Value)
+	if err != nil {
+		return
+	}
+	c.sb.WriteString(lua)
 	return nil
 }
 
+// luaString encodes s as a PICO-8 string literal. Every character must have a P8SCII
+// encoding. Control codes are escaped and glyphs are written in the form PICO-8 uses for
+// them in .p8 files.
+func luaString(s string) (lua string, err error) {
+	var sb strings.Builder
+	sb.WriteRune('"')
+	for len(s) > 0 {
+		b, size, err := p8scii.Byte(s)
+		if err != nil {
+			return "", err
+		}
+		s = s[size:]
+
+		if escaped, ok := luaEscapes[b]; ok {
+			sb.WriteString(escaped)
+			continue
+		}
+		sb.WriteString(p8scii.String(b))
+	}
+	sb.WriteRune('"')
+	return sb.String(), nil
+}
+
 func (c *compiler) compileExprBoolean(expr parser.ExprBoolean) (err error) {
 	c.sb.WriteString(expr.Value)
 	return nil
@@ -380,6 +1541,14 @@ func (c *compiler) compileExprTable(expr parser.ExprTable) (err error) {
 				return
 			}
 			c.sb.WriteRune('"')
+		case parser.ExprUnary:
+			if err = c.compileExprUnary(k); err != nil {
+				err = fmt.Errorf("failed to compile key: %w", err)
+				return
+			}
+		default:
+			err = fmt.Errorf("%w: table keys must be literals or labels, got %T", ErrInvalidOperand, k)
+			return
 		}
 
 		c.sb.WriteRune(':')
@@ -482,6 +1651,15 @@ func (c *compiler) checkExpressionValidDataType(dataType shared.DataType, expr p
 	if indexExpr, isIndex := expr.(parser.ExprIndex); isIndex {
 		return c.checkExpressionValidIndex(dataType, indexExpr)
 	}
+	if callExpr, isCall := expr.(parser.ExprCall); isCall {
+		return c.checkExpressionValidCall(dataType, callExpr)
+	}
+	if callExpr, isCall := expr.(parser.ExprMemberCall); isCall {
+		return c.checkExpressionValidMemberCall(callExpr)
+	}
+	if conditionalExpr, isConditional := expr.(parser.ExprConditional); isConditional {
+		return c.checkExpressionValidConditional(dataType, conditionalExpr)
+	}
 
 	switch d := dataType.(type) {
 	case shared.Number:
@@ -519,6 +1697,10 @@ func (c *compiler) checkExpressionValidIndex(expectedType shared.DataType, expr
 		}
 	case parser.ExprString:
 		leftDataType = shared.String{}
+	case parser.ExprCall, parser.ExprIndex, parser.ExprPropertyAccess:
+		if leftDataType, err = c.accessDataType(left); err != nil {
+			return err
+		}
 	// For other expressions, we rely on the type checking system to determine compatibility
 	default:
 		// For now, we'll limit indexing support to variables and string literals
@@ -559,6 +1741,13 @@ func (c *compiler) checkExpressionValidIndex(expectedType shared.DataType, expr
 				leftType.ListType.String(), leftType.ListType.String(), expectedType.String())
 		}
 		return nil
+	case shared.Map:
+		// Indexing a map should return a value of the map's value type
+		if expectedType.String() != leftType.ValueType.String() {
+			return fmt.Errorf("indexing map of %s returns %s, but expected %s",
+				leftType.ValueType.String(), leftType.ValueType.String(), expectedType.String())
+		}
+		return nil
 	case shared.String:
 		// Indexing a string should return a string (a character)
 		if _, isString := expectedType.(shared.String); !isString {
@@ -595,6 +1784,13 @@ func (c *compiler) checkExpressionValidNumber(dataType shared.Number, expr parse
 			}
 			return nil
 		}
+	case parser.ExprUnary:
+		if e.Operator == lexer.TokenType_Bang {
+			return fmt.Errorf("expected %s got logical not", dataType.String())
+		}
+		return c.checkExpressionValidUnary(e)
+	case parser.ExprBlock:
+		return c.checkExpressionValidDataType(dataType, e.Value)
 	default:
 		return fmt.Errorf("expected %s got %T", dataType.String(), e)
 	}
@@ -624,6 +1820,30 @@ func (c *compiler) checkExpressionValidNumberForBinary(expr parser.Expr) (err er
 			}
 		}
 		return fmt.Errorf("expected number, got variable of different type")
+	case parser.ExprPropertyAccess:
+		dataType, err := c.accessDataType(e)
+		if err != nil {
+			return err
+		}
+		if _, isNumber := dataType.(shared.Number); isNumber {
+			return nil
+		}
+		return fmt.Errorf("expected number, got property of type %s", dataType.String())
+	case parser.ExprUnary:
+		if e.Operator == lexer.TokenType_Bang {
+			return fmt.Errorf("expected number, got logical not")
+		}
+		return c.checkExpressionValidUnary(e)
+	case parser.ExprBlock:
+		return c.checkExpressionValidNumberForBinary(e.Value)
+	case parser.ExprIndex:
+		return c.checkExpressionValidIndex(shared.Number{}, e)
+	case parser.ExprCall:
+		return c.checkExpressionValidCall(shared.Number{}, e)
+	case parser.ExprMemberCall:
+		return c.checkExpressionValidMemberCall(e)
+	case parser.ExprConditional:
+		return c.checkExpressionValidConditional(shared.Number{}, e)
 	default:
 		return fmt.Errorf("expected number, got %T", e)
 	}
@@ -657,6 +1877,8 @@ func (c *compiler) checkExpressionValidString(dataType shared.String, expr parse
 		} else {
 			return fmt.Errorf("expected %s got binary operation with operator %v", dataType.String(), e.Operator)
 		}
+	case parser.ExprBlock:
+		return c.checkExpressionValidDataType(dataType, e.Value)
 	default:
 		return fmt.Errorf("expected %s got %T", dataType.String(), e)
 	}
@@ -690,6 +1912,23 @@ func (c *compiler) checkExpressionValidStringForBinary(expr parser.Expr) (err er
 			}
 		}
 		return fmt.Errorf("expected string, got variable of different type")
+	case parser.ExprPropertyAccess:
+		dataType, err := c.accessDataType(e)
+		if err != nil {
+			return err
+		}
+		if _, isString := dataType.(shared.String); isString {
+			return nil
+		}
+		return fmt.Errorf("expected string, got property of type %s", dataType.String())
+	case parser.ExprIndex:
+		return c.checkExpressionValidIndex(shared.String{}, e)
+	case parser.ExprCall:
+		return c.checkExpressionValidCall(shared.String{}, e)
+	case parser.ExprMemberCall:
+		return c.checkExpressionValidMemberCall(e)
+	case parser.ExprConditional:
+		return c.checkExpressionValidConditional(shared.String{}, e)
 	default:
 		return fmt.Errorf("expected string, got %T", e)
 	}
@@ -700,6 +1939,13 @@ func (c *compiler) checkExpressionValidBoolean(dataType shared.Boolean, expr par
 	case parser.ExprBoolean:
 		return nil
 	case parser.ExprBinary:
+		// Boolean values can come from logical operations on booleans
+		if c.isLogicalOperator(e.Operator) {
+			if err = c.checkExpressionValidDataType(dataType, e.Left); err != nil {
+				return err
+			}
+			return c.checkExpressionValidDataType(dataType, e.Right)
+		}
 		// Boolean values can come from relational operations
 		if c.isRelationalOperator(e.Operator) {
 			// For relational operators, check if both operands are valid for comparison
@@ -712,6 +1958,32 @@ func (c *compiler) checkExpressionValidBoolean(dataType shared.Boolean, expr par
 			return nil
 		}
 		return fmt.Errorf("expected %s got binary operation with operator %v", dataType.String(), e.Operator)
+	case parser.ExprUnary:
+		if e.Operator != lexer.TokenType_Bang {
+			return fmt.Errorf("expected %s got unary operation with operator %v", dataType.String(), e.Operator)
+		}
+		return c.checkExpressionValidUnary(e)
+	case parser.ExprVariable:
+		if varInfo, exists := c.variables[e.Name]; exists {
+			if _, isBoolean := varInfo.dataType.(shared.Boolean); isBoolean {
+				return nil
+			}
+			return fmt.Errorf("expected %s got variable of type %s", dataType.String(), varInfo.dataType.String())
+		}
+		return fmt.Errorf("variable %q does not exist", e.Name)
+	case parser.ExprPropertyAccess:
+		propertyType, err := c.accessDataType(e)
+		if err != nil {
+			return err
+		}
+		if _, isBoolean := propertyType.(shared.Boolean); isBoolean {
+			return nil
+		}
+		return fmt.Errorf("expected %s got property of type %s", dataType.String(), propertyType.String())
+	case parser.ExprBlock:
+		return c.checkExpressionValidDataType(dataType, e.Value)
+	case parser.ExprConditional:
+		return c.checkExpressionValidConditional(dataType, e)
 	default:
 		return fmt.Errorf("expected %s got %T", dataType.String(), e)
 	}
@@ -842,17 +2114,11 @@ func (c *compiler) compileObjectZeroValue(dataType shared.Custom) (err error) {
 }
 
 func (c *compiler) compileExprIndex(expr parser.ExprIndex) (err error) {
-	// Determine if we're indexing a string variable to use string.sub function
-	isStringIndexing := false
-
-	// Check if the left side is a variable of string type
-	if varExpr, isVar := expr.Left.(parser.ExprVariable); isVar {
-		if variable, exists := c.variables[varExpr.Name]; exists {
-			if _, isString := variable.dataType.(shared.String); isString {
-				isStringIndexing = true
-			}
-		}
-	}
+	// The type of the left side decides how it is indexed
+	leftType, _ := c.accessDataType(expr.Left)
+
+	// Determine if we're indexing a string to use string.sub function
+	_, isStringIndexing := leftType.(shared.String)
 
 	if isStringIndexing {
 		// For string indexing, use string.sub function in Lua
@@ -901,16 +2167,7 @@ func (c *compiler) compileExprIndex(expr parser.ExprIndex) (err error) {
 	} else {
 		// For lists and maps, use standard indexing with bracket notation
 		// Check if the container is a list to determine if index adjustment is needed
-		needsIndexAdjustment := false
-
-		if varExpr, isVar := expr.Left.(parser.ExprVariable); isVar {
-			if variable, exists := c.variables[varExpr.Name]; exists {
-				// Check if the variable is of list type
-				if _, isList := variable.dataType.(shared.List); isList {
-					needsIndexAdjustment = true
-				}
-			}
-		}
+		_, needsIndexAdjustment := leftType.(shared.List)
 
 		if err = c.compileExpr(expr.Left); err != nil {
 			err = fmt.Errorf("failed to compile left side of index: %w", err)
@@ -965,60 +2222,455 @@ func (c *compiler) compileExprPropertyAccess(expr parser.ExprPropertyAccess) (er
 	return nil
 }
 
+// fieldDataType returns the data type of a field of an object type.
+func (c *compiler) fieldDataType(objectType shared.DataType, property string) (dataType shared.DataType, err error) {
+	customType, isCustom := objectType.(shared.Custom)
+	if !isCustom {
+		err = fmt.Errorf("type %s has no property %q", objectType.String(), property)
+		return
+	}
+	obj, exists := c.objects[customType.Name]
+	if !exists {
+		err = fmt.Errorf("object definition %q does not exist", customType.Name)
+		return
+	}
+	for _, field := range obj.fields {
+		if field.Field == property {
+			return field.Type, nil
+		}
+	}
+	err = fmt.Errorf("object %q has no field %q", customType.Name, property)
+	return
+}
+
 func (c *compiler) compileExprBinary(expr parser.ExprBinary) (err error) {
+	if c.isLogicalOperator(expr.Operator) {
+		// && and || only make sense on booleans, Lua's and/or would happily return the other operand
+		for _, operand := range []parser.Expr{expr.Left, expr.Right} {
+			if err = c.checkExpressionValidDataType(shared.Boolean{}, operand); err != nil {
+				err = fmt.Errorf("%w: %s expects bool: %v", ErrInvalidOperand, lexer.TokenTypeString[expr.Operator], err)
+				return
+			}
+		}
+	}
+
+	if c.isNumberOperator(expr.Operator) {
+		for _, operand := range []parser.Expr{expr.Left, expr.Right} {
+			if err = c.checkExpressionValidNumberForBinary(operand); err != nil {
+				err = fmt.Errorf("%w: %s expects num: %v", ErrInvalidOperand, lexer.TokenTypeString[expr.Operator], err)
+				return
+			}
+		}
+	}
+
+	operator, err := c.luaBinaryOperator(expr)
+	if err != nil {
+		return
+	}
+
 	// Compile the left operand
-	if err = c.compileExpr(expr.Left); err != nil {
+	if err = c.compileExprOperand(expr.Left, luaPrecedence[operator], false); err != nil {
 		err = fmt.Errorf("failed to compile left side of binary expression: %w", err)
 		return
 	}
 
 	// Add space, operator, space
 	c.sb.WriteRune(' ')
+	c.sb.WriteString(operator)
+	c.sb.WriteRune(' ')
+
+	// Compile the right operand
+	if err = c.compileExprOperand(expr.Right, luaPrecedence[operator], true); err != nil {
+		err = fmt.Errorf("failed to compile right side of binary expression: %w", err)
+		return
+	}
+
+	return nil
+}
+
+// compileExprConditional compiles a conditional expression whose type is worked out from
+// its branches.
+func (c *compiler) compileExprConditional(expr parser.ExprConditional) (err error) {
+	dataType, err := c.conditionalDataType(expr)
+	if err != nil {
+		return
+	}
+	return c.compileConditional(dataType, expr)
+}
+
+// compileExprOfType compiles an expression whose type is known from where it is used, such
+// as the declared type of the variable it is assigned to, after it has been checked
+// against that type. A conditional expression takes the type instead of working it out
+// from its branches, so a ? [] : [] can be assigned to a list.
+func (c *compiler) compileExprOfType(dataType shared.DataType, expr parser.Expr) (err error) {
+	conditional, isConditional := expr.(parser.ExprConditional)
+	if !isConditional {
+		return c.compileExpr(expr)
+	}
+
+	c.mark(conditional.Position())
+	if err = c.compileConditional(dataType, conditional); err != nil {
+		err = lexer.ErrorAt(conditional.Position(), fmt.Errorf("failed to compile expression conditional: %w", err))
+		return
+	}
+	return nil
+}
+
+// compileConditional compiles a conditional expression of dataType. Lua's c and a or b
+// gives b whenever a is false or nil, so it is only used when the true branch can be
+// neither; otherwise the branches go in a function that is called straight away.
+func (c *compiler) compileConditional(dataType shared.DataType, expr parser.ExprConditional) (err error) {
+	if c.neverFalse(expr.Then) {
+		if err = c.compileExprOperand(expr.Condition, luaPrecedence["and"], false); err != nil {
+			err = fmt.Errorf("failed to compile condition: %w", err)
+			return
+		}
+		c.sb.WriteString(" and ")
+		if err = c.compileConditionalBranch(dataType, expr.Then, luaPrecedence["and"]); err != nil {
+			err = fmt.Errorf("failed to compile true branch: %w", err)
+			return
+		}
+		c.sb.WriteString(" or ")
+		if err = c.compileConditionalBranch(dataType, expr.Else, luaPrecedence["or"]); err != nil {
+			err = fmt.Errorf("failed to compile false branch: %w", err)
+			return
+		}
+		return nil
+	}
+
+	c.sb.WriteString("(function() if ")
+	if err = c.compileExpr(expr.Condition); err != nil {
+		err = fmt.Errorf("failed to compile condition: %w", err)
+		return
+	}
+	c.sb.WriteString(" then return ")
+	if err = c.compileConditionalBranch(dataType, expr.Then, 0); err != nil {
+		err = fmt.Errorf("failed to compile true branch: %w", err)
+		return
+	}
+	c.sb.WriteString(" end return ")
+	if err = c.compileConditionalBranch(dataType, expr.Else, 0); err != nil {
+		err = fmt.Errorf("failed to compile false branch: %w", err)
+		return
+	}
+	c.sb.WriteString(" end)()")
+	return nil
+}
+
+// compileConditionalBranch compiles a branch of a conditional expression of dataType as the
+// right operand of an operator with the given Lua precedence. An object literal is filled
+// with zero values, as when it is assigned. A nested conditional takes the same type, and
+// when it is written as c and a or b it is an or expression, so as the true branch of
+// another it needs brackets.
+func (c *compiler) compileConditionalBranch(dataType shared.DataType, branch parser.Expr, precedence int) (err error) {
+	if conditional, isConditional := branch.(parser.ExprConditional); isConditional {
+		brackets := c.neverFalse(conditional.Then) && luaPrecedence["or"] < precedence
+		if brackets {
+			c.sb.WriteRune('(')
+		}
+		if err = c.compileExprOfType(dataType, conditional); err != nil {
+			return
+		}
+		if brackets {
+			c.sb.WriteRune(')')
+		}
+		return nil
+	}
+	if exprTable, isTable := branch.(parser.ExprTable); isTable {
+		if customType, isCustom := dataType.(shared.Custom); isCustom {
+			if _, isObject := c.objects[customType.Name]; isObject {
+				return c.compileExprTableWithZeroValues(exprTable, customType)
+			}
+		}
+	}
+	return c.compileExprOperand(branch, precedence, true)
+}
+
+// neverFalse reports whether expr is certain to be neither false nor nil: a literal other
+// than false, or arithmetic on numbers.
+func (c *compiler) neverFalse(expr parser.Expr) bool {
+	switch e := expr.(type) {
+	case parser.ExprNumber, parser.ExprString, parser.ExprList, parser.ExprTable:
+		return true
+	case parser.ExprBoolean:
+		return e.Value == shared.Keyword_True
+	case parser.ExprBlock:
+		return c.neverFalse(e.Value)
+	case parser.ExprUnary:
+		return e.Operator != lexer.TokenType_Bang
+	case parser.ExprBinary:
+		return e.Operator == lexer.TokenType_Plus || c.isNumberOperator(e.Operator)
+	case parser.ExprConditional:
+		return c.neverFalse(e.Then) && c.neverFalse(e.Else)
+	default:
+		return false
+	}
+}
+
+// conditionalDataType checks a conditional expression whose type is not known from where
+// it is used and returns its type. The branches must have the same type, which is worked
+// out from whichever branch it can be.
+func (c *compiler) conditionalDataType(expr parser.ExprConditional) (dataType shared.DataType, err error) {
+	if dataType, err = c.inferDataType(expr.Then); err != nil {
+		if dataType, err = c.inferDataType(expr.Else); err != nil {
+			err = fmt.Errorf("type of conditional expression cannot be worked out: %w", err)
+			return
+		}
+	}
+
+	for _, branch := range []parser.Expr{expr.Then, expr.Else} {
+		if err = c.checkConditionalBranch(dataType, branch); err != nil {
+			err = lexer.ErrorAt(branch.Position(), fmt.Errorf("%w: branches of conditional expression must both be %s: %w", ErrInvalidOperand, dataType.String(), err))
+			return
+		}
+	}
+	if err = c.checkExpressionValidConditional(dataType, expr); err != nil {
+		return
+	}
+	return dataType, nil
+}
+
+// checkExpressionValidConditional checks that the condition of a conditional expression is
+// a bool and that both of its branches are of dataType, the type it is used as.
+func (c *compiler) checkExpressionValidConditional(dataType shared.DataType, expr parser.ExprConditional) (err error) {
+	if err = c.checkExpressionValidBoolean(shared.Boolean{}, expr.Condition); err != nil {
+		err = lexer.ErrorAt(expr.Condition.Position(), fmt.Errorf("%w: expected bool: %v", ErrInvalidCondition, err))
+		return
+	}
+
+	for _, branch := range []parser.Expr{expr.Then, expr.Else} {
+		if err = c.checkConditionalBranch(dataType, branch); err != nil {
+			err = lexer.ErrorAt(branch.Position(), fmt.Errorf("conditional expression must be %s: %w", dataType.String(), err))
+			return
+		}
+	}
+	return nil
+}
+
+// checkConditionalBranch checks that a branch of a conditional expression is of dataType.
+// A branch whose type can be worked out must have exactly that type, since the checks for
+// numbers let comparisons such as 2 == 1 through, and is reported by that type.
+func (c *compiler) checkConditionalBranch(dataType shared.DataType, branch parser.Expr) (err error) {
+	if branchType, inferErr := c.inferDataType(branch); inferErr == nil && branchType.String() != dataType.String() {
+		return fmt.Errorf("got %s", branchType.String())
+	}
+	return c.checkExpressionAssignable(dataType, branch)
+}
+
+// inferDataType works out the type of an expression from what it is made of. Empty
+// literals, objects and calls to PICO-8's own functions have no type of their own.
+func (c *compiler) inferDataType(expr parser.Expr) (dataType shared.DataType, err error) {
+	switch e := expr.(type) {
+	case parser.ExprNumber:
+		return shared.Number{}, nil
+	case parser.ExprString:
+		return shared.String{}, nil
+	case parser.ExprBoolean:
+		return shared.Boolean{}, nil
+	case parser.ExprBlock:
+		return c.inferDataType(e.Value)
+	case parser.ExprUnary:
+		if e.Operator == lexer.TokenType_Bang {
+			return shared.Boolean{}, nil
+		}
+		return shared.Number{}, nil
+	case parser.ExprBinary:
+		switch {
+		case c.isLogicalOperator(e.Operator), c.isRelationalOperator(e.Operator):
+			return shared.Boolean{}, nil
+		case e.Operator == lexer.TokenType_Plus && (c.isStringExpression(e.Left) || c.isStringExpression(e.Right)):
+			return shared.String{}, nil
+		default:
+			return shared.Number{}, nil
+		}
+	case parser.ExprList:
+		if len(e.Values) == 0 {
+			err = fmt.Errorf("type of an empty list is not known")
+			return
+		}
+		var listType shared.DataType
+		if listType, err = c.inferDataType(e.Values[0]); err != nil {
+			return
+		}
+		return shared.List{ListType: listType}, nil
+	case parser.ExprTable:
+		err = fmt.Errorf("type of a map or object literal is not known")
+		return
+	default:
+		return c.accessDataType(expr)
+	}
+}
+
+// luaPrecedence is how tightly PICO-8's Lua binds each binary operator, higher binds
+// tighter. The unary operators sit between * and ^.
+var luaPrecedence = map[string]int{
+	"or":  1,
+	"and": 2,
+	"==":  3,
+	"~=":  3,
+	"<":   3,
+	"<=":  3,
+	">":   3,
+	">=":  3,
+	"|":   4,
+	"^^":  5,
+	"&":   6,
+	"<<":  7,
+	">>":  7,
+	">>>": 7,
+	"<<>": 7,
+	">><": 7,
+	"..":  8,
+	"+":   9,
+	"-":   9,
+	"*":   10,
+	"/":   10,
+	"\\":  10,
+	"%":   10,
+	"^":   11,
+}
+
+// compileExprOperand compiles an operand of a binary operator with the given Lua
+// precedence. The parser has already grouped the operands by pixie's precedence, so an
+// operand is put in parentheses whenever Lua would otherwise group it differently: when
+// it binds looser than the operator, or as tightly on the right, since every operator is
+// left associative in pixie.
+func (c *compiler) compileExprOperand(operand parser.Expr, precedence int, right bool) (err error) {
+	binary, ok := operand.(parser.ExprBinary)
+	if !ok {
+		return c.compileExpr(operand)
+	}
+
+	operator, err := c.luaBinaryOperator(binary)
+	if err != nil {
+		return
+	}
+	if luaPrecedence[operator] > precedence || luaPrecedence[operator] == precedence && !right {
+		return c.compileExpr(operand)
+	}
 
-	// Map operator tokens to Lua operators
+	c.sb.WriteRune('(')
+	if err = c.compileExpr(operand); err != nil {
+		return
+	}
+	c.sb.WriteRune(')')
+	return nil
+}
+
+// luaBinaryOperator maps the operator of a binary expression to its Lua operator.
+func (c *compiler) luaBinaryOperator(expr parser.ExprBinary) (operator string, err error) {
 	switch expr.Operator {
 	case lexer.TokenType_Plus:
 		// For string concatenation, Lua uses .. instead of +
 		// Check if either operand is a string
 		if c.isStringExpression(expr.Left) || c.isStringExpression(expr.Right) {
-			c.sb.WriteString("..")
-		} else {
-			c.sb.WriteString("+")
+			return "..", nil
 		}
+		return "+", nil
 	case lexer.TokenType_Minus:
-		c.sb.WriteString("-")
+		return "-", nil
 	case lexer.TokenType_Asterisk:
-		c.sb.WriteString("*")
+		return "*", nil
 	case lexer.TokenType_ForwardSlash:
-		c.sb.WriteString("/")
+		return "/", nil
 	case lexer.TokenType_EqualEqual:
-		c.sb.WriteString("==")
+		return "==", nil
 	case lexer.TokenType_BangEqual:
-		c.sb.WriteString("~=")
+		return "~=", nil
 	case lexer.TokenType_LessThan:
-		c.sb.WriteString("<")
+		return "<", nil
 	case lexer.TokenType_LessThanEqual:
-		c.sb.WriteString("<=")
+		return "<=", nil
 	case lexer.TokenType_GreaterThan:
-		c.sb.WriteString(">")
+		return ">", nil
 	case lexer.TokenType_GreaterThanEqual:
-		c.sb.WriteString(">=")
+		return ">=", nil
+	case lexer.TokenType_Percent:
+		return "%", nil
+	case lexer.TokenType_Caret:
+		return "^", nil
+	case lexer.TokenType_Backslash:
+		return "\\", nil
+	case lexer.TokenType_Ampersand:
+		return "&", nil
+	case lexer.TokenType_Pipe:
+		return "|", nil
+	case lexer.TokenType_CaretCaret:
+		return "^^", nil
+	case lexer.TokenType_LessThanLessThan:
+		return "<<", nil
+	case lexer.TokenType_GreaterThanGreaterThan:
+		return ">>", nil
+	case lexer.TokenType_GreaterThanGreaterThanGreaterThan:
+		return ">>>", nil
+	case lexer.TokenType_LessThanLessThanGreaterThan:
+		return "<<>", nil
+	case lexer.TokenType_GreaterThanGreaterThanLessThan:
+		return ">><", nil
+	case lexer.TokenType_AmpersandAmpersand:
+		return "and", nil
+	case lexer.TokenType_PipePipe:
+		return "or", nil
 	default:
 		err = fmt.Errorf("unknown binary operator: %v", expr.Operator)
 		return
 	}
+}
 
-	c.sb.WriteRune(' ')
+func (c *compiler) compileExprUnary(expr parser.ExprUnary) (err error) {
+	if err = c.checkExpressionValidUnary(expr); err != nil {
+		return
+	}
 
-	// Compile the right operand
-	if err = c.compileExpr(expr.Right); err != nil {
-		err = fmt.Errorf("failed to compile right side of binary expression: %w", err)
+	switch expr.Operator {
+	case lexer.TokenType_Minus:
+		c.sb.WriteString("-")
+		// Lua reads -- as the start of a comment, so a negated negation needs a space
+		if operand, ok := expr.Operand.(parser.ExprUnary); ok && operand.Operator == lexer.TokenType_Minus {
+			c.sb.WriteRune(' ')
+		}
+		// A negated literal is range checked as one number, so -32768 is allowed
+		if operand, ok := expr.Operand.(parser.ExprNumber); ok {
+			var lua string
+			if lua, err = luaNegatedNumber(operand.Value); err != nil {
+				return
+			}
+			c.sb.WriteString(lua)
+			return nil
+		}
+	case lexer.TokenType_Bang:
+		c.sb.WriteString("not ")
+	case lexer.TokenType_Tilde:
+		c.sb.WriteString("~")
+	default:
+		err = fmt.Errorf("unknown unary operator: %v", expr.Operator)
+		return
+	}
+
+	if err = c.compileExpr(expr.Operand); err != nil {
+		err = fmt.Errorf("failed to compile operand of unary expression: %w", err)
 		return
 	}
 
 	return nil
 }
 
+// checkExpressionValidUnary checks the operand of a unary expression: ! needs a boolean,
+// and - and ~ need a number.
+func (c *compiler) checkExpressionValidUnary(expr parser.ExprUnary) (err error) {
+	if expr.Operator == lexer.TokenType_Bang {
+		if err = c.checkExpressionValidDataType(shared.Boolean{}, expr.Operand); err != nil {
+			return fmt.Errorf("%w: ! expects bool: %v", ErrInvalidOperand, err)
+		}
+		return nil
+	}
+
+	if err = c.checkExpressionValidNumberForBinary(expr.Operand); err != nil {
+		return fmt.Errorf("%w: %s expects num: %v", ErrInvalidOperand, lexer.TokenTypeString[expr.Operator], err)
+	}
+	return nil
+}
+
 // Helper function to check if an expression is a string literal
 func (c *compiler) isStringExpression(expr parser.Expr) bool {
 	switch e := expr.(type) {
@@ -1031,6 +2683,13 @@ func (c *compiler) isStringExpression(expr parser.Expr) bool {
 			return isString
 		}
 		return false
+	case parser.ExprCall, parser.ExprIndex, parser.ExprPropertyAccess, parser.ExprConditional:
+		dataType, err := c.accessDataType(e)
+		if err != nil {
+			return false
+		}
+		_, isString := dataType.(shared.String)
+		return isString
 	default:
 		return false
 	}
@@ -1059,6 +2718,21 @@ func (c *compiler) getExpressionType(expr parser.Expr) shared.DataType {
 			// to infer the type from the first element
 		}
 		return shared.List{}
+	case parser.ExprUnary:
+		if e.Operator == lexer.TokenType_Bang {
+			return shared.Boolean{}
+		}
+		return shared.Number{}
+	case parser.ExprCall:
+		if dataType, err := c.callDataType(e); err == nil {
+			return dataType
+		}
+		return shared.String{} // Default fallback
+	case parser.ExprConditional:
+		if dataType, err := c.conditionalDataType(e); err == nil {
+			return dataType
+		}
+		return shared.String{} // Default fallback
 	default:
 		// For other expressions like binary operations, we'd need more complex type inference
 		// For now, return a default
@@ -1076,6 +2750,25 @@ func (c *compiler) isRelationalOperator(operator int) bool {
 		   operator == lexer.TokenType_GreaterThanEqual
 }
 
+// Helper function to check if an operator only works on numbers
+func (c *compiler) isNumberOperator(operator int) bool {
+	switch operator {
+	case lexer.TokenType_Minus, lexer.TokenType_Asterisk, lexer.TokenType_ForwardSlash,
+		lexer.TokenType_Percent, lexer.TokenType_Caret, lexer.TokenType_Backslash,
+		lexer.TokenType_Ampersand, lexer.TokenType_Pipe, lexer.TokenType_CaretCaret,
+		lexer.TokenType_LessThanLessThan, lexer.TokenType_GreaterThanGreaterThan, lexer.TokenType_GreaterThanGreaterThanGreaterThan,
+		lexer.TokenType_LessThanLessThanGreaterThan, lexer.TokenType_GreaterThanGreaterThanLessThan:
+		return true
+	}
+	return false
+}
+
+// Helper function to check if an operator is a logical operator
+func (c *compiler) isLogicalOperator(operator int) bool {
+	return operator == lexer.TokenType_AmpersandAmpersand ||
+		operator == lexer.TokenType_PipePipe
+}
+
 // Helper function to check if an expression is valid for comparison operations
 func (c *compiler) checkExpressionValidForComparison(expr parser.Expr) (err error) {
 	switch e := expr.(type) {
@@ -1095,6 +2788,10 @@ func (c *compiler) checkExpressionValidForComparison(expr parser.Expr) (err erro
 		return fmt.Errorf("variable %q does not exist", e.Name)
 	case parser.ExprBinary:
 		// For binary expressions that result in comparable types
+		// Logical operations return booleans, which are comparable
+		if c.isLogicalOperator(e.Operator) {
+			return c.checkExpressionValidBoolean(shared.Boolean{}, e)
+		}
 		// Check if it's a relational operation (which returns boolean and is comparable)
 		if c.isRelationalOperator(e.Operator) {
 			// Recursively check both operands
@@ -1107,8 +2804,8 @@ func (c *compiler) checkExpressionValidForComparison(expr parser.Expr) (err erro
 			return nil
 		}
 		// For arithmetic expressions (which result in numbers and are comparable)
-		switch e.Operator {
-		case lexer.TokenType_Plus, lexer.TokenType_Minus, lexer.TokenType_Asterisk, lexer.TokenType_ForwardSlash:
+		switch {
+		case e.Operator == lexer.TokenType_Plus, c.isNumberOperator(e.Operator):
 			// Check if both operands are valid for arithmetic operations
 			if err = c.checkExpressionValidNumberForBinary(e.Left); err != nil {
 				return err
@@ -1120,6 +2817,38 @@ func (c *compiler) checkExpressionValidForComparison(expr parser.Expr) (err erro
 		default:
 			return fmt.Errorf("binary expression with operator %v cannot be compared", e.Operator)
 		}
+	case parser.ExprUnary:
+		// Every unary operator results in a boolean or a number, which are comparable
+		return c.checkExpressionValidUnary(e)
+	case parser.ExprBlock:
+		return c.checkExpressionValidForComparison(e.Value)
+	case parser.ExprCall:
+		if _, ok := c.functions[e.FunctionName]; !ok {
+			return nil
+		}
+		dataType, err := c.callDataType(e)
+		if err != nil {
+			return err
+		}
+		switch dataType.(type) {
+		case shared.Number, shared.String, shared.Boolean:
+			return nil
+		default:
+			return fmt.Errorf("call to %q returns %s which cannot be compared", e.FunctionName, dataType.String())
+		}
+	case parser.ExprMemberCall:
+		return c.checkExpressionValidMemberCall(e)
+	case parser.ExprConditional:
+		dataType, err := c.conditionalDataType(e)
+		if err != nil {
+			return err
+		}
+		switch dataType.(type) {
+		case shared.Number, shared.String, shared.Boolean:
+			return nil
+		default:
+			return fmt.Errorf("conditional expression of type %s cannot be compared", dataType.String())
+		}
 	default:
 		return fmt.Errorf("expression of type %T cannot be compared", e)
 	}
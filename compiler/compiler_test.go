@@ -2,9 +2,13 @@ package compiler
 
 import (
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"pixie/lexer"
+	"pixie/p8scii"
 	"pixie/parser"
+	"pixie/sourcemap"
+	"strings"
 	"testing"
 
 	"github.com/gkampitakis/go-snaps/snaps"
@@ -132,3 +136,1247 @@ func Test_MapVariable_InvalidTypeAssign(t *testing.T) {
 		require.ErrorIs(t, err, ErrInvalidTypeAssign)
 	})
 }
+
+func Test_MapKeys(t *testing.T) {
+	tests := map[string]struct {
+		pixie    string
+		expected string
+	}{
+		"negative_key": {"m map[num:num] = {-1: 3}", "m = {-1:3}"},
+		"min_key":      {"m map[num:num] = {-32768: 3}", "m = {-0x8000:3}"},
+		"mixed_keys":   {"m map[num:str] = {1: \"a\", -2: \"b\"}", "m = {1:\"a\",-2:\"b\"}"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(tt.pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			lua, err := Compile(node)
+			require.NoError(t, err, "failed to compile")
+			require.Equal(t, tt.expected+"\n", lua)
+		})
+	}
+
+	t.Run("negative_key_out_of_range", func(t *testing.T) {
+		node, err := parser.New(lexer.New("m map[num:num] = {-32769: 3}")).Parse()
+		require.NoError(t, err, "failed to parse")
+
+		_, err = Compile(node)
+		require.ErrorIs(t, err, ErrNumberRange)
+	})
+
+	t.Run("expression_key", func(t *testing.T) {
+		node, err := parser.New(lexer.New("m map[num:num] = {1 + 2: 3}")).Parse()
+		require.NoError(t, err, "failed to parse")
+
+		_, err = Compile(node)
+		require.ErrorIs(t, err, ErrInvalidOperand)
+	})
+}
+
+func Test_CountTokens(t *testing.T) {
+	tests := map[string]struct {
+		lua    string
+		tokens int
+	}{
+		"empty":              {lua: "", tokens: 0},
+		"call":               {lua: "print(\"hi\")", tokens: 3},
+		"assignment":         {lua: "x = 1", tokens: 3},
+		"free_tokens":        {lua: "local t = {1, 2}\nt.x = t[1]; end", tokens: 11},
+		"negative_literal":   {lua: "x = -1", tokens: 3},
+		"binary_minus":       {lua: "x = y - 1", tokens: 5},
+		"negative_variable":  {lua: "x = -y", tokens: 4},
+		"multi_char_ops":     {lua: "a ..= b >>> 2 ~= c", tokens: 7},
+		"comments":           {lua: "-- a comment\nx = 1 --[[ long\ncomment ]] y = 2", tokens: 6},
+		"strings_are_one":    {lua: "s = \"a \\\" b\" .. [[long\nstring]]", tokens: 5},
+		"glyph_identifiers":  {lua: "if btn(⬅️) then x -= 1 end", tokens: 8},
+		"goto_labels_free":   {lua: "goto continue_1\n::continue_1::", tokens: 3},
+		"number_formats":     {lua: "x = 0x1f.8 + 0b101 + .5", tokens: 7},
+		"unary_after_return": {lua: "return -1", tokens: 2},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, test.tokens, CountTokens(test.lua))
+		})
+	}
+}
+
+func Test_MeasureBudget(t *testing.T) {
+	pixie := `
+	point obj {
+		x num
+		y num
+	}
+	p point = {x: 1}
+	print(p.x)
+	`
+
+	l := lexer.New(pixie)
+	p := parser.New(l)
+	node, err := p.Parse()
+	require.NoError(t, err, "failed to parse")
+
+	program, err := CompileProgram(node)
+	require.NoError(t, err, "failed to compile")
+
+	budget, err := MeasureBudget(program)
+	require.NoError(t, err)
+	require.Equal(t, CountTokens(program.Lua), budget.Tokens)
+	require.Equal(t, len(program.Lua), budget.Chars)
+	require.Greater(t, budget.Compressed, 0)
+	require.Empty(t, budget.Exceeded())
+
+	require.Len(t, budget.Decls, 3)
+	require.Equal(t, "obj point", budget.Decls[0].Name)
+	require.Equal(t, "var p", budget.Decls[1].Name)
+	require.Equal(t, "call print", budget.Decls[2].Name)
+
+	tokens := 0
+	for _, decl := range budget.Decls {
+		tokens += decl.Tokens
+	}
+	require.Equal(t, budget.Tokens, tokens)
+}
+
+func Test_CompileProgram_Mappings(t *testing.T) {
+	pixie := "x num = 1\n\ny num = x + 2\nprint(y)"
+
+	l := lexer.NewFile("game.pixie", pixie)
+	p := parser.New(l)
+	node, err := p.Parse()
+	require.NoError(t, err, "failed to parse")
+
+	program, err := CompileProgram(node)
+	require.NoError(t, err, "failed to compile")
+	require.Equal(t, "x = 1\ny = x + 2\nprint(y)\n", program.Lua)
+
+	pos := func(line, column, offset int) lexer.Position {
+		return lexer.Position{File: "game.pixie", Line: line, Column: column, Offset: offset}
+	}
+	require.Equal(t, []sourcemap.Mapping{
+		{Line: 1, Column: 1, Source: pos(1, 1, 0)},
+		{Line: 1, Column: 5, Source: pos(1, 9, 8)},
+		{Line: 2, Column: 1, Source: pos(3, 1, 11)},
+		{Line: 2, Column: 5, Source: pos(3, 9, 19)},
+		{Line: 2, Column: 9, Source: pos(3, 13, 23)},
+		{Line: 3, Column: 1, Source: pos(4, 1, 25)},
+		{Line: 3, Column: 7, Source: pos(4, 7, 31)},
+	}, program.Mappings)
+}
+
+func Test_StringEscapes(t *testing.T) {
+	tests := map[string]struct {
+		pixie    string
+		expected string
+	}{
+		"plain":          {`print("hello")`, `print("hello")`},
+		"quote":          {`print("say \"hi\"")`, `print("say \"hi\"")`},
+		"backslash":      {`print("a\\b")`, `print("a\\b")`},
+		"newline_tab":    {`print("a\nb\tc")`, `print("a\nb\tc")`},
+		"raw_newline":    {"print(\"a\nb\")", `print("a\nb")`},
+		"control_codes":  {`print("\x00\x01\x02\x06\x07\x0c8\x0e\x0f")`, `print("\000\*\#\^\a\f8\014\015")`},
+		"glyphs":         {`print("\x87 \x83 \u{2605}")`, `print("♥ ⬇️ ★")`},
+		"glyph_as_is":    {`print("♥⬇")`, `print("♥⬇️")`},
+		"p8scii_symbols": {`print("\x10\x7f")`, `print("▮○")`},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(tt.pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			lua, err := Compile(node)
+			require.NoError(t, err, "failed to compile")
+			require.Equal(t, tt.expected+"\n", lua)
+		})
+	}
+
+	t.Run("not_p8scii", func(t *testing.T) {
+		node, err := parser.New(lexer.NewFile("game.pixie", `print("héllo")`)).Parse()
+		require.NoError(t, err, "failed to parse")
+
+		_, err = CompileProgram(node)
+		require.ErrorIs(t, err, p8scii.ErrNotP8SCII)
+		require.True(t, strings.HasPrefix(err.Error(), "game.pixie:1:7: "), err.Error())
+	})
+}
+
+func Test_NumberLiterals(t *testing.T) {
+	tests := map[string]struct {
+		pixie    string
+		expected string
+	}{
+		"integer":          {"42", "42"},
+		"decimal":          {"3.14", "3.14"},
+		"leading_zero":     {"0.5", ".5"},
+		"trailing_zeros":   {"2.500", "2.5"},
+		"trailing_point":   {"7.", "7"},
+		"separators":       {"10_000", "10000"},
+		"hex":              {"0x5f00", "24320"},
+		"hex_fraction":     {"0x0.8", ".5"},
+		"hex_shorter":      {"0x7f00.01", "0x7f00.01"},
+		"tiny_fraction":    {"0x0.0001", ".00002"},
+		"hex_sign_bit":     {"0xffff", "0xffff"},
+		"hex_negative_fr":  {"0x8000.8", "0x8000.8"},
+		"binary":           {"0b0101", "5"},
+		"binary_fraction":  {"0b0.01", ".25"},
+		"binary_mask":      {"0b1111_0000_0000_0000", "0xf000"},
+		"rounded":          {"0.1", ".1"},
+		"shortest_decimal": {"1.000015", "1.00002"},
+		"max":              {"32767.99998", "32767.99998"},
+		"max_hex":          {"0x7fff.ffff", "32767.99998"},
+		"negative":         {"-1.5", "-1.5"},
+		"min":              {"-32768", "-0x8000"},
+		"negative_hex":     {"-0xffff", "-0xffff"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New("print(" + tt.pixie + ")")).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			lua, err := Compile(node)
+			require.NoError(t, err, "failed to compile")
+			require.Equal(t, "print("+tt.expected+")\n", lua)
+		})
+	}
+
+	for _, literal := range []string{"32768", "40000.5", "-32769", "-32768.00001", "-0x10000", "0x10000", "0b1_0000_0000_0000_0000", "0x0.00001", "0b0.00000000000000001"} {
+		t.Run("out_of_range_"+literal, func(t *testing.T) {
+			node, err := parser.New(lexer.New("print(" + literal + ")")).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			_, err = Compile(node)
+			require.ErrorIs(t, err, ErrNumberRange)
+		})
+	}
+}
+
+func Test_UnaryOperators(t *testing.T) {
+	tests := map[string]struct {
+		pixie    string
+		expected string
+	}{
+		"negate":          {"x num = 1\nx = -x", "x = 1\nx = -x"},
+		"negate_literal":  {"print(-1)", "print(-1)"},
+		"not":             {"b bool = true\nb = !b", "b = true\nb = not b"},
+		"not_comparison":  {"x num = 1\nb bool = !(x > 2)", "x = 1\nb = not (x > 2)"},
+		"bitwise_not":     {"x num = 0x0f\nx = ~x", "x = 15\nx = ~x"},
+		"double_negate":   {"x num = 1\nx = --x", "x = 1\nx = - -x"},
+		"double_not":      {"b bool = true\nb = !!b", "b = true\nb = not not b"},
+		"binds_tighter":   {"x num = 1\nx = -x * 2", "x = 1\nx = -x * 2"},
+		"binds_looser":    {"l list[num]\nx num = 0\nx = -l[0]", "l = []\nx = 0\nx = -l[(0 + 1)]"},
+		"negate_group":    {"x num = 1\nx = -(x + 1)", "x = 1\nx = -(x + 1)"},
+		"binary_operand":  {"x num = 1\nx = 2 - -x", "x = 1\nx = 2 - -x"},
+		"mixed_operators": {"x num = 1\nx = -~x", "x = 1\nx = -~x"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(tt.pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			lua, err := Compile(node)
+			require.NoError(t, err, "failed to compile")
+			require.Equal(t, tt.expected+"\n", lua)
+		})
+	}
+
+	errorTests := map[string]string{
+		"not_number":    "x num = 1\nb bool = !x",
+		"not_string":    "print(!\"a\")",
+		"negate_bool":   "b bool = true\nx num = -b",
+		"negate_string": "print(-\"a\")",
+		"tilde_bool":    "print(~true)",
+	}
+
+	for name, pixie := range errorTests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			_, err = Compile(node)
+			require.ErrorIs(t, err, ErrInvalidOperand)
+		})
+	}
+
+	t.Run("assign_wrong_type", func(t *testing.T) {
+		node, err := parser.New(lexer.New("x num = 1\nb bool = true\nx = !b")).Parse()
+		require.NoError(t, err, "failed to parse")
+
+		_, err = Compile(node)
+		require.ErrorIs(t, err, ErrInvalidTypeAssign)
+	})
+}
+
+func Test_LogicalOperators(t *testing.T) {
+	tests := map[string]struct {
+		pixie    string
+		expected string
+	}{
+		"and":              {"a bool = true\nb bool = false\na = a && b", "a = true\nb = false\na = a and b"},
+		"or":               {"a bool = true\nb bool = false\na = a || b", "a = true\nb = false\na = a or b"},
+		"below_comparison": {"x num = 1\nb bool = true\nb = x > 0 && b", "x = 1\nb = true\nb = x > 0 and b"},
+		"and_before_or":    {"a bool = true\na = a || a && false", "a = true\na = a or a and false"},
+		"or_grouped":       {"a bool = true\na = (a || a) && false", "a = true\na = (a or a) and false"},
+		"with_not":         {"a bool = true\na = !a || a", "a = true\na = not a or a"},
+		"left_associative": {"a bool = true\na = a || a || a", "a = true\na = a or a or a"},
+		"in_comparison":    {"a bool = true\na = (a && a) == a", "a = true\na = (a and a) == a"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(tt.pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			lua, err := Compile(node)
+			require.NoError(t, err, "failed to compile")
+			require.Equal(t, tt.expected+"\n", lua)
+		})
+	}
+
+	errorTests := map[string]string{
+		"number_left":  "b bool = 1 && true",
+		"number_right": "x num = 1\nb bool = true || x",
+		"string":       "print(\"a\" || \"b\")",
+	}
+
+	for name, pixie := range errorTests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			_, err = Compile(node)
+			require.ErrorIs(t, err, ErrInvalidOperand)
+		})
+	}
+
+	t.Run("assign_to_number", func(t *testing.T) {
+		node, err := parser.New(lexer.New("x num = 1\nx = true && false")).Parse()
+		require.NoError(t, err, "failed to parse")
+
+		_, err = Compile(node)
+		require.ErrorIs(t, err, ErrInvalidTypeAssign)
+	})
+}
+
+func Test_ArithmeticAndBitwiseOperators(t *testing.T) {
+	tests := map[string]struct {
+		pixie    string
+		expected string
+	}{
+		"modulo":             {"x num = 7 % 3", "x = 7 % 3"},
+		"power":              {"x num = 2 ^ 3", "x = 2 ^ 3"},
+		"integer_division":   {`x num = 7 \ 2`, `x = 7 \ 2`},
+		"and":                {"x num = 0xf0 & 0x3c", "x = 240 & 60"},
+		"or":                 {"x num = 1 | 2", "x = 1 | 2"},
+		"xor":                {"x num = 1 ^^ 3", "x = 1 ^^ 3"},
+		"shifts":             {"x num = 1 << 4 >> 2 >>> 1", "x = 1 << 4 >> 2 >>> 1"},
+		"rotates":            {"x num = 1 <<> 4 >>< 2", "x = 1 <<> 4 >>< 2"},
+		"product_over_sum":   {"x num = 1 + 7 % 3 * 2", "x = 1 + 7 % 3 * 2"},
+		"shift_over_and":     {"x num = 1 & 1 << 2", "x = 1 & 1 << 2"},
+		"bitwise_below_sum":  {"x num = 1 | 2 + 3", "x = 1 | 2 + 3"},
+		"and_xor_or":         {"x num = 1 | 2 ^^ 3 & 4", "x = 1 | 2 ^^ 3 & 4"},
+		"power_right_assoc":  {"x num = 2 ^ 3 ^ 2", "x = 2 ^ (3 ^ 2)"},
+		"power_over_unary":   {"x num = 2\nx = -x ^ 2", "x = 2\nx = -x ^ 2"},
+		"negative_exponent":  {"x num = 2 ^ -1", "x = 2 ^ -1"},
+		"power_over_product": {"x num = 2 * 3 ^ 2", "x = 2 * 3 ^ 2"},
+		"comparison":         {"b bool = 5 % 2 == 1", "b = 5 % 2 == 1"},
+		"grouped_shift":      {"x num = (1 | 2) << 1", "x = (1 | 2) << 1"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(tt.pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			lua, err := Compile(node)
+			require.NoError(t, err, "failed to compile")
+			require.Equal(t, tt.expected+"\n", lua)
+		})
+	}
+
+	t.Run("property", func(t *testing.T) {
+		node, err := parser.New(lexer.New("p obj {\n    x num\n}\nv p\nx num = v.x & 3")).Parse()
+		require.NoError(t, err, "failed to parse")
+
+		lua, err := Compile(node)
+		require.NoError(t, err, "failed to compile")
+		require.True(t, strings.HasSuffix(lua, "\nx = v.x & 3\n"), lua)
+	})
+
+	errorTests := map[string]string{
+		"string_modulo":   `print("a" % 2)`,
+		"bool_and":        "b bool = true\nprint(b & 1)",
+		"string_shift":    `print(1 << "a")`,
+		"bool_power":      "print(2 ^ true)",
+		"string_subtract": `print("a" - "b")`,
+		"string_property": "p obj {\n    s str\n}\nv p\nprint(v.s | 1)",
+	}
+
+	for name, pixie := range errorTests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			_, err = Compile(node)
+			require.ErrorIs(t, err, ErrInvalidOperand)
+		})
+	}
+}
+
+func Test_CompoundAssignment(t *testing.T) {
+	tests := map[string]struct {
+		pixie    string
+		expected string
+	}{
+		"add":              {"x num = 1\nx += 2", "x = 1\nx += 2"},
+		"subtract_sum":     {"x num = 1\nx -= 2 + 3", "x = 1\nx -= 2 + 3"},
+		"multiply":         {"x num = 1\ny num = 2\nx *= y", "x = 1\ny = 2\nx *= y"},
+		"divide":           {"x num = 1\nx /= 2", "x = 1\nx /= 2"},
+		"modulo":           {"x num = 1\nx %= 2", "x = 1\nx %= 2"},
+		"power":            {"x num = 1\nx ^= 2", "x = 1\nx ^= 2"},
+		"integer_division": {"x num = 1\nx \\= 2", "x = 1\nx \\= 2"},
+		"bitwise":          {"x num = 1\nx &= 3\nx |= 4\nx ^^= 5", "x = 1\nx &= 3\nx |= 4\nx ^^= 5"},
+		"shifts":           {"x num = 1\nx <<= 1\nx >>= 1\nx >>>= 1\nx <<>= 1\nx >><= 1", "x = 1\nx <<= 1\nx >>= 1\nx >>>= 1\nx <<>= 1\nx >><= 1"},
+		"concatenate":      {"s str = \"a\"\ns += \"b\"", "s = \"a\"\ns ..= \"b\""},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(tt.pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			lua, err := Compile(node)
+			require.NoError(t, err, "failed to compile")
+			require.Equal(t, tt.expected+"\n", lua)
+		})
+	}
+
+	errorTests := map[string]string{
+		"number_plus_string": "x num = 1\nx += \"a\"",
+		"string_minus":       "s str = \"a\"\ns -= \"b\"",
+		"string_plus_number": "s str = \"a\"\ns += 1",
+		"bool_plus":          "b bool = true\nb += true",
+	}
+
+	for name, pixie := range errorTests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			_, err = Compile(node)
+			require.ErrorIs(t, err, ErrInvalidTypeAssign)
+		})
+	}
+
+	t.Run("unknown_variable", func(t *testing.T) {
+		node, err := parser.New(lexer.New("x += 1")).Parse()
+		require.NoError(t, err, "failed to parse")
+
+		_, err = Compile(node)
+		require.ErrorContains(t, err, `variable "x" does not exist`)
+	})
+}
+
+func Test_If(t *testing.T) {
+	tests := map[string]struct {
+		pixie    string
+		expected string
+	}{
+		"if":              {"x num = 1\nif x > 0 {\n    x = 0\n}", "x = 1\nif x > 0 then\nx = 0\nend"},
+		"else":            {"b bool\nif b {\n    print(1)\n} else {\n    print(2)\n}", "b = false\nif b then\nprint(1)\nelse\nprint(2)\nend"},
+		"else_if":         {"x num\nif x > 0 {\n    print(1)\n} else if x < 0 {\n    print(2)\n} else if x == 0 {\n    print(3)\n} else {\n    print(4)\n}", "x = 0\nif x > 0 then\nprint(1)\nelseif x < 0 then\nprint(2)\nelseif x == 0 then\nprint(3)\nelse\nprint(4)\nend"},
+		"empty":           {"if true {}", "if true then\nend"},
+		"local":           {"if true {\n    y num = 1\n    y += 1\n}", "if true then\nlocal y = 1\ny += 1\nend"},
+		"nested":          {"if true {\n    if false {\n        print(1)\n    }\n}", "if true then\nif false then\nprint(1)\nend\nend"},
+		"outer_variable":  {"x num\nif true {\n    x = 2\n}", "x = 0\nif true then\nx = 2\nend"},
+		"redeclare_after": {"if true {\n    y num = 1\n}\ny str = \"a\"", "if true then\nlocal y = 1\nend\ny = \"a\""},
+		"logical":         {"x num\nif x >= 0 && !(x > 9) {}", "x = 0\nif x >= 0 and not (x > 9) then\nend"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(tt.pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			lua, err := Compile(node)
+			require.NoError(t, err, "failed to compile")
+			require.Equal(t, tt.expected+"\n", lua)
+		})
+	}
+
+	conditionTests := map[string]string{
+		"number":         "if 1 {}",
+		"string":         "if \"a\" {}",
+		"number_var":     "x num\nif x {}",
+		"else_if_number": "if true {} else if 2 {}",
+	}
+
+	for name, pixie := range conditionTests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			_, err = Compile(node)
+			require.ErrorIs(t, err, ErrInvalidCondition)
+		})
+	}
+
+	t.Run("scope_ends_at_brace", func(t *testing.T) {
+		node, err := parser.New(lexer.NewFile("game.pixie", "if true {\n    y num = 1\n}\ny = 2")).Parse()
+		require.NoError(t, err, "failed to parse")
+
+		_, err = Compile(node)
+		require.ErrorContains(t, err, `variable "y" does not exist`)
+		require.True(t, strings.HasPrefix(err.Error(), "game.pixie:4:1: "), err.Error())
+	})
+
+	parseErrorTests := map[string]string{
+		"unclosed":     "if true {\n    print(1)",
+		"missing_cond": "if {}",
+		"missing_body": "if true print(1)",
+		"else_alone":   "else {}",
+	}
+
+	for name, pixie := range parseErrorTests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parser.New(lexer.New(pixie)).Parse()
+			require.Error(t, err)
+		})
+	}
+}
+
+func Test_Loops(t *testing.T) {
+	tests := map[string]struct {
+		pixie    string
+		expected string
+	}{
+		"while":           {"x num = 3\nwhile x > 0 {\n    x -= 1\n}", "x = 3\nwhile x > 0 do\nx -= 1\nend"},
+		"for":             {"for i in 0..9 {\n    print(i)\n}", "for i = 0, 9 do\nprint(i)\nend"},
+		"for_step":        {"n num = 8\nfor i in n..0 step -2 {}", "n = 8\nfor i = n, 0, -2 do\nend"},
+		"for_expressions": {"n num = 8\nfor i in n / 2..n - 1 {}", "n = 8\nfor i = n / 2, n - 1 do\nend"},
+		"break":           {"while true {\n    break\n}", "while true do\nbreak\nend"},
+		"continue":        {"for i in 1..5 {\n    if i == 3 {\n        continue\n    }\n    print(i)\n}", "for i = 1, 5 do\nif i == 3 then\ngoto continue1\nend\nprint(i)\n::continue1::\nend"},
+		"nested_continue": {"for i in 1..5 {\n    for j in 1..5 {\n        continue\n    }\n    continue\n}", "for i = 1, 5 do\nfor j = 1, 5 do\ngoto continue2\n::continue2::\nend\ngoto continue1\n::continue1::\nend"},
+		"sibling_loops":   {"for i in 1..2 {}\nfor i in 1..2 {\n    continue\n}", "for i = 1, 2 do\nend\nfor i = 1, 2 do\ngoto continue1\n::continue1::\nend"},
+		"locals":          {"while true {\n    y num = 1\n}\ny str", "while true do\nlocal y = 1\nend\ny = \"\""},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(tt.pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			lua, err := Compile(node)
+			require.NoError(t, err, "failed to compile")
+			require.Equal(t, tt.expected+"\n", lua)
+		})
+	}
+
+	errorTests := map[string]struct {
+		pixie string
+		err   error
+	}{
+		"while_number":     {"while 1 {}", ErrInvalidCondition},
+		"for_string_start": {"for i in \"a\"..2 {}", ErrInvalidRange},
+		"for_bool_end":     {"for i in 0..true {}", ErrInvalidRange},
+		"for_string_step":  {"for i in 0..1 step \"a\" {}", ErrInvalidRange},
+		"break_outside":    {"break", ErrNotInLoop},
+		"continue_outside": {"continue", ErrNotInLoop},
+		"continue_in_if":   {"if true {\n    continue\n}", ErrNotInLoop},
+		"assign_loop_var":  {"for i in 0..1 {\n    i = \"a\"\n}", ErrInvalidTypeAssign},
+		"break_after_loop": {"while true {}\nbreak", ErrNotInLoop},
+	}
+
+	for name, tt := range errorTests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(tt.pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			_, err = Compile(node)
+			require.ErrorIs(t, err, tt.err)
+		})
+	}
+
+	scopeTests := map[string]string{
+		"loop_var_after_loop": "for i in 0..1 {}\ni = 2",
+		"loop_var_shadows":    "i num\nfor i in 0..1 {}",
+	}
+
+	for name, pixie := range scopeTests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			_, err = Compile(node)
+			require.Error(t, err)
+		})
+	}
+}
+
+func Test_ForEach(t *testing.T) {
+	tests := map[string]struct {
+		pixie    string
+		expected string
+	}{
+		"list_values":   {"l list[num] = [1]\nfor v in l {\n    print(v)\n}", "l = [1]\nfor v in all(l) do\nprint(v)\nend"},
+		"list_indices":  {"l list[str] = [\"a\"]\nfor i, v in l {\n    print(i)\n}", "l = [\"a\"]\nfor i, v in ipairs(l) do\nlocal i = i - 1\nprint(i)\nend"},
+		"map_keys":      {"m map[str:num] = {\"a\": 1}\nfor k in m {\n    print(k)\n}", "m = {\"a\":1}\nfor k in pairs(m) do\nprint(k)\nend"},
+		"map_pairs":     {"m map[str:num] = {\"a\": 1}\nfor k, v in m {\n    print(k)\n}", "m = {\"a\":1}\nfor k, v in pairs(m) do\nprint(k)\nend"},
+		"discard_index": {"l list[num] = [1]\nfor _, v in l {}", "l = [1]\nfor _, v in ipairs(l) do\nend"},
+		"typed_value":   {"l list[num] = [1]\nfor v in l {\n    v += 1\n}", "l = [1]\nfor v in all(l) do\nv += 1\nend"},
+		"continue":      {"l list[num] = [1]\nfor v in l {\n    continue\n}", "l = [1]\nfor v in all(l) do\ngoto continue1\n::continue1::\nend"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(tt.pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			lua, err := Compile(node)
+			require.NoError(t, err, "failed to compile")
+			require.Equal(t, tt.expected+"\n", lua)
+		})
+	}
+
+	errorTests := map[string]struct {
+		pixie string
+		err   error
+	}{
+		"string":          {"s str\nfor c in s {}", ErrNotIterable},
+		"number_literal":  {"for v in 3 {}", ErrNotIterable},
+		"list_value_type": {"l list[num] = [1]\nfor v in l {\n    v = \"a\"\n}", ErrInvalidTypeAssign},
+		"list_index_type": {"l list[str] = [\"a\"]\nfor i, v in l {\n    i = v\n}", ErrInvalidTypeAssign},
+		"map_key_type":    {"m map[str:num] = {\"a\": 1}\nfor k in m {\n    k = 1\n}", ErrInvalidTypeAssign},
+	}
+
+	for name, tt := range errorTests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(tt.pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			_, err = Compile(node)
+			require.ErrorIs(t, err, tt.err)
+		})
+	}
+
+	scopeTests := map[string]string{
+		"var_after_loop": "l list[num] = [1]\nfor v in l {}\nv = 2",
+		"var_shadows":    "v num\nl list[num] = [1]\nfor v in l {}",
+		"same_names":     "l list[num] = [1]\nfor v, v in l {}",
+		"range_pair":     "for i, v in 0..1 {}",
+	}
+
+	for name, pixie := range scopeTests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(pixie)).Parse()
+			if err == nil {
+				_, err = Compile(node)
+			}
+			require.Error(t, err)
+		})
+	}
+}
+
+func Test_Functions(t *testing.T) {
+	tests := map[string]struct {
+		pixie    string
+		expected string
+	}{
+		"no_params":       {"fn hello() {\n    print(\"hello\")\n}", "function hello()\nprint(\"hello\")\nend"},
+		"params":          {"fn show(a num, b str) {\n    print(b)\n}\nshow(1, \"a\")", "function show(a,b)\nprint(b)\nend\nshow(1,\"a\")"},
+		"return_value":    {"fn double(n num) num {\n    return n * 2\n}", "function double(n)\nreturn n * 2\nend"},
+		"return_variable": {"fn same(s str) str {\n    return s\n}", "function same(s)\nreturn s\nend"},
+		"early_return":    {"fn check(n num) {\n    if n < 0 {\n        return\n    }\n    print(n)\n}", "function check(n)\nif n < 0 then\nreturn\nend\nprint(n)\nend"},
+		"if_else_returns": {"fn sign(n num) num {\n    if n < 0 {\n        return -1\n    } else {\n        return 1\n    }\n}", "function sign(n)\nif n < 0 then\nreturn -1\nelse\nreturn 1\nend\nend"},
+		"recursion":       {"fn count(n num) {\n    if n > 0 {\n        count(n - 1)\n    }\n}", "function count(n)\nif n > 0 then\ncount(n - 1)\nend\nend"},
+		"return_in_loop":  {"fn first(l list[num]) num {\n    for v in l {\n        return v\n    }\n    return 0\n}", "function first(l)\nfor v in all(l) do\ndo return v end\nend\nreturn 0\nend"},
+		"locals":          {"fn f() {\n    x num = 1\n}\nx str", "function f()\nlocal x = 1\nend\nx = \"\""},
+		"indirect_after":  {"fn f() {\n    g()\n}\nfn g() {\n    f()\n}\nf()", "function f()\ng()\nend\nfunction g()\nf()\nend\nf()"},
+		"call_later":      {"fn f() num {\n    return g()\n}\nfn g() num {\n    return 1\n}\nprint(f())", "function f()\nreturn g()\nend\nfunction g()\nreturn 1\nend\nprint(f())"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(tt.pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			lua, err := Compile(node)
+			require.NoError(t, err, "failed to compile")
+			require.Equal(t, tt.expected+"\n", lua)
+		})
+	}
+
+	errorTests := map[string]struct {
+		pixie string
+		err   error
+	}{
+		"return_outside":    {"return", ErrInvalidReturn},
+		"return_wrong_type": {"fn f() num {\n    return \"a\"\n}", ErrInvalidReturn},
+		"return_wrong_var":  {"fn f(s str) num {\n    return s\n}", ErrInvalidReturn},
+		"return_value_void": {"fn f() {\n    return 1\n}", ErrInvalidReturn},
+		"return_missing":    {"fn f() num {\n    return\n}", ErrInvalidReturn},
+		"no_final_return":   {"fn f(n num) num {\n    if n > 0 {\n        return 1\n    }\n}", ErrInvalidReturn},
+		"argument_count":    {"fn f(n num) {}\nf(1, 2)", ErrInvalidArgument},
+		"argument_type":     {"fn f(n num) {}\nf(\"a\")", ErrInvalidArgument},
+		"argument_var_type": {"fn f(n num) {}\ns str\nf(s)", ErrInvalidArgument},
+		"assign_param":      {"fn f(n num) {\n    n = \"a\"\n}", ErrInvalidTypeAssign},
+		"break_in_function": {"fn f() {\n    break\n}", ErrNotInLoop},
+		"later_return_type": {"fn g() {\n    x num = 0\n    x = f()\n}\nfn f() str {\n    return \"a\"\n}", ErrInvalidTypeAssign},
+		"later_arguments":   {"fn g() {\n    f(1)\n}\nfn f(s str) {}", ErrInvalidArgument},
+	}
+
+	for name, tt := range errorTests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(tt.pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			_, err = Compile(node)
+			require.ErrorIs(t, err, tt.err)
+		})
+	}
+
+	scopeTests := map[string]string{
+		"param_after_function": "fn f(n num) {}\nn = 1",
+		"param_shadows":        "n num\nfn f(n num) {}",
+		"duplicate_params":     "fn f(n num, n str) {}",
+		"duplicate_function":   "fn f() {}\nfn f() {}",
+		"variable_named_fn":    "fn f() {}\nf num",
+		"nested_function":      "fn f() {\n    fn g() {}\n}",
+		"after_return":         "fn f() num {\n    return 1\n    print(1)\n}",
+		"later_duplicate":      "f()\nfn f() {}\nfn f() {}",
+		"call_before_define":   "f()\nfn f() {}",
+		"indirect_before":      "fn f() {\n    g()\n}\nf()\nfn g() {}",
+		"deep_before":          "fn f() {\n    g()\n}\nfn g() {\n    h()\n}\nf()\nfn h() {}",
+		"value_before_define":  "x num = f()\nfn f() num {\n    return 1\n}",
+	}
+
+	for name, pixie := range scopeTests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			_, err = Compile(node)
+			require.Error(t, err)
+		})
+	}
+
+	t.Run("indirect_message", func(t *testing.T) {
+		node, err := parser.New(lexer.New("fn f() {\n    g()\n}\nf()\nfn g() {}")).Parse()
+		require.NoError(t, err, "failed to parse")
+
+		_, err = Compile(node)
+		require.ErrorContains(t, err, `function "f" is called before "g", which it calls, is defined`)
+	})
+}
+
+func Test_CallExpressions(t *testing.T) {
+	double := "fn double(n num) num {\n    return n * 2\n}\n"
+	doubleLua := "function double(n)\nreturn n * 2\nend\n"
+
+	tests := map[string]struct {
+		pixie    string
+		expected string
+	}{
+		"assign":      {double + "x num\nx = double(3)", doubleLua + "x = 0\nx = double(3)"},
+		"builtin":     {"hp num = 3\nhp = max(hp - 1, 0)", "hp = 3\nhp = max(hp - 1,0)"},
+		"argument":    {double + "print(double(double(1)))", doubleLua + "print(double(double(1)))"},
+		"binary":      {double + "x num\nx = double(2) * 3 + 1", doubleLua + "x = 0\nx = double(2) * 3 + 1"},
+		"comparison":  {double + "b bool\nb = double(2) > 3", doubleLua + "b = false\nb = double(2) > 3"},
+		"concat":      {"fn name(n num) str {\n    return \"a\"\n}\nprint(\"hi \" + name(1))", "function name(n)\nreturn \"a\"\nend\nprint(\"hi \" .. name(1))"},
+		"condition":   {"fn ok(n num) bool {\n    return n > 0\n}\nif ok(1) {}", "function ok(n)\nreturn n > 0\nend\nif ok(1) then\nend"},
+		"recursion":   {"fn fact(n num) num {\n    if n <= 1 {\n        return 1\n    }\n    return n * fact(n - 1)\n}", "function fact(n)\nif n <= 1 then\nreturn 1\nend\nreturn n * fact(n - 1)\nend"},
+		"index":       {"fn nums(n num) list[num] {\n    return [1, 2]\n}\nx num\nx = nums(1)[0]", "function nums(n)\nreturn [1,2]\nend\nx = 0\nx = nums(1)[(0 + 1)]"},
+		"property":    {"point obj {\n    x num\n}\nfn origin(n num) point {\n    return {x: 0}\n}\nx num\nx = origin(1).x + 1", "x = 0\nx = origin(1).x + 1"},
+		"return_call": {double + "fn quad(n num) num {\n    return double(double(n))\n}", doubleLua + "function quad(n)\nreturn double(double(n))\nend"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(tt.pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			lua, err := Compile(node)
+			require.NoError(t, err, "failed to compile")
+			require.True(t, strings.HasSuffix(lua, tt.expected+"\n"), "got:\n%s", lua)
+		})
+	}
+
+	errorTests := map[string]struct {
+		pixie string
+		err   error
+	}{
+		"assign_wrong_type": {double + "s str\ns = double(1)", ErrInvalidTypeAssign},
+		"operand_type":      {"fn name(n num) str {\n    return \"a\"\n}\nprint(name(1) * 2)", ErrInvalidOperand},
+		"condition_type":    {double + "if double(1) {}", ErrInvalidCondition},
+		"no_value_assign":   {"fn f(n num) {}\nx num\nx = f(1)", ErrInvalidTypeAssign},
+		"no_value_argument": {"fn f(n num) {}\nprint(f(1))", ErrNoValue},
+		"argument_type":     {double + "print(double(\"a\"))", ErrInvalidArgument},
+		"return_type":       {double + "fn f(n num) str {\n    return double(n)\n}", ErrInvalidReturn},
+	}
+
+	for name, tt := range errorTests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(tt.pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			_, err = Compile(node)
+			require.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func Test_TargetAssignment(t *testing.T) {
+	point := "point obj {\n    x num\n    name str\n}\n"
+
+	tests := map[string]struct {
+		pixie    string
+		expected string
+	}{
+		"list_element":  {"l list[num] = [1]\nl[0] = 2", "l[(0 + 1)] = 2"},
+		"nested_lists":  {"grid list[list[num]] = [[1]]\ny num\ngrid[y][0] = 5", "grid[(y + 1)][(0 + 1)] = 5"},
+		"map_value":     {"m map[str:num] = {\"bob\": 1}\nm[\"bob\"] = 3", "m[\"bob\"] = 3"},
+		"property":      {point + "p point\np.name = \"x\"", "p.name = \"x\""},
+		"nested":        {point + "ps list[point] = [{x: 1}]\nps[0].x = 4", "ps[(0 + 1)].x = 4"},
+		"object_fill":   {point + "ps list[point] = [{x: 1}]\nps[0] = {name: \"a\"}", "ps[(0 + 1)] = {\"x\":0,\"name\":\"a\"}"},
+		"compound":      {"m map[str:num] = {\"bob\": 1}\nm[\"bob\"] += 1", "m[\"bob\"] += 1"},
+		"compound_str":  {"l list[str] = [\"a\"]\nl[0] += \"b\"", "l[(0 + 1)] ..= \"b\""},
+		"from_property": {point + "p point\nq point\np.x = q.x", "p.x = q.x"},
+		"read_nested":   {"grid list[list[num]] = [[1]]\nprint(grid[0][0])", "print(grid[(0 + 1)][(0 + 1)])"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(tt.pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			lua, err := Compile(node)
+			require.NoError(t, err, "failed to compile")
+			require.True(t, strings.HasSuffix(lua, tt.expected+"\n"), "got:\n%s", lua)
+		})
+	}
+
+	errorTests := map[string]struct {
+		pixie string
+		err   error
+	}{
+		"element_type":   {"l list[num] = [1]\nl[0] = \"a\"", ErrInvalidTypeAssign},
+		"nested_type":    {"grid list[list[num]] = [[1]]\ngrid[0][0] = true", ErrInvalidTypeAssign},
+		"map_value_type": {"m map[str:num] = {\"bob\": 1}\nm[\"bob\"] = \"a\"", ErrInvalidTypeAssign},
+		"property_type":  {point + "p point\np.x = \"a\"", ErrInvalidTypeAssign},
+		"compound_type":  {point + "p point\np.name -= 1", ErrInvalidTypeAssign},
+		"string_char":    {"s str = \"ab\"\ns[0] = \"c\"", ErrInvalidTypeAssign},
+	}
+
+	for name, tt := range errorTests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(tt.pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			_, err = Compile(node)
+			require.ErrorIs(t, err, tt.err)
+		})
+	}
+
+	invalidTests := map[string]string{
+		"unknown_variable": "l[0] = 1",
+		"unknown_field":    point + "p point\np.y = 1",
+		"map_key_type":     "m map[str:num] = {\"bob\": 1}\nm[1] = 3",
+		"list_index_type":  "l list[num] = [1]\nl[\"a\"] = 3",
+		"index_number":     "x num\nx[0] = 1",
+		"property_number":  "x num\nx.y = 1",
+	}
+
+	for name, pixie := range invalidTests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			_, err = Compile(node)
+			require.Error(t, err)
+		})
+	}
+}
+
+func Test_MemberCalls(t *testing.T) {
+	enemy := "enemy obj {\n    x num\n}\n"
+
+	tests := map[string]struct {
+		pixie    string
+		expected string
+	}{
+		"statement":   {enemy + "e enemy\ne.update(1)", "e.update(1)"},
+		"element":     {enemy + "es list[enemy] = [{x: 1}]\nes[0].update(1, 2)", "es[(0 + 1)].update(1,2)"},
+		"field_args":  {enemy + "e enemy\ne.move(e.x, 2)", "e.move(e.x,2)"},
+		"as_value":    {enemy + "e enemy\nprint(e.speed(1))", "print(e.speed(1))"},
+		"in_binary":   {enemy + "e enemy\nprint(e.speed(1) * 2)", "print(e.speed(1) * 2)"},
+		"in_function": {enemy + "fn tick(e enemy) {\n    e.update(1)\n}", "function tick(e)\ne.update(1)\nend"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(tt.pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			lua, err := Compile(node)
+			require.NoError(t, err, "failed to compile")
+			require.True(t, strings.HasSuffix(lua, tt.expected+"\n"), "got:\n%s", lua)
+		})
+	}
+
+	errorTests := map[string]string{
+		"field":    enemy + "e enemy\ne.x(1)",
+		"element":  "l list[num] = [1]\nl[0](1)",
+		"number":   "n num = 1\nn.f(1)",
+		"map":      "m map[str:num] = {\"a\": 1}\nm.f(1)",
+		"in_value": enemy + "e enemy\nprint(e.x(1) + 1)",
+	}
+
+	for name, pixie := range errorTests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			_, err = Compile(node)
+			require.ErrorIs(t, err, ErrInvalidOperand)
+		})
+	}
+
+	t.Run("unknown_variable", func(t *testing.T) {
+		node, err := parser.New(lexer.New("e.update(1)")).Parse()
+		require.NoError(t, err, "failed to parse")
+
+		_, err = Compile(node)
+		require.ErrorContains(t, err, `variable "e" does not exist`)
+	})
+}
+
+func Test_InvalidStatements(t *testing.T) {
+	tests := map[string]struct {
+		pixie    string
+		expected string
+	}{
+		"open_paran":     {"(1)", `1:1: expected statement, got "OpenParan"`},
+		"open_bracket":   {"x num\n[1]", `2:1: expected statement, got "OpenBracket"`},
+		"close_brace":    {"}", `1:1: expected statement, got "CloseBrace"`},
+		"in_block":       {"if true {\n    (1)\n}", `2:5: expected statement, got "OpenParan"`},
+		"assign_to_call": {"e.f(1) = 2", "1:8: cannot assign to the result of a call"},
+		"number":         {"1 + 2", `1:1: expected statement, got "NumberLiteral"`},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parser.New(lexer.New(tt.pixie)).Parse()
+			require.EqualError(t, err, tt.expected)
+		})
+	}
+}
+
+func Test_EmptyLiterals(t *testing.T) {
+	point := "point obj {\n    x num\n}\n"
+
+	tests := map[string]struct {
+		pixie    string
+		expected string
+	}{
+		"list":            {"l list[num] = []", "l = []"},
+		"map":             {"m map[str:num] = {}", "m = {}"},
+		"object":          {point + "p point = {}", "p = {\"x\":0}"},
+		"assign":          {"l list[num] = [1]\nl = []", "l = []"},
+		"nested":          {"l list[list[num]] = [[]]", "l = [[]]"},
+		"call":            {"cls()", "cls()"},
+		"call_value":      {"x num = flr(rnd())", "x = flr(rnd())"},
+		"argument":        {"fn f(l list[num]) {\n    print(1)\n}\nf([])", "f([])"},
+		"trailing_commas": {"fn f(a num, b num,) {\n    print(a, b,)\n}\nf(1, 2,)\nl list[num] = [\n    1,\n    2,\n]", "f(1,2)\nl = [1,2]"},
+		"map_trailing":    {"m map[str:num] = {\"a\": 1,}", "m = {\"a\":1}"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(tt.pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			lua, err := Compile(node)
+			require.NoError(t, err, "failed to compile")
+			require.True(t, strings.HasSuffix(lua, tt.expected+"\n"), "got:\n%s", lua)
+		})
+	}
+
+	errorTests := map[string]struct {
+		pixie string
+		err   error
+	}{
+		"list_as_map":    {"m map[str:num] = []", ErrInvalidTypeAssign},
+		"map_as_list":    {"l list[num] = {}", ErrInvalidTypeAssign},
+		"list_as_object": {point + "p point = []", ErrInvalidTypeAssign},
+		"list_as_number": {"x num = []", ErrInvalidTypeAssign},
+		"map_as_string":  {"s str = {}", ErrInvalidTypeAssign},
+		"assign":         {"l list[num] = [1]\nl = {}", ErrInvalidTypeAssign},
+		"argument":       {"fn f(l list[num]) {\n    print(1)\n}\nf({})", ErrInvalidArgument},
+		"return":         {"fn f() map[str:num] {\n    return []\n}", ErrInvalidReturn},
+	}
+
+	for name, tt := range errorTests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(tt.pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			_, err = Compile(node)
+			require.ErrorIs(t, err, tt.err)
+		})
+	}
+
+	parseErrorTests := map[string]string{
+		"only_comma":   "l list[num] = [,]",
+		"double_comma": "print(1,,)",
+		"map_comma":    "m map[str:num] = {,}",
+		"param_comma":  "fn f(,) {\n    print(1)\n}",
+	}
+
+	for name, pixie := range parseErrorTests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parser.New(lexer.New(pixie)).Parse()
+			require.Error(t, err)
+		})
+	}
+}
+
+func Test_Conditional(t *testing.T) {
+	point := "point obj {\n    x num\n}\n"
+
+	tests := map[string]struct {
+		pixie    string
+		expected string
+	}{
+		"numbers":       {"alive bool\ncol num\ncol = alive ? 7 : 5", "col = alive and 7 or 5"},
+		"declaration":   {"alive bool\ncol num = alive ? 7 : 5", "col = alive and 7 or 5"},
+		"bool_branch":   {"a bool\nb bool\nb = a ? b : true", "b = (function() if a then return b end return true end)()"},
+		"variable":      {"a bool\nn num\nn = a ? n : 1", "n = (function() if a then return n end return 1 end)()"},
+		"concat":        {"a bool\nprint(\"hp: \" + (a ? \"full\" : \"low\"))", "print(\"hp: \" .. (a and \"full\" or \"low\"))"},
+		"operand":       {"a bool\nn num\nn = 1 + (a ? 2 : 3)", "n = 1 + (a and 2 or 3)"},
+		"or_condition":  {"a bool\nb bool\nprint(a || b ? 1 : 2)", "print((a or b) and 1 or 2)"},
+		"chained":       {"a bool\nb bool\nprint(a ? 1 : b ? 2 : 3)", "print(a and 1 or b and 2 or 3)"},
+		"bare_then":     {"a bool\nb bool\nx num = a ? b ? 1 : 2 : 3", "x = a and (b and 1 or 2) or 3"},
+		"bare_then_fn":  {"a bool\nb bool\nn num\nx num = a ? b ? n : 2 : 3", "x = (function() if a then return (function() if b then return n end return 2 end)() end return 3 end)()"},
+		"nested_type":   {"a bool\nb bool\nl list[num] = a ? b ? [] : [] : [1]", "l = a and (b and [] or []) or [1]"},
+		"nested_then":   {"a bool\nb bool\nprint(a ? (b ? 1 : 2) : 3)", "print(a and (b and 1 or 2) or 3)"},
+		"empty_list":    {"a bool\nl list[num]\nl = a ? [] : [1]", "l = a and [] or [1]"},
+		"object_fill":   {point + "a bool\np point\np = a ? p : {}", "p = (function() if a then return p end return {\"x\":0} end)()"},
+		"condition":     {"a bool\nif a ? true : false {\n    print(1)\n}", "if a and true or false then\nprint(1)\nend"},
+		"return":        {"fn sign(n num) num {\n    return n < 0 ? -1 : 1\n}", "function sign(n)\nreturn n < 0 and -1 or 1\nend"},
+		"map_value":     {"m map[str:num] = {\"a\": 1}\na bool\nn num\nn = a ? m[\"a\"] : 0", "n = (function() if a then return m[\"a\"] end return 0 end)()"},
+		"declared_type": {"a bool\nl list[num] = a ? [] : []", "l = a and [] or []"},
+		"assigned_type": {"a bool\nm map[str:num]\nm = a ? {} : {}", "m = a and {} or {}"},
+		"object_type":   {point + "a bool\np point = a ? {x: 1} : {}", "p = a and {\"x\":1} or {\"x\":0}"},
+		"argument_type": {"fn f(l list[num]) {\n    print(l)\n}\na bool\nf(a ? [] : [])", "f(a and [] or [])"},
+		"return_type":   {"fn f(a bool) list[str] {\n    return a ? [] : []\n}", "function f(a)\nreturn a and [] or []\nend"},
+		"false_branch":  {"a bool\nb bool\nb = a ? false : true", "b = (function() if a then return false end return true end)()"},
+		"property_read": {point + "a bool\np point\nq point\nprint((a ? p : q).x)", "print(((function() if a then return p end return q end)()).x)"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(tt.pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			lua, err := Compile(node)
+			require.NoError(t, err, "failed to compile")
+			require.True(t, strings.HasSuffix(lua, tt.expected+"\n"), "got:\n%s", lua)
+		})
+	}
+
+	errorTests := map[string]struct {
+		pixie string
+		err   error
+	}{
+		"number_condition": {"a num\nprint(a ? 1 : 2)", ErrInvalidCondition},
+		"branch_types":     {"a bool\nprint(a ? 1 : \"x\")", ErrInvalidOperand},
+		"comparison_else":  {"a bool\nprint(a ? 1 : 2 == 1)", ErrInvalidOperand},
+		"assign_type":      {"a bool\ns str\ns = a ? 1 : 2", ErrInvalidTypeAssign},
+		"declare_type":     {"a bool\nl list[str] = a ? [] : [1]", ErrInvalidTypeAssign},
+		"operand_type":     {"a bool\nprint(1 + (a ? \"x\" : \"y\") * 2)", ErrInvalidOperand},
+		"argument_type":    {"fn f(n num) {\n    print(n)\n}\na bool\nf(a ? \"x\" : \"y\")", ErrInvalidArgument},
+	}
+
+	for name, tt := range errorTests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(tt.pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			_, err = Compile(node)
+			require.ErrorIs(t, err, tt.err)
+		})
+	}
+
+	t.Run("unknown_type", func(t *testing.T) {
+		node, err := parser.New(lexer.New("a bool\nprint(a ? [] : [])")).Parse()
+		require.NoError(t, err, "failed to parse")
+
+		_, err = Compile(node)
+		require.ErrorContains(t, err, "type of conditional expression cannot be worked out")
+	})
+
+	t.Run("target_type", func(t *testing.T) {
+		node, err := parser.New(lexer.New("c bool\nx num = 0\nx = c ? \"b\" : \"a\"")).Parse()
+		require.NoError(t, err, "failed to parse")
+
+		_, err = Compile(node)
+		require.ErrorIs(t, err, ErrInvalidTypeAssign)
+		require.ErrorContains(t, err, "conditional expression must be num")
+		require.NotContains(t, err.Error(), "branches")
+	})
+}
+
+func Test_OperandParentheses(t *testing.T) {
+	tests := map[string]struct {
+		pixie    string
+		expected string
+	}{
+		"add_then_concat":   {`print(1 + 2 + "a")`, `print(1 + 2 .. "a")`},
+		"right_subtraction": {"x num = 1\nx = 1 - (2 - x)", "x = 1\nx = 1 - (2 - x)"},
+		"no_extra_parens":   {"x num = 1\nx = x * 2 + 1", "x = 1\nx = x * 2 + 1"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.New(lexer.New(tt.pixie)).Parse()
+			require.NoError(t, err, "failed to parse")
+
+			lua, err := Compile(node)
+			require.NoError(t, err, "failed to compile")
+			require.Equal(t, tt.expected+"\n", lua)
+		})
+	}
+}
+
+func Test_ErrorPositions(t *testing.T) {
+	tests := map[string]struct {
+		pixie    string
+		expected string
+	}{
+		"parse_error":          {"x num = 1\nprint(x,, 2)", `game.pixie:2:9: `},
+		"invalid_rune":         {"x num = 1\ny num = x $ 2", `game.pixie:2:11: `},
+		"unknown_variable":     {"x num = 1\n\n  y = 2", `game.pixie:3:3: `},
+		"unknown_variable_rhs": {"x num = 1\nx = y", `game.pixie:2:5: `},
+		"invalid_type_assign":  {"s str = \"a\"\ns = 1", `game.pixie:2:1: invalid type assign: `},
+		"conditional_else":     {"a bool\ny num = a ? 1 : \"s\"", `game.pixie:2:17: invalid type assign: conditional expression must be num: got str`},
+		"conditional_return":   {"fn f(a bool) num {\n    return a ? 1 : \"s\"\n}", `game.pixie:2:20: invalid return: conditional expression must be num: got str`},
+		"target_assign_rhs":    {"l list[num]\nl[0] = y", `game.pixie:2:8: invalid type assign: variable "y" does not exist`},
+		"unreachable":          {"fn f() num {\n    return 1\n    print(2)\n}", `game.pixie:3:5: `},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := func() (program Program, err error) {
+				node, err := parser.New(lexer.NewFile("game.pixie", tt.pixie)).Parse()
+				if err != nil {
+					return
+				}
+				return CompileProgram(node)
+			}()
+
+			var posErr *lexer.Error
+			require.ErrorAs(t, err, &posErr)
+			require.Equal(t, "game.pixie", posErr.Pos.File)
+			require.True(t, strings.HasPrefix(err.Error(), tt.expected), err.Error())
+		})
+	}
+
+	t.Run("keeps_sentinel_errors", func(t *testing.T) {
+		node, err := parser.New(lexer.NewFile("game.pixie", "s str = \"a\"\ns = 1")).Parse()
+		require.NoError(t, err)
+		_, err = CompileProgram(node)
+		require.ErrorIs(t, err, ErrInvalidTypeAssign)
+	})
+}
+
+func compileFile(t *testing.T, path string) (Program, error) {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	require.NoError(t, err, "failed to read file")
+
+	l := lexer.NewFile(path, string(content))
+	p := parser.New(l)
+	node, err := p.Parse()
+	require.NoError(t, err, "failed to parse")
+
+	return CompileProgram(node)
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+}
+
+func Test_Import(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.pixie": `
+		import "lib/enemies.pixie"
+		import "lib/shared.pixie"
+		e enemy = {hp: 3}
+		lives = 2
+		`,
+		"lib/enemies.pixie": `
+		import "shared.pixie"
+		enemy obj {
+			hp num
+		}
+		`,
+		"lib/shared.pixie": `
+		lives num = 3
+		`,
+	})
+
+	program, err := compileFile(t, filepath.Join(dir, "main.pixie"))
+	require.NoError(t, err)
+	require.Equal(t, "lives = 3\n\ne = {\"hp\":3}\nlives = 2\n", program.Lua)
+	require.Equal(t, []string{
+		filepath.Join(dir, "lib", "enemies.pixie"),
+		filepath.Join(dir, "lib", "shared.pixie"),
+	}, program.Files)
+
+	require.Len(t, program.Decls, 4)
+	require.Equal(t, "var lives", program.Decls[0].Name)
+	require.Equal(t, filepath.Join(dir, "lib", "shared.pixie"), program.Decls[0].Pos.File)
+	require.Equal(t, "obj enemy", program.Decls[1].Name)
+	require.Equal(t, filepath.Join(dir, "main.pixie"), program.Decls[2].Pos.File)
+}
+
+func Test_Import_Cycle(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.pixie": `import "b.pixie"`,
+		"b.pixie": `import "c.pixie"`,
+		"c.pixie": `import "a.pixie"`,
+	})
+
+	program, err := compileFile(t, filepath.Join(dir, "a.pixie"))
+	require.ErrorIs(t, err, ErrImportCycle)
+	require.Contains(t, err.Error(), "a.pixie -> "+filepath.Join(dir, "b.pixie")+" -> "+filepath.Join(dir, "c.pixie")+" -> "+filepath.Join(dir, "a.pixie"))
+	require.Len(t, program.Files, 2)
+}
+
+func Test_Import_Errors(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"missing.pixie":   `import "nowhere.pixie"`,
+		"redeclare.pixie": "import \"x.pixie\"\nx num = 2",
+		"x.pixie":         `x num = 1`,
+	})
+
+	program, err := compileFile(t, filepath.Join(dir, "missing.pixie"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.Equal(t, []string{filepath.Join(dir, "nowhere.pixie")}, program.Files)
+
+	_, err = compileFile(t, filepath.Join(dir, "redeclare.pixie"))
+	require.ErrorContains(t, err, `variable "x" already exists`)
+	require.True(t, strings.HasPrefix(err.Error(), filepath.Join(dir, "redeclare.pixie")+":2:1: "), err.Error())
+}
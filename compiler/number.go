@@ -0,0 +1,147 @@
+package compiler
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNumberRange = errors.New("number does not fit PICO-8's 16.16 fixed point") // ErrNumberRange is returned for number literals PICO-8 cannot represent
+)
+
+const (
+	fixedOne     = 1 << 16   // fixedOne is 1 in 16.16 fixed point
+	fixedMaxUint = 0xffff    // fixedMaxUint is the largest integer part a hex or binary literal can have
+	fixedMax     = 1<<31 - 1 // fixedMax is the largest positive 16.16 value, just under 32768
+	maxDecimals  = 5         // maxDecimals is enough decimal places to tell any two 16.16 values apart
+)
+
+// luaNumber validates a pixie number literal and returns it as the shortest Lua literal
+// with the same 16.16 fixed-point value.
+func luaNumber(literal string) (lua string, err error) {
+	fixed, err := parseFixed(literal, false)
+	if err != nil {
+		return
+	}
+	return formatFixed(fixed), nil
+}
+
+// luaNegatedNumber is luaNumber for a literal with a unary minus in front of it, which
+// lets a decimal literal go up to 32768. -32768 is written as -0x8000, which PICO-8 wraps
+// back round to -32768.
+func luaNegatedNumber(literal string) (lua string, err error) {
+	fixed, err := parseFixed(literal, true)
+	if err != nil {
+		return
+	}
+	return formatFixed(fixed), nil
+}
+
+// parseFixed converts a number literal into the 16.16 fixed-point bits PICO-8 stores it
+// as. Decimal literals are rounded to the nearest fixed-point value and must be below
+// 32768, or at most 32768 when negated. Hex and binary literals give the bits directly, so
+// their integer part may go up to 0xffff, which PICO-8 reads as a negative number, and
+// their fraction may not have more than 16 bits.
+func parseFixed(literal string, negated bool) (fixed uint32, err error) {
+	text := strings.ReplaceAll(literal, "_", "")
+
+	base, bitsPerDigit := 10, 0
+	switch {
+	case strings.HasPrefix(text, "0x"), strings.HasPrefix(text, "0X"):
+		base, bitsPerDigit = 16, 4
+	case strings.HasPrefix(text, "0b"), strings.HasPrefix(text, "0B"):
+		base, bitsPerDigit = 2, 1
+	}
+
+	if base == 10 {
+		value, parseErr := strconv.ParseFloat(text, 64)
+		if parseErr != nil {
+			err = fmt.Errorf("invalid number %q: %w", literal, parseErr)
+			return
+		}
+		scaled := math.Round(value * fixedOne)
+		if negated && scaled > fixedMax+1 {
+			err = fmt.Errorf("%w: -%s is smaller than -32768", ErrNumberRange, literal)
+			return
+		}
+		if !negated && scaled > fixedMax {
+			err = fmt.Errorf("%w: %s is larger than 32767.99998", ErrNumberRange, literal)
+			return
+		}
+		return uint32(scaled), nil
+	}
+
+	whole, fraction, _ := strings.Cut(text[2:], ".")
+	if whole == "" {
+		whole = "0"
+	}
+	integer, parseErr := strconv.ParseUint(whole, base, 64)
+	if parseErr != nil || integer > fixedMaxUint {
+		err = fmt.Errorf("%w: %s is larger than 0xffff", ErrNumberRange, literal)
+		return
+	}
+
+	// Trailing zeros add no bits, so 0x0.8000 is as precise as 0x0.8.
+	fraction = strings.TrimRight(fraction, "0")
+	fractionBits := len(fraction) * bitsPerDigit
+	if fractionBits > 16 {
+		err = fmt.Errorf("%w: %s has more than 16 bits after the point", ErrNumberRange, literal)
+		return
+	}
+
+	var frac uint64
+	if fraction != "" {
+		if frac, parseErr = strconv.ParseUint(fraction, base, 64); parseErr != nil {
+			err = fmt.Errorf("invalid number %q: %w", literal, parseErr)
+			return
+		}
+	}
+	return uint32(integer<<16 | frac<<(16-fractionBits)), nil
+}
+
+// formatFixed returns the shortest Lua literal for a 16.16 fixed-point value. Values with
+// the sign bit set are written in hex, since a negative decimal would need a unary minus.
+func formatFixed(fixed uint32) string {
+	hex := formatFixedHex(fixed)
+	if fixed > fixedMax {
+		return hex
+	}
+	if decimal := formatFixedDecimal(fixed); len(decimal) <= len(hex) {
+		return decimal
+	}
+	return hex
+}
+
+// formatFixedHex writes a fixed-point value as a hex literal such as 0x5f00 or 0x0.8.
+func formatFixedHex(fixed uint32) string {
+	hex := "0x" + strconv.FormatUint(uint64(fixed>>16), 16)
+	if frac := fixed & 0xffff; frac != 0 {
+		hex += "." + strings.TrimRight(fmt.Sprintf("%04x", frac), "0")
+	}
+	return hex
+}
+
+// formatFixedDecimal writes a non-negative fixed-point value as the decimal literal with
+// the fewest digits that rounds back to the same value, leaving out the 0 in front of the
+// point, as in .5.
+func formatFixedDecimal(fixed uint32) string {
+	if fixed&0xffff == 0 {
+		return strconv.FormatUint(uint64(fixed>>16), 10)
+	}
+
+	value := float64(fixed) / fixedOne
+	decimal := strconv.FormatFloat(value, 'f', maxDecimals, 64)
+	for places := 1; places < maxDecimals; places++ {
+		candidate := strconv.FormatFloat(value, 'f', places, 64)
+		if parsed, _ := strconv.ParseFloat(candidate, 64); math.Round(parsed*fixedOne) == float64(fixed) {
+			decimal = candidate
+			break
+		}
+	}
+
+	decimal = strings.TrimRight(decimal, "0")
+	return strings.TrimPrefix(decimal, "0")
+}
@@ -0,0 +1,687 @@
+// Package format prints pixie source in its canonical layout.
+// Statements are written one per line with single spaces around operators, obj field
+// lists and multi-line list and map literals are indented, and runs of blank lines are
+// collapsed to one. Comments are kept where they were, either on their own line or at
+// the end of the line they trailed.
+package format
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"pixie/lexer"
+	"pixie/parser"
+	"pixie/shared"
+	"sort"
+	"strings"
+)
+
+const (
+	indentUnit = "    " // indentUnit is the indentation of one nesting level
+)
+
+var (
+	ErrUnknownNode = errors.New("cannot format node") // ErrUnknownNode is returned for syntax the formatter does not know how to print
+)
+
+var (
+	// operators maps the binary operator tokens to how they are written.
+	operators = map[int]string{
+		lexer.TokenType_Plus:                              "+",
+		lexer.TokenType_Minus:                             "-",
+		lexer.TokenType_Asterisk:                          "*",
+		lexer.TokenType_ForwardSlash:                      "/",
+		lexer.TokenType_EqualEqual:                        "==",
+		lexer.TokenType_BangEqual:                         "!=",
+		lexer.TokenType_GreaterThan:                       ">",
+		lexer.TokenType_GreaterThanEqual:                  ">=",
+		lexer.TokenType_LessThan:                          "<",
+		lexer.TokenType_LessThanEqual:                     "<=",
+		lexer.TokenType_Percent:                           "%",
+		lexer.TokenType_Caret:                             "^",
+		lexer.TokenType_Backslash:                         "\\",
+		lexer.TokenType_Ampersand:                         "&",
+		lexer.TokenType_Pipe:                              "|",
+		lexer.TokenType_CaretCaret:                        "^^",
+		lexer.TokenType_LessThanLessThan:                  "<<",
+		lexer.TokenType_GreaterThanGreaterThan:            ">>",
+		lexer.TokenType_GreaterThanGreaterThanGreaterThan: ">>>",
+		lexer.TokenType_LessThanLessThanGreaterThan:       "<<>",
+		lexer.TokenType_GreaterThanGreaterThanLessThan:    ">><",
+		lexer.TokenType_AmpersandAmpersand:                "&&",
+		lexer.TokenType_PipePipe:                          "||",
+	}
+
+	// unaryOperators maps the unary operator tokens to how they are written.
+	unaryOperators = map[int]string{
+		lexer.TokenType_Minus: "-",
+		lexer.TokenType_Bang:  "!",
+		lexer.TokenType_Tilde: "~",
+	}
+
+	// endOfFile is a position after everything in a file.
+	endOfFile = lexer.Position{Line: math.MaxInt, Column: math.MaxInt}
+)
+
+// Source formats pixie source. The source must parse; if it does not, the parser's
+// *lexer.Error is returned.
+func Source(src []byte) (out []byte, err error) {
+	node, err := parser.New(lexer.New(string(src))).Parse()
+	if err != nil {
+		return
+	}
+
+	block, ok := node.(parser.StmtBlock)
+	if !ok {
+		err = fmt.Errorf("%w: expected statement block, got %T", ErrUnknownNode, node)
+		return
+	}
+
+	tokens, err := tokenize(string(src))
+	if err != nil {
+		err = fmt.Errorf("failed to tokenize: %w", err)
+		return
+	}
+
+	p := &printer{
+		tokens:      tokens,
+		comments:    block.Comments,
+		atLineStart: true,
+	}
+	for _, tok := range tokens {
+		p.marks = append(p.marks, tok.Pos)
+	}
+	for _, comment := range block.Comments {
+		p.marks = append(p.marks, comment.Pos)
+	}
+	sort.Slice(p.marks, func(i, j int) bool {
+		return before(p.marks[i], p.marks[j])
+	})
+
+	if err = p.stmts(block.Stmts, endOfFile); err != nil {
+		return
+	}
+	p.ownLineComments(endOfFile, len(block.Stmts) == 0)
+
+	return []byte(p.sb.String()), nil
+}
+
+// tokenize returns every token in src.
+func tokenize(src string) (tokens []lexer.Token, err error) {
+	l := lexer.New(src)
+	for {
+		var tok lexer.Token
+		tok, err = l.GetToken()
+		if errors.Is(err, io.EOF) {
+			return tokens, nil
+		}
+		if err != nil {
+			return
+		}
+		tokens = append(tokens, tok)
+	}
+}
+
+// printer writes formatted source. Comments are not part of the tree the statements are
+// in, so the printer interleaves them by position as it goes.
+type printer struct {
+	sb          strings.Builder
+	indent      int
+	atLineStart bool
+
+	tokens   []lexer.Token    // Every token in the source, used to find closing brackets
+	comments []lexer.Comment  // Every comment in the source
+	next     int              // Index of the next comment to print
+	marks    []lexer.Position // Positions of every token and comment, in order
+}
+
+// write writes s, indenting it when it starts a line.
+func (p *printer) write(s string) {
+	if p.atLineStart {
+		p.sb.WriteString(strings.Repeat(indentUnit, p.indent))
+		p.atLineStart = false
+	}
+	p.sb.WriteString(s)
+}
+
+// newline ends the current line. Comments that trailed the code just written, which are
+// the trailing comments before limit, are written at the end of the line first.
+func (p *printer) newline(limit lexer.Position) {
+	for p.next < len(p.comments) {
+		comment := p.comments[p.next]
+		if !comment.Trailing || !before(comment.Pos, limit) {
+			break
+		}
+		p.write(" " + comment.Text)
+		p.next++
+	}
+	p.sb.WriteRune('\n')
+	p.atLineStart = true
+}
+
+// leading writes the comments before pos, then a blank line if the source had one before
+// pos. No blank line is written before the first thing in a block.
+func (p *printer) leading(pos lexer.Position, first bool) {
+	first = p.ownLineComments(pos, first)
+	p.blankLine(pos, first)
+}
+
+// ownLineComments writes the comments before pos on lines of their own, keeping blank
+// lines between them. It reports whether nothing was written, so the caller knows if
+// pos is still the first thing in its block.
+func (p *printer) ownLineComments(pos lexer.Position, first bool) bool {
+	for p.next < len(p.comments) && before(p.comments[p.next].Pos, pos) {
+		comment := p.comments[p.next]
+		p.blankLine(comment.Pos, first)
+		p.write(comment.Text)
+		p.next++
+		p.newline(comment.Pos)
+		first = false
+	}
+	return first
+}
+
+// blankLine writes a blank line if the source had one before pos.
+func (p *printer) blankLine(pos lexer.Position, first bool) {
+	if first {
+		return
+	}
+	if prev, ok := p.prevMark(pos); ok && pos.Line > prev.Line+1 {
+		p.sb.WriteRune('\n')
+	}
+}
+
+// prevMark returns the position of the last token or comment before pos.
+func (p *printer) prevMark(pos lexer.Position) (prev lexer.Position, ok bool) {
+	i := sort.Search(len(p.marks), func(i int) bool {
+		return !before(p.marks[i], pos)
+	})
+	if i == 0 {
+		return lexer.Position{}, false
+	}
+	return p.marks[i-1], true
+}
+
+// tokensFrom returns the tokens at or after pos.
+func (p *printer) tokensFrom(pos lexer.Position) []lexer.Token {
+	i := sort.Search(len(p.tokens), func(i int) bool {
+		return !before(p.tokens[i].Pos, pos)
+	})
+	return p.tokens[i:]
+}
+
+// closing returns the position of the bracket closing the one opened at open.
+func (p *printer) closing(open lexer.Position) lexer.Position {
+	depth := 0
+	for _, tok := range p.tokensFrom(open) {
+		switch tok.Type {
+		case lexer.TokenType_OpenBrace, lexer.TokenType_OpenBracket, lexer.TokenType_OpenParan:
+			depth++
+		case lexer.TokenType_CloseBrace, lexer.TokenType_CloseBracket, lexer.TokenType_CloseParan:
+			depth--
+			if depth == 0 {
+				return tok.Pos
+			}
+		}
+	}
+	return endOfFile
+}
+
+// nextToken returns the position of the first token of the given type at or after pos.
+func (p *printer) nextToken(pos lexer.Position, tokenType int) lexer.Position {
+	for _, tok := range p.tokensFrom(pos) {
+		if tok.Type == tokenType {
+			return tok.Pos
+		}
+	}
+	return endOfFile
+}
+
+// stmts writes statements one per line. end limits the trailing comments of the last
+// statement.
+func (p *printer) stmts(stmts []parser.Stmt, end lexer.Position) (err error) {
+	for i, stmt := range stmts {
+		p.leading(stmt.Position(), i == 0)
+		if err = p.stmt(stmt); err != nil {
+			return
+		}
+
+		limit := end
+		if i+1 < len(stmts) {
+			limit = stmts[i+1].Position()
+		}
+		p.newline(limit)
+	}
+	return nil
+}
+
+func (p *printer) stmt(stmt parser.Stmt) (err error) {
+	switch n := stmt.(type) {
+	case parser.StmtCallFunction:
+		p.write(n.FunctionName)
+		p.write("(")
+		if err = p.exprList(n.Args); err != nil {
+			return
+		}
+		p.write(")")
+	case parser.StmtVarDeclare:
+		p.write(n.VariableName)
+		p.write(" ")
+		p.write(typeString(n.DataType))
+		if n.Expr != nil {
+			p.write(" = ")
+			if err = p.expr(n.Expr); err != nil {
+				return
+			}
+		}
+	case parser.StmtVarAssign:
+		operator := ""
+		if n.Operator != lexer.TokenType_Undefined {
+			var ok bool
+			if operator, ok = operators[n.Operator]; !ok {
+				err = fmt.Errorf("%w: operator %s", ErrUnknownNode, lexer.TokenTypeString[n.Operator])
+				return
+			}
+		}
+		if err = p.expr(n.Target); err != nil {
+			return
+		}
+		p.write(" " + operator + "= ")
+		if err = p.expr(n.Expr); err != nil {
+			return
+		}
+	case parser.StmtObjDefine:
+		p.stmtObjDefine(n)
+	case parser.StmtIf:
+		if err = p.stmtIf(n); err != nil {
+			return
+		}
+	case parser.StmtWhile:
+		p.write(shared.Keyword_While)
+		p.write(" ")
+		if err = p.expr(n.Cond); err != nil {
+			return
+		}
+		p.write(" ")
+		if err = p.block(n.Body); err != nil {
+			return
+		}
+	case parser.StmtFor:
+		if err = p.stmtFor(n); err != nil {
+			return
+		}
+	case parser.StmtForEach:
+		p.write(shared.Keyword_For + " " + strings.Join(n.Variables, ", ") + " " + shared.Keyword_In + " ")
+		if err = p.expr(n.Collection); err != nil {
+			return
+		}
+		p.write(" ")
+		if err = p.block(n.Body); err != nil {
+			return
+		}
+	case parser.StmtFunction:
+		if err = p.stmtFunction(n); err != nil {
+			return
+		}
+	case parser.StmtReturn:
+		p.write(shared.Keyword_Return)
+		if n.Expr != nil {
+			p.write(" ")
+			if err = p.expr(n.Expr); err != nil {
+				return
+			}
+		}
+	case parser.StmtExpr:
+		if err = p.expr(n.Expr); err != nil {
+			return
+		}
+	case parser.StmtBreak:
+		p.write(shared.Keyword_Break)
+	case parser.StmtContinue:
+		p.write(shared.Keyword_Continue)
+	case parser.StmtImport:
+		p.write(shared.Keyword_Import)
+		p.write(" ")
+		p.write(quote(n.Path))
+	default:
+		err = fmt.Errorf("%w: %T", ErrUnknownNode, stmt)
+		return
+	}
+	return nil
+}
+
+func (p *printer) stmtIf(stmt parser.StmtIf) (err error) {
+	p.write(shared.Keyword_If)
+	p.write(" ")
+	if err = p.expr(stmt.Cond); err != nil {
+		return
+	}
+	p.write(" ")
+	if err = p.block(stmt.Then); err != nil {
+		return
+	}
+
+	switch e := stmt.Else.(type) {
+	case nil:
+	case parser.StmtIf:
+		p.write(" " + shared.Keyword_Else + " ")
+		return p.stmtIf(e)
+	case parser.StmtBlock:
+		p.write(" " + shared.Keyword_Else + " ")
+		return p.block(e)
+	default:
+		err = fmt.Errorf("%w: else %T", ErrUnknownNode, e)
+	}
+	return
+}
+
+func (p *printer) stmtFor(stmt parser.StmtFor) (err error) {
+	p.write(shared.Keyword_For + " " + stmt.Variable + " " + shared.Keyword_In + " ")
+	if err = p.expr(stmt.Start); err != nil {
+		return
+	}
+	p.write("..")
+	if err = p.expr(stmt.End); err != nil {
+		return
+	}
+	if stmt.Step != nil {
+		p.write(" " + shared.Keyword_Step + " ")
+		if err = p.expr(stmt.Step); err != nil {
+			return
+		}
+	}
+	p.write(" ")
+	return p.block(stmt.Body)
+}
+
+func (p *printer) stmtFunction(stmt parser.StmtFunction) (err error) {
+	p.write(shared.Keyword_Function + " " + stmt.Name + "(")
+	for i, param := range stmt.Params {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.write(param.Field + " " + typeString(param.Type))
+	}
+	p.write(") ")
+	if stmt.ReturnType != nil {
+		p.write(typeString(stmt.ReturnType) + " ")
+	}
+	return p.block(stmt.Body)
+}
+
+// block writes a braced block of statements, each on its own indented line.
+func (p *printer) block(block parser.StmtBlock) (err error) {
+	close := p.closing(block.Pos)
+
+	p.write("{")
+	p.indent++
+	limit := close
+	if len(block.Stmts) > 0 {
+		limit = block.Stmts[0].Position()
+	}
+	p.newline(limit)
+	if err = p.stmts(block.Stmts, close); err != nil {
+		return
+	}
+	p.ownLineComments(close, len(block.Stmts) == 0)
+	p.indent--
+
+	p.write("}")
+	return nil
+}
+
+func (p *printer) stmtObjDefine(stmt parser.StmtObjDefine) {
+	open := p.nextToken(stmt.Pos, lexer.TokenType_OpenBrace)
+	close := p.closing(open)
+
+	p.write(stmt.Name)
+	p.write(" ")
+	p.write(shared.Keyword_Object)
+	p.write(" {")
+
+	p.indent++
+	for i, field := range stmt.Fields {
+		if i == 0 {
+			p.newline(field.Pos)
+		}
+		p.leading(field.Pos, i == 0)
+		p.write(field.Field)
+		p.write(" ")
+		p.write(typeString(field.Type))
+
+		limit := close
+		if i+1 < len(stmt.Fields) {
+			limit = stmt.Fields[i+1].Pos
+		}
+		p.newline(limit)
+	}
+	p.ownLineComments(close, false)
+	p.indent--
+
+	p.write("}")
+}
+
+func (p *printer) expr(expr parser.Expr) (err error) {
+	switch n := expr.(type) {
+	case parser.ExprBlock:
+		p.write("(")
+		if err = p.expr(n.Value); err != nil {
+			return
+		}
+		p.write(")")
+	case parser.ExprNumber:
+		p.write(n.Value)
+	case parser.ExprString:
+		// The literal is kept as written, since escapes such as \x0c cannot be rebuilt from
+		// the characters they decode to
+		if n.Raw != "" {
+			p.write(n.Raw)
+		} else {
+			p.write(quote(n.Value))
+		}
+	case parser.ExprBoolean:
+		p.write(n.Value)
+	case parser.ExprVariable:
+		p.write(n.Name)
+	case parser.ExprList:
+		values := make([]func() error, len(n.Values))
+		positions := make([]lexer.Position, len(n.Values))
+		for i, value := range n.Values {
+			value := value
+			values[i] = func() error { return p.expr(value) }
+			positions[i] = value.Position()
+		}
+		if err = p.composite(n.Pos, "[", "]", values, positions); err != nil {
+			return
+		}
+	case parser.ExprTable:
+		pairs := make([]func() error, len(n.Pairs))
+		positions := make([]lexer.Position, len(n.Pairs))
+		for i, pair := range n.Pairs {
+			pair := pair
+			pairs[i] = func() (err error) {
+				if err = p.expr(pair.Key); err != nil {
+					return
+				}
+				p.write(": ")
+				return p.expr(pair.Value)
+			}
+			positions[i] = pair.Key.Position()
+		}
+		if err = p.composite(n.Pos, "{", "}", pairs, positions); err != nil {
+			return
+		}
+	case parser.ExprIndex:
+		if err = p.expr(n.Left); err != nil {
+			return
+		}
+		p.write("[")
+		if err = p.expr(n.Index); err != nil {
+			return
+		}
+		p.write("]")
+	case parser.ExprPropertyAccess:
+		if err = p.expr(n.Left); err != nil {
+			return
+		}
+		p.write(".")
+		p.write(n.Property)
+	case parser.ExprUnary:
+		operator, ok := unaryOperators[n.Operator]
+		if !ok {
+			err = fmt.Errorf("%w: operator %s", ErrUnknownNode, lexer.TokenTypeString[n.Operator])
+			return
+		}
+		p.write(operator)
+		if err = p.expr(n.Operand); err != nil {
+			return
+		}
+	case parser.ExprCall:
+		p.write(n.FunctionName)
+		p.write("(")
+		if err = p.exprList(n.Args); err != nil {
+			return
+		}
+		p.write(")")
+	case parser.ExprMemberCall:
+		if err = p.expr(n.Callee); err != nil {
+			return
+		}
+		p.write("(")
+		if err = p.exprList(n.Args); err != nil {
+			return
+		}
+		p.write(")")
+	case parser.ExprConditional:
+		if err = p.expr(n.Condition); err != nil {
+			return
+		}
+		p.write(" ? ")
+		if err = p.expr(n.Then); err != nil {
+			return
+		}
+		p.write(" : ")
+		if err = p.expr(n.Else); err != nil {
+			return
+		}
+	case parser.ExprBinary:
+		operator, ok := operators[n.Operator]
+		if !ok {
+			err = fmt.Errorf("%w: operator %s", ErrUnknownNode, lexer.TokenTypeString[n.Operator])
+			return
+		}
+		if err = p.expr(n.Left); err != nil {
+			return
+		}
+		p.write(" " + operator + " ")
+		if err = p.expr(n.Right); err != nil {
+			return
+		}
+	default:
+		err = fmt.Errorf("%w: %T", ErrUnknownNode, expr)
+		return
+	}
+	return nil
+}
+
+// exprList writes expressions separated by commas on a single line.
+func (p *printer) exprList(exprs []parser.Expr) (err error) {
+	for i, expr := range exprs {
+		if i > 0 {
+			p.write(", ")
+		}
+		if err = p.expr(expr); err != nil {
+			return
+		}
+	}
+	return nil
+}
+
+// composite writes a list or map literal. A literal that spanned several lines in the
+// source is written with one element per line, indented and followed by a comma;
+// otherwise it stays on one line, without a trailing comma.
+func (p *printer) composite(open lexer.Position, opening, closing string, elements []func() error, positions []lexer.Position) (err error) {
+	close := p.closing(open)
+
+	p.write(opening)
+	if close.Line == open.Line || len(elements) == 0 {
+		for i, element := range elements {
+			if i > 0 {
+				p.write(", ")
+			}
+			if err = element(); err != nil {
+				return
+			}
+		}
+		p.write(closing)
+		return nil
+	}
+
+	p.indent++
+	p.newline(positions[0])
+	for i, element := range elements {
+		p.leading(positions[i], i == 0)
+		if err = element(); err != nil {
+			return
+		}
+
+		// Every element is followed by a comma, so adding one at the end changes one line
+		p.write(",")
+		limit := close
+		if i+1 < len(elements) {
+			limit = positions[i+1]
+		}
+		p.newline(limit)
+	}
+	p.ownLineComments(close, false)
+	p.indent--
+
+	p.write(closing)
+	return nil
+}
+
+// typeString writes a data type the way it is spelled in source.
+func typeString(dataType shared.DataType) string {
+	switch t := dataType.(type) {
+	case shared.List:
+		return shared.Keyword_List + "[" + typeString(t.ListType) + "]"
+	case shared.Map:
+		return shared.Keyword_Map + "[" + typeString(t.KeyType) + ":" + typeString(t.ValueType) + "]"
+	case shared.Custom:
+		return t.Name
+	default:
+		return dataType.String()
+	}
+}
+
+// quote writes a string literal, escaping the characters the lexer would not read back
+// as they are.
+func quote(s string) string {
+	var sb strings.Builder
+	sb.WriteRune('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			sb.WriteRune('\\')
+			sb.WriteRune(r)
+		case r == '\n':
+			sb.WriteString(`\n`)
+		case r == '\t':
+			sb.WriteString(`\t`)
+		case r == '\r':
+			sb.WriteString(`\r`)
+		case r < ' ' || r == 0x7f:
+			fmt.Fprintf(&sb, `\u{%x}`, r)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteRune('"')
+	return sb.String()
+}
+
+// before reports whether a comes before b.
+func before(a, b lexer.Position) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
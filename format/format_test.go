@@ -0,0 +1,213 @@
+package format
+
+import (
+	"os"
+	"path/filepath"
+	"pixie/compiler"
+	"pixie/lexer"
+	"pixie/parser"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Source(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "operator_spacing",
+			input:    "x num=1+2*3\ny num = (x-1)/2\nb bool=x>=y",
+			expected: "x num = 1 + 2 * 3\ny num = (x - 1) / 2\nb bool = x >= y\n",
+		},
+		{
+			name:     "call_arguments",
+			input:    "print( \"a\" ,1+2 )",
+			expected: "print(\"a\", 1 + 2)\n",
+		},
+		{
+			name:     "obj_fields",
+			input:    "point obj { x num\n y num }",
+			expected: "point obj {\n    x num\n    y num\n}\n",
+		},
+		{
+			name:     "single_line_literals",
+			input:    "l list[num] = [1,2,3]\nm map[str:num] = {\"a\":1, \"b\":2}",
+			expected: "l list[num] = [1, 2, 3]\nm map[str:num] = {\"a\": 1, \"b\": 2}\n",
+		},
+		{
+			name:     "multi_line_literals",
+			input:    "l list[list[num]] = [[1,2],\n[3]]\nm map[str:num] = {\n\"a\":1}",
+			expected: "l list[list[num]] = [\n    [1, 2],\n    [3],\n]\nm map[str:num] = {\n    \"a\": 1,\n}\n",
+		},
+		{
+			name:     "trailing_commas",
+			input:    "l list[num] = [1, 2,]\nm map[str:num] = {\n\"a\": 1,\n}\nfn f(a num, b num,) {\nprint(a, b,)\n}",
+			expected: "l list[num] = [1, 2]\nm map[str:num] = {\n    \"a\": 1,\n}\nfn f(a num, b num) {\n    print(a, b)\n}\n",
+		},
+		{
+			name:     "empty_literals",
+			input:    "l list[num] = [ ]\nm map[str:num] = {\n}\ncls( )",
+			expected: "l list[num] = []\nm map[str:num] = {}\ncls()\n",
+		},
+		{
+			name:     "conditional",
+			input:    "col num = alive?7:5\nprint(a?1:b ?2:3)",
+			expected: "col num = alive ? 7 : 5\nprint(a ? 1 : b ? 2 : 3)\n",
+		},
+		{
+			name:     "blank_lines",
+			input:    "\n\nx num = 1\n\n\n\ny num = 2\nz num = 3\n\n",
+			expected: "x num = 1\n\ny num = 2\nz num = 3\n",
+		},
+		{
+			name:     "own_line_comments",
+			input:    "// header\n\n// x is one\nx num = 1\n  // footer",
+			expected: "// header\n\n// x is one\nx num = 1\n// footer\n",
+		},
+		{
+			name:     "trailing_comments",
+			input:    "x num = 1   // one\nprint(x)//two",
+			expected: "x num = 1 // one\nprint(x) //two\n",
+		},
+		{
+			name:     "comments_in_obj",
+			input:    "point obj {\n x num // across\n\n  // down\n y num\n // end\n}",
+			expected: "point obj {\n    x num // across\n\n    // down\n    y num\n    // end\n}\n",
+		},
+		{
+			name:     "comments_in_literal",
+			input:    "l list[num] = [1, // one\n2 // two\n]",
+			expected: "l list[num] = [\n    1, // one\n    2, // two\n]\n",
+		},
+		{
+			name:     "only_comments",
+			input:    "// nothing here\n",
+			expected: "// nothing here\n",
+		},
+		{
+			name:     "number_literals",
+			input:    "print(0x5F00,0b1111_0000 , 0.5)",
+			expected: "print(0x5F00, 0b1111_0000, 0.5)\n",
+		},
+		{
+			name:     "string_escapes",
+			input:    "print(\"say \\\"hi\\\"\\n\\\\ \\x87 \\u{2605}\\u{c}\")",
+			expected: "print(\"say \\\"hi\\\"\\n\\\\ \\x87 \\u{2605}\\u{c}\")\n",
+		},
+		{
+			name:     "string_escapes_kept",
+			input:    `print("\x0c8", "\x01", "\x80", "♥")`,
+			expected: "print(\"\\x0c8\", \"\\x01\", \"\\x80\", \"♥\")\n",
+		},
+		{
+			name:     "unary_operators",
+			input:    "x num = - 1\nb bool = ! (x > 0)\ny num = -x * ~ x",
+			expected: "x num = -1\nb bool = !(x > 0)\ny num = -x * ~x\n",
+		},
+		{
+			name:     "logical_operators",
+			input:    "b bool = 1>0&&!false||(2<1)",
+			expected: "b bool = 1 > 0 && !false || (2 < 1)\n",
+		},
+		{
+			name:     "bitwise_operators",
+			input:    "x num = 1<<4|0x0f&~2^^3\ny num = 2^-x%3\\2",
+			expected: "x num = 1 << 4 | 0x0f & ~2 ^^ 3\ny num = 2 ^ -x % 3 \\ 2\n",
+		},
+		{
+			name:     "compound_assignment",
+			input:    "x num = 1\nx+=2\nx  >>>=  1\ns str\ns +=\"a\"",
+			expected: "x num = 1\nx += 2\nx >>>= 1\ns str\ns += \"a\"\n",
+		},
+		{
+			name:     "if_else",
+			input:    "if x>0{ // positive\nprint(1)\n\n\n  y num = 2\n}else if x<0 {print(2)} else {\n// nothing\n}",
+			expected: "if x > 0 { // positive\n    print(1)\n\n    y num = 2\n} else if x < 0 {\n    print(2)\n} else {\n    // nothing\n}\n",
+		},
+		{
+			name:     "nested_if",
+			input:    "if a {\nif b {\nprint(1)\n}\n}",
+			expected: "if a {\n    if b {\n        print(1)\n    }\n}\n",
+		},
+		{
+			name:     "loops",
+			input:    "for i in 0 .. n-1 step 2{\nif i==4 {continue}\n}\nwhile true { break }",
+			expected: "for i in 0..n - 1 step 2 {\n    if i == 4 {\n        continue\n    }\n}\nwhile true {\n    break\n}\n",
+		},
+		{
+			name:     "for_each",
+			input:    "for v in l{print(v)}\nfor k,v in m {}",
+			expected: "for v in l {\n    print(v)\n}\nfor k, v in m {\n}\n",
+		},
+		{
+			name:     "functions",
+			input:    "fn add(a num,b num)num{return a+b}\nfn log(s str) {\nprint(s)\nreturn\n}",
+			expected: "fn add(a num, b num) num {\n    return a + b\n}\nfn log(s str) {\n    print(s)\n    return\n}\n",
+		},
+		{
+			name:     "call_expressions",
+			input:    "hp = max( hp-1 ,0 )\nprint(double(double( 2)))",
+			expected: "hp = max(hp - 1, 0)\nprint(double(double(2)))\n",
+		},
+		{
+			name:     "assign_targets",
+			input:    "grid[y] [x+1]=1\np.name=\"a\"\nm[\"k\"]+=2",
+			expected: "grid[y][x + 1] = 1\np.name = \"a\"\nm[\"k\"] += 2\n",
+		},
+		{
+			name:     "import",
+			input:    "import   \"lib.pixie\"\nprint(1)",
+			expected: "import \"lib.pixie\"\nprint(1)\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := Source([]byte(test.input))
+			require.NoError(t, err)
+			require.Equal(t, test.expected, string(out))
+
+			again, err := Source(out)
+			require.NoError(t, err)
+			require.Equal(t, string(out), string(again), "formatting is not idempotent")
+		})
+	}
+}
+
+func Test_Source_ParseError(t *testing.T) {
+	_, err := Source([]byte("x num = "))
+	require.Error(t, err)
+}
+
+func Test_Source_Examples(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "examples", "*.pixie"))
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			src, err := os.ReadFile(file)
+			require.NoError(t, err)
+
+			out, err := Source(src)
+			require.NoError(t, err)
+
+			again, err := Source(out)
+			require.NoError(t, err)
+			require.Equal(t, string(out), string(again), "formatting is not idempotent")
+
+			require.Equal(t, compile(t, src), compile(t, out), "formatting changed the compiled Lua")
+		})
+	}
+}
+
+func compile(t *testing.T, src []byte) string {
+	node, err := parser.New(lexer.New(string(src))).Parse()
+	require.NoError(t, err)
+	lua, err := compiler.Compile(node)
+	require.NoError(t, err)
+	return lua
+}
@@ -6,8 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"pixie/p8scii"
 	"pixie/shared"
+	"strconv"
 	"unicode"
+	"unicode/utf8"
 )
 
 // TokenType represents the different types of tokens that can be recognized by the lexer.
@@ -37,6 +40,38 @@ const (
 	TokenType_GreaterThanEqual      // TokenType_GreaterThanEqual represents a >= character
 	TokenType_LessThan              // TokenType_LessThan represents a < character
 	TokenType_LessThanEqual         // TokenType_LessThanEqual represents a <= character
+	TokenType_Bang                  // TokenType_Bang represents a ! character
+	TokenType_Tilde                 // TokenType_Tilde represents a ~ character
+	TokenType_AmpersandAmpersand    // TokenType_AmpersandAmpersand represents a && character
+	TokenType_PipePipe              // TokenType_PipePipe represents a || character
+	TokenType_Percent               // TokenType_Percent represents a % character
+	TokenType_Caret                 // TokenType_Caret represents a ^ character
+	TokenType_Backslash             // TokenType_Backslash represents a \ character
+	TokenType_Ampersand             // TokenType_Ampersand represents a & character
+	TokenType_Pipe                  // TokenType_Pipe represents a | character
+	TokenType_CaretCaret            // TokenType_CaretCaret represents a ^^ character
+	TokenType_LessThanLessThan      // TokenType_LessThanLessThan represents a << character
+	TokenType_GreaterThanGreaterThan // TokenType_GreaterThanGreaterThan represents a >> character
+	TokenType_GreaterThanGreaterThanGreaterThan // TokenType_GreaterThanGreaterThanGreaterThan represents a >>> character
+	TokenType_LessThanLessThanGreaterThan       // TokenType_LessThanLessThanGreaterThan represents a <<> character
+	TokenType_GreaterThanGreaterThanLessThan    // TokenType_GreaterThanGreaterThanLessThan represents a >>< character
+	TokenType_PlusEqual                         // TokenType_PlusEqual represents a += character
+	TokenType_MinusEqual                        // TokenType_MinusEqual represents a -= character
+	TokenType_AsteriskEqual                     // TokenType_AsteriskEqual represents a *= character
+	TokenType_ForwardSlashEqual                 // TokenType_ForwardSlashEqual represents a /= character
+	TokenType_PercentEqual                      // TokenType_PercentEqual represents a %= character
+	TokenType_CaretEqual                        // TokenType_CaretEqual represents a ^= character
+	TokenType_BackslashEqual                    // TokenType_BackslashEqual represents a \= character
+	TokenType_AmpersandEqual                    // TokenType_AmpersandEqual represents a &= character
+	TokenType_PipeEqual                         // TokenType_PipeEqual represents a |= character
+	TokenType_CaretCaretEqual                   // TokenType_CaretCaretEqual represents a ^^= character
+	TokenType_LessThanLessThanEqual             // TokenType_LessThanLessThanEqual represents a <<= character
+	TokenType_GreaterThanGreaterThanEqual       // TokenType_GreaterThanGreaterThanEqual represents a >>= character
+	TokenType_GreaterThanGreaterThanGreaterThanEqual // TokenType_GreaterThanGreaterThanGreaterThanEqual represents a >>>= character
+	TokenType_LessThanLessThanGreaterThanEqual       // TokenType_LessThanLessThanGreaterThanEqual represents a <<>= character
+	TokenType_GreaterThanGreaterThanLessThanEqual    // TokenType_GreaterThanGreaterThanLessThanEqual represents a >><= character
+	TokenType_PeriodPeriod                           // TokenType_PeriodPeriod represents a .. character
+	TokenType_Question                               // TokenType_Question represents a ? character
 )
 
 // TokenTypeString maps token type constants to their string representations for debugging and display purposes.
@@ -67,6 +102,38 @@ var (
 		TokenType_GreaterThanEqual: "GreaterThanEqual",
 		TokenType_LessThan:       "LessThan",
 		TokenType_LessThanEqual:  "LessThanEqual",
+		TokenType_Bang:           "Bang",
+		TokenType_Tilde:          "Tilde",
+		TokenType_AmpersandAmpersand: "AmpersandAmpersand",
+		TokenType_PipePipe:       "PipePipe",
+		TokenType_Percent:        "Percent",
+		TokenType_Caret:          "Caret",
+		TokenType_Backslash:      "Backslash",
+		TokenType_Ampersand:      "Ampersand",
+		TokenType_Pipe:           "Pipe",
+		TokenType_CaretCaret:     "CaretCaret",
+		TokenType_LessThanLessThan: "LessThanLessThan",
+		TokenType_GreaterThanGreaterThan: "GreaterThanGreaterThan",
+		TokenType_GreaterThanGreaterThanGreaterThan: "GreaterThanGreaterThanGreaterThan",
+		TokenType_LessThanLessThanGreaterThan: "LessThanLessThanGreaterThan",
+		TokenType_GreaterThanGreaterThanLessThan: "GreaterThanGreaterThanLessThan",
+		TokenType_PlusEqual:      "PlusEqual",
+		TokenType_MinusEqual:     "MinusEqual",
+		TokenType_AsteriskEqual:  "AsteriskEqual",
+		TokenType_ForwardSlashEqual: "ForwardSlashEqual",
+		TokenType_PercentEqual:   "PercentEqual",
+		TokenType_CaretEqual:     "CaretEqual",
+		TokenType_BackslashEqual: "BackslashEqual",
+		TokenType_AmpersandEqual: "AmpersandEqual",
+		TokenType_PipeEqual:      "PipeEqual",
+		TokenType_CaretCaretEqual: "CaretCaretEqual",
+		TokenType_LessThanLessThanEqual: "LessThanLessThanEqual",
+		TokenType_GreaterThanGreaterThanEqual: "GreaterThanGreaterThanEqual",
+		TokenType_GreaterThanGreaterThanGreaterThanEqual: "GreaterThanGreaterThanGreaterThanEqual",
+		TokenType_LessThanLessThanGreaterThanEqual: "LessThanLessThanGreaterThanEqual",
+		TokenType_GreaterThanGreaterThanLessThanEqual: "GreaterThanGreaterThanLessThanEqual",
+		TokenType_PeriodPeriod:   "PeriodPeriod",
+		TokenType_Question:       "Question",
 	}
 
 	TokenTypeCharactersMap map[rune]Token = map[rune]Token{
@@ -83,14 +150,71 @@ var (
 		']': {Type: TokenType_CloseBracket},
 		'{': {Type: TokenType_OpenBrace},
 		'}': {Type: TokenType_CloseBrace},
+		'~': {Type: TokenType_Tilde},
+		'!': {Type: TokenType_Bang},
+		'<': {Type: TokenType_LessThan},
+		'>': {Type: TokenType_GreaterThan},
+		'%': {Type: TokenType_Percent},
+		'^': {Type: TokenType_Caret},
+		'\\': {Type: TokenType_Backslash},
+		'&': {Type: TokenType_Ampersand},
+		'|': {Type: TokenType_Pipe},
+		'?': {Type: TokenType_Question},
+	}
+
+	// TokenTypeOperators lists the operators longer than one character, longest first so
+	// the first match is the longest one.
+	TokenTypeOperators = []struct {
+		Text  string
+		Token Token
+	}{
+		{">>>=", Token{Type: TokenType_GreaterThanGreaterThanGreaterThanEqual}},
+		{"<<>=", Token{Type: TokenType_LessThanLessThanGreaterThanEqual}},
+		{">><=", Token{Type: TokenType_GreaterThanGreaterThanLessThanEqual}},
+		{"^^=", Token{Type: TokenType_CaretCaretEqual}},
+		{"<<=", Token{Type: TokenType_LessThanLessThanEqual}},
+		{">>=", Token{Type: TokenType_GreaterThanGreaterThanEqual}},
+		{">>>", Token{Type: TokenType_GreaterThanGreaterThanGreaterThan}},
+		{"<<>", Token{Type: TokenType_LessThanLessThanGreaterThan}},
+		{">><", Token{Type: TokenType_GreaterThanGreaterThanLessThan}},
+		{"==", Token{Type: TokenType_EqualEqual}},
+		{"!=", Token{Type: TokenType_BangEqual}},
+		{"<=", Token{Type: TokenType_LessThanEqual}},
+		{">=", Token{Type: TokenType_GreaterThanEqual}},
+		{"&&", Token{Type: TokenType_AmpersandAmpersand}},
+		{"||", Token{Type: TokenType_PipePipe}},
+		{"^^", Token{Type: TokenType_CaretCaret}},
+		{"<<", Token{Type: TokenType_LessThanLessThan}},
+		{">>", Token{Type: TokenType_GreaterThanGreaterThan}},
+		{"+=", Token{Type: TokenType_PlusEqual}},
+		{"-=", Token{Type: TokenType_MinusEqual}},
+		{"*=", Token{Type: TokenType_AsteriskEqual}},
+		{"/=", Token{Type: TokenType_ForwardSlashEqual}},
+		{"%=", Token{Type: TokenType_PercentEqual}},
+		{"^=", Token{Type: TokenType_CaretEqual}},
+		{"\\=", Token{Type: TokenType_BackslashEqual}},
+		{"&=", Token{Type: TokenType_AmpersandEqual}},
+		{"|=", Token{Type: TokenType_PipeEqual}},
 	}
 )
 
 var (
-	errUnexpectedEOF = errors.New("unexpected EOF") // errUnexpectedEOF is returned when a string literal is not properly closed
-	errInvalidRune   = errors.New("invalid rune")   // errInvalidRune is returned when an invalid character is encountered
+	errUnexpectedEOF = errors.New("unexpected EOF")          // errUnexpectedEOF is returned when a string literal is not properly closed
+	errInvalidRune   = errors.New("invalid rune")            // errInvalidRune is returned when an invalid character is encountered
+	errInvalidEscape = errors.New("invalid escape sequence") // errInvalidEscape is returned when a string literal contains an unknown or malformed escape
+	errInvalidNumber = errors.New("invalid number literal")  // errInvalidNumber is returned when a number literal has no digits or misplaced separators
 )
 
+// escapes maps the single character escapes allowed in string literals to the character
+// they stand for.
+var escapes = map[rune]rune{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'"':  '"',
+	'\\': '\\',
+}
+
 // isLabelRune returns whether the provided rune is a valid label rune.
 // Valid label runes are letters, numbers, and underscores.
 func isLabelRune(r rune) bool {
@@ -99,8 +223,134 @@ func isLabelRune(r rune) bool {
 
 // Token represents a single token from the input string with its type and value.
 type Token struct {
-	Type  int    // The type of the token as defined by the TokenType constants
-	Value string // The actual text value of the token from the input string
+	Type  int      // The type of the token as defined by the TokenType constants
+	Value string   // The actual text value of the token from the input string
+	Raw   string   // The source text of a string literal, quotes and escapes included
+	Pos   Position // Where the token starts in the input
+}
+
+// Comment is a // comment. The lexer skips comments when producing tokens but records
+// them so tools such as the formatter can put them back.
+type Comment struct {
+	Text     string   // The comment text, including the leading //
+	Pos      Position // Where the comment starts
+	Trailing bool     // Whether the comment follows a token on the same line
+}
+
+// Position is a location in pixie source.
+type Position struct {
+	File   string // Name of the source file, empty when the source is not a file
+	Line   int    // Line number, starting at 1
+	Column int    // Column number counted in runes, starting at 1
+	Offset int    // Byte offset from the start of the source, starting at 0
+}
+
+// IsValid reports whether the position refers to a location in the source.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// String formats the position as file:line:column, leaving out the file when it is unknown.
+func (p Position) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// Error is an error at a position in pixie source. Its message starts with the position,
+// as in "game.pixie:3:7: unexpected token".
+type Error struct {
+	Pos Position
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// located records the position an error happened at without changing its message, so
+// the error can be wrapped with context on its way up before Locate moves the position
+// to the front.
+type located struct {
+	pos Position
+	err error
+}
+
+func (e *located) Error() string {
+	return e.err.Error()
+}
+
+func (e *located) Unwrap() error {
+	return e.err
+}
+
+// ErrorAt records that err happened at pos. The position is reported once the error
+// reaches Locate.
+func ErrorAt(pos Position, err error) error {
+	return &located{pos: pos, err: err}
+}
+
+// Errorf is ErrorAt for an error formatted like fmt.Errorf.
+func Errorf(pos Position, format string, args ...any) error {
+	return ErrorAt(pos, fmt.Errorf(format, args...))
+}
+
+// Locate returns err as an *Error at the innermost position recorded by ErrorAt, or at
+// pos when err has no position recorded. Errors that are already an *Error are returned
+// unchanged.
+func Locate(err error, pos Position) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+
+	for inner := err; inner != nil; inner = unwrap(inner) {
+		if _, ok := inner.(*Error); ok {
+			break
+		}
+		if l, ok := inner.(*located); ok {
+			pos = l.pos
+		}
+	}
+	return &Error{Pos: pos, Err: err}
+}
+
+// Primary returns the innermost error recorded by ErrorAt in err as an *Error at its
+// position, leaving out the context err was wrapped in on its way up. An err with no
+// position recorded is returned whole at pos, as Locate would.
+func Primary(err error, pos Position) *Error {
+	primary := &Error{Pos: pos, Err: err}
+	for inner := err; inner != nil; inner = unwrap(inner) {
+		if e, ok := inner.(*Error); ok {
+			if primary.Err == err {
+				return e
+			}
+			break
+		}
+		if l, ok := inner.(*located); ok {
+			primary = &Error{Pos: l.pos, Err: l.err}
+		}
+	}
+	return primary
+}
+
+// unwrap returns the error err wraps. Of an error wrapping several, as made by
+// fmt.Errorf("%w: %w", ErrSentinel, err), it returns the last, which carries the detail.
+func unwrap(err error) error {
+	if multi, ok := err.(interface{ Unwrap() []error }); ok {
+		if errs := multi.Unwrap(); len(errs) > 0 {
+			return errs[len(errs)-1]
+		}
+		return nil
+	}
+	return errors.Unwrap(err)
 }
 
 // String makes Token implement the Stringer interface.
@@ -122,13 +372,67 @@ func New(input string) *Lexer {
 	}
 }
 
+// NewFile creates a Lexer like New whose token positions refer to the named file.
+func NewFile(file, input string) *Lexer {
+	l := New(input)
+	l.file = file
+	return l
+}
+
 // Lexer provides functionality to tokenize an input string into a sequence of tokens.
 // It supports peeking at the next token without consuming it and handles various token types
 // including numbers, strings, labels, and boolean literals.
 type Lexer struct {
 	input []rune // The input string converted to runes for proper Unicode handling
 	index int    // Current position in the input
-	buf   *Token // Buffered token for peeking functionality
+	buf   *Token      // Buffered token for peeking functionality
+	file  string      // Name of the source file, used in token positions
+	start int         // Index where the token being scanned starts
+	lines lineCounter // Converts input indexes to token positions
+	pos   Position    // Position of the last token scanned
+
+	comments []Comment // Comments skipped so far
+	lastLine int       // Line of the last token scanned, to tell trailing comments apart
+}
+
+// Pos returns the position of the last token scanned, including a token that was only
+// peeked at. Once the input runs out it is the position of the end of the input.
+func (l *Lexer) Pos() Position {
+	return l.pos
+}
+
+// Comments returns the comments the lexer has skipped so far, in source order.
+func (l *Lexer) Comments() []Comment {
+	return l.comments
+}
+
+// lineCounter converts input indexes to line and column numbers. Tokens are scanned in
+// order, so it carries on from the last index it counted to instead of starting over.
+type lineCounter struct {
+	index  int
+	line   int
+	column int
+	offset int
+}
+
+// positionAt returns the position of the rune at index.
+func (l *Lexer) positionAt(index int) Position {
+	if l.lines.line == 0 || index < l.lines.index {
+		l.lines = lineCounter{line: 1, column: 1}
+	}
+
+	for ; l.lines.index < index && l.lines.index < len(l.input); l.lines.index++ {
+		r := l.input[l.lines.index]
+		if r == '\n' {
+			l.lines.line++
+			l.lines.column = 1
+		} else {
+			l.lines.column++
+		}
+		l.lines.offset += utf8.RuneLen(r)
+	}
+
+	return Position{File: l.file, Line: l.lines.line, Column: l.lines.column, Offset: l.lines.offset}
 }
 
 // getRune returns the rune at the current index of the input and increments the index.
@@ -159,9 +463,9 @@ func (l *Lexer) ConsumeToken(expected int) (err error) {
 		return err
 	}
 	if tok.Type != expected {
-		err = fmt.Errorf("unexpected token, wanted %q got %q", TokenTypeString[expected], tok.String())
+		err = Errorf(tok.Pos, "unexpected token, wanted %q got %q", TokenTypeString[expected], tok.String())
 	}
-	return nil
+	return err
 }
 
 // GetToken returns the next token in the input string.
@@ -171,6 +475,8 @@ func (l *Lexer) ConsumeToken(expected int) (err error) {
 // - Strings (enclosed in double quotes)
 // - Labels (identifiers starting with letters, numbers, or underscores)
 // - Boolean literals (true and false)
+// Every token records the position it starts at, and errors record the position of the
+// character they were found at.
 func (l *Lexer) GetToken() (tok Token, err error) {
 	if l.buf != nil {
 		tok = *l.buf
@@ -178,6 +484,23 @@ func (l *Lexer) GetToken() (tok Token, err error) {
 		return tok, nil
 	}
 
+	tok, err = l.getToken()
+	if errors.Is(err, io.EOF) {
+		l.pos = l.positionAt(len(l.input))
+		return
+	}
+	if err != nil {
+		err = ErrorAt(l.positionAt(l.start), err)
+		return
+	}
+	tok.Pos = l.positionAt(l.start)
+	l.pos = tok.Pos
+	l.lastLine = tok.Pos.Line
+	return tok, nil
+}
+
+// getToken scans the next token, recording where it starts in l.start.
+func (l *Lexer) getToken() (tok Token, err error) {
 	var r rune
 	var ok bool
 
@@ -193,6 +516,7 @@ func (l *Lexer) GetToken() (tok Token, err error) {
 			continue
 		}
 
+		l.start = l.index
 		if unicode.IsNumber(r) {
 			return l.getTokenNumberLiteral()
 		}
@@ -210,48 +534,12 @@ func (l *Lexer) GetToken() (tok Token, err error) {
 			// Note: This means that decimals starting with a period (like ".5")
 			// will be tokenized as [Period, Number] which is the expected behavior
 			// based on the test cases
-			l.index++
-			return Token{Type: TokenType_Period}, nil
-		case '=':
-			// Handle == operator
-			nextIndex := l.index + 1
-			if nextIndex < len(l.input) && l.input[nextIndex] == '=' {
+			if l.followedBy('.') {
 				l.index += 2
-				return Token{Type: TokenType_EqualEqual}, nil
+				return Token{Type: TokenType_PeriodPeriod}, nil
 			}
-			// Single = is already handled in TokenTypeCharactersMap
 			l.index++
-			return Token{Type: TokenType_Equal}, nil
-		case '!':
-			// Handle != operator
-			nextIndex := l.index + 1
-			if nextIndex < len(l.input) && l.input[nextIndex] == '=' {
-				l.index += 2
-				return Token{Type: TokenType_BangEqual}, nil
-			}
-			// Handle as invalid character for now (could be extended for unary not)
-			err = fmt.Errorf("%w: %s", errInvalidRune, string(r))
-			return
-		case '<':
-			// Handle <= operator
-			nextIndex := l.index + 1
-			if nextIndex < len(l.input) && l.input[nextIndex] == '=' {
-				l.index += 2
-				return Token{Type: TokenType_LessThanEqual}, nil
-			}
-			// Single < is handled here
-			l.index++
-			return Token{Type: TokenType_LessThan}, nil
-		case '>':
-			// Handle >= operator
-			nextIndex := l.index + 1
-			if nextIndex < len(l.input) && l.input[nextIndex] == '=' {
-				l.index += 2
-				return Token{Type: TokenType_GreaterThanEqual}, nil
-			}
-			// Single > is handled here
-			l.index++
-			return Token{Type: TokenType_GreaterThan}, nil
+			return Token{Type: TokenType_Period}, nil
 		case '/':
 			// Handle comments first: check if next character is also / to form a comment
 			nextIndex := l.index + 1
@@ -262,14 +550,24 @@ func (l *Lexer) GetToken() (tok Token, err error) {
 			}
 			if l.input[nextIndex] == '/' {
 				// This is a comment (//), skip both characters and the comment content
+				start := l.index
 				l.index += 2 // skip both '/'
 				l.skipComments()
+
+				pos := l.positionAt(start)
+				l.comments = append(l.comments, Comment{
+					Text:     string(l.input[start:l.index]),
+					Pos:      pos,
+					Trailing: pos.Line == l.lastLine,
+				})
 				continue
-			} else {
-				// This is a division operator, not a comment
-				l.index++
-				return Token{Type: TokenType_ForwardSlash}, nil
 			}
+			// Otherwise this is a division operator, not a comment, which is handled below
+			// along with /=
+		}
+
+		if tok, ok = l.getTokenOperator(); ok {
+			return tok, nil
 		}
 
 		tok, ok = TokenTypeCharactersMap[r]
@@ -278,12 +576,31 @@ func (l *Lexer) GetToken() (tok Token, err error) {
 			return tok, nil
 		}
 
-		// If the rune is unknown.
+		// If the rune is unknown, skip it so scanning can carry on after the error.
+		l.index++
 		err = fmt.Errorf("%w: %s", errInvalidRune, string(r))
 		return
 	}
 }
 
+// followedBy reports whether the rune after the current one is r.
+func (l *Lexer) followedBy(r rune) bool {
+	return l.index+1 < len(l.input) && l.input[l.index+1] == r
+}
+
+// getTokenOperator consumes the longest operator in TokenTypeOperators at the current
+// position, if there is one.
+func (l *Lexer) getTokenOperator() (tok Token, ok bool) {
+	for _, operator := range TokenTypeOperators {
+		end := l.index + len(operator.Text)
+		if end <= len(l.input) && string(l.input[l.index:end]) == operator.Text {
+			l.index = end
+			return operator.Token, true
+		}
+	}
+	return tok, false
+}
+
 // PeekToken returns the current token in the input string without advancing the lexer position.
 // This allows looking ahead at the next token without consuming it.
 // If there are no more tokens to process in the string, PeekToken returns an
@@ -303,40 +620,77 @@ func (l *Lexer) PeekToken() (tok Token, err error) {
 }
 
 // getTokenNumberLiteral scans and returns a number literal token from the current position.
-// It handles both integer and floating-point numbers (with decimal points).
-// If there are no more characters to process in the string, getTokenNumberLiteral returns an
-// io.EOF error.
+// It handles decimal numbers with an optional decimal point, hexadecimal numbers starting
+// with 0x and binary numbers starting with 0b, both of which may also have a fractional
+// part, as in 0x0.8. Digits may be separated by underscores, as in 0b1111_0000.
+// The token value is the literal as written.
 func (l *Lexer) getTokenNumberLiteral() (tok Token, err error) {
 	tok.Type = TokenType_NumberLiteral
-	var r rune
-	hasDecimalPoint := false
+	isDigit := unicode.IsNumber
+	prefixed := false
+
+	if l.input[l.index] == '0' && l.index+1 < len(l.input) {
+		switch l.input[l.index+1] {
+		case 'x', 'X':
+			isDigit, prefixed = isHexDigit, true
+		case 'b', 'B':
+			isDigit, prefixed = isBinaryDigit, true
+		}
+	}
+	if prefixed {
+		tok.Value = string(l.input[l.index : l.index+2])
+		l.index += 2
+	}
 
-	for {
-		r, err = l.peekRune()
+	digits := l.getDigits(isDigit)
+	tok.Value += digits
+	// A second period starts a range such as 0..9, so the first one is not a decimal point
+	if r, err := l.peekRune(); err == nil && r == '.' && !l.followedBy('.') {
+		l.index++
+		fraction := l.getDigits(isDigit)
+		tok.Value += "." + fraction
+		digits += fraction
+	}
 
-		if err == io.EOF {
-			return tok, nil
-		}
+	if prefixed && digits == "" {
+		err = fmt.Errorf("%w: %s has no digits", errInvalidNumber, tok.Value)
+		return
+	}
+	if err = checkDigitSeparators(tok.Value); err != nil {
+		return
+	}
+	return tok, nil
+}
 
-		if err != nil {
-			return tok, err
+// getDigits consumes the digits and underscore separators at the current position and
+// returns them.
+func (l *Lexer) getDigits(isDigit func(rune) bool) string {
+	start := l.index
+	for {
+		r, err := l.peekRune()
+		if err != nil || !isDigit(r) && r != '_' {
+			break
 		}
+		l.index++
+	}
+	return string(l.input[start:l.index])
+}
 
-		if unicode.IsNumber(r) {
-			l.index++
-			tok.Value += string(r)
+// checkDigitSeparators checks that every underscore in a number literal sits between two
+// digits, or between a 0x or 0b prefix and a digit.
+func checkDigitSeparators(literal string) error {
+	runes := []rune(literal)
+	for i, r := range runes {
+		if r != '_' {
 			continue
 		}
-
-		if r == '.' && !hasDecimalPoint {
-			l.index++
-			tok.Value += string(r)
-			hasDecimalPoint = true
-			continue
+		validBefore := i > 0 && runes[i-1] != '_' && runes[i-1] != '.'
+		validAfter := i+1 < len(runes) && runes[i+1] != '_' && runes[i+1] != '.'
+		if !validBefore || !validAfter {
+			return fmt.Errorf("%w: %s has a misplaced _", errInvalidNumber, literal)
 		}
-
-		return tok, nil
 	}
+	return nil
 }
 
 // getTokenLabel scans and returns a label token from the current position.
@@ -377,11 +731,17 @@ func (l *Lexer) getTokenLabel() (tok Token, err error) {
 }
 
 // getTokenStringLiteral scans and returns a string literal token from the current position.
-// It reads characters until it encounters a closing double quote.
+// It reads characters until it encounters a closing double quote, decoding escape
+// sequences into the characters they stand for:
+// - \n, \t, \r, \" and \\
+// - \xHH, the P8SCII character with the hexadecimal code HH, such as \x0c or \x87 (♥)
+// - \u{H...}, the unicode character with the hexadecimal code point H...
+// The literal as it was written is kept in Raw.
 // If the end of input is reached before finding a closing quote,
 // getTokenStringLiteral returns an errUnexpectedEOF error.
 func (l *Lexer) getTokenStringLiteral() (tok Token, err error) {
 	tok.Type = TokenType_StringLiteral
+	start := l.index - 1 // the opening quote
 	var r rune
 
 	for {
@@ -400,12 +760,92 @@ func (l *Lexer) getTokenStringLiteral() (tok Token, err error) {
 			break
 		}
 
+		if r == '\\' {
+			var escaped string
+			escaped, err = l.getEscape()
+			if err != nil {
+				return
+			}
+			tok.Value += escaped
+			continue
+		}
+
 		tok.Value += string(r)
 	}
 
+	tok.Raw = string(l.input[start:l.index])
 	return tok, nil
 }
 
+// getEscape decodes the escape sequence following a backslash in a string literal.
+func (l *Lexer) getEscape() (s string, err error) {
+	r, err := l.getRune()
+	if err == io.EOF {
+		err = errUnexpectedEOF
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	if escaped, ok := escapes[r]; ok {
+		return string(escaped), nil
+	}
+
+	switch r {
+	case 'x':
+		digits := l.getHexDigits(2)
+		if len(digits) != 2 {
+			err = fmt.Errorf("%w: \\x must be followed by two hexadecimal digits", errInvalidEscape)
+			return
+		}
+		code, _ := strconv.ParseUint(digits, 16, 8)
+		return p8scii.String(byte(code)), nil
+	case 'u':
+		if next, _ := l.getRune(); next != '{' {
+			err = fmt.Errorf("%w: \\u must be followed by a code point in braces", errInvalidEscape)
+			return
+		}
+		digits := l.getHexDigits(6)
+		if next, _ := l.getRune(); next != '}' || len(digits) == 0 {
+			err = fmt.Errorf("%w: \\u must be followed by a code point in braces", errInvalidEscape)
+			return
+		}
+		code, _ := strconv.ParseUint(digits, 16, 32)
+		if !utf8.ValidRune(rune(code)) {
+			err = fmt.Errorf("%w: \\u{%s} is not a valid character", errInvalidEscape, digits)
+			return
+		}
+		return string(rune(code)), nil
+	}
+
+	err = fmt.Errorf("%w: \\%c", errInvalidEscape, r)
+	return
+}
+
+// getHexDigits consumes up to max hexadecimal digits and returns them.
+func (l *Lexer) getHexDigits(max int) string {
+	start := l.index
+	for l.index-start < max {
+		r, err := l.peekRune()
+		if err != nil || !isHexDigit(r) {
+			break
+		}
+		l.index++
+	}
+	return string(l.input[start:l.index])
+}
+
+// isHexDigit returns whether r is a hexadecimal digit.
+func isHexDigit(r rune) bool {
+	return r >= '0' && r <= '9' || r >= 'a' && r <= 'f' || r >= 'A' && r <= 'F'
+}
+
+// isBinaryDigit returns whether r is a binary digit.
+func isBinaryDigit(r rune) bool {
+	return r == '0' || r == '1'
+}
+
 func (l *Lexer) skipComments() error {
 	for {
 		r, err := l.peekRune()
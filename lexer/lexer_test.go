@@ -2,6 +2,7 @@ package lexer
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"testing"
 
@@ -140,14 +141,37 @@ func TestGetToken(t *testing.T) {
 		"float_starting_with_zero":  {"0.5", []Token{{Type: TokenType_NumberLiteral, Value: "0.5"}}, false},
 		"multiple_decimals":         {"3.14.15", []Token{{Type: TokenType_NumberLiteral, Value: "3.14"}, {Type: TokenType_Period}, {Type: TokenType_NumberLiteral, Value: "15"}}, false}, // "3.14" is parsed as a number, then "." as a period token, then "15" as a number
 		"decimal_starting_with_dot": {".5", []Token{{Type: TokenType_Period}, {Type: TokenType_NumberLiteral, Value: "5"}}, false},      // "." is parsed as a period token, then "5" as a number - this will be handled as an error at the parser level
+		"hex_integer":               {"0x5f00", []Token{{Type: TokenType_NumberLiteral, Value: "0x5f00"}}, false},
+		"hex_upper_case":            {"0X5F", []Token{{Type: TokenType_NumberLiteral, Value: "0X5F"}}, false},
+		"hex_fraction":              {"0x0.8", []Token{{Type: TokenType_NumberLiteral, Value: "0x0.8"}}, false},
+		"hex_fraction_only":         {"0x.8", []Token{{Type: TokenType_NumberLiteral, Value: "0x.8"}}, false},
+		"binary":                    {"0b0101", []Token{{Type: TokenType_NumberLiteral, Value: "0b0101"}}, false},
+		"binary_fraction":           {"0b1.1", []Token{{Type: TokenType_NumberLiteral, Value: "0b1.1"}}, false},
+		"digit_separators":          {"1_000_000", []Token{{Type: TokenType_NumberLiteral, Value: "1_000_000"}}, false},
+		"separator_after_prefix":    {"0b_1111_0000", []Token{{Type: TokenType_NumberLiteral, Value: "0b_1111_0000"}}, false},
+		"hex_then_label":            {"0x1fg", []Token{{Type: TokenType_NumberLiteral, Value: "0x1f"}, {Type: TokenType_Label, Value: "g"}}, false},
+		"hex_without_digits":        {"0x", nil, true},
+		"binary_without_digits":     {"0b2", nil, true},
+		"trailing_separator":        {"100_", nil, true},
+		"double_separator":          {"1__0", nil, true},
+		"separator_before_point":    {"1_.5", nil, true},
 
 		// Test string literals
-		"empty_string":              {`""`, []Token{{Type: TokenType_StringLiteral, Value: ""}}, false},
-		"simple_string":             {`"hello"`, []Token{{Type: TokenType_StringLiteral, Value: "hello"}}, false},
-		"string_with_spaces":        {`"hello world"`, []Token{{Type: TokenType_StringLiteral, Value: "hello world"}}, false},
-		"string_with_special_chars": {`"hello!@#$%^&*()"`, []Token{{Type: TokenType_StringLiteral, Value: "hello!@#$%^&*()"}}, false},
-		// Note: The following test will fail since the lexer doesn't handle escape sequences properly
-		// "string_with_quote_inside": {`"hello\"world"`, []Token{{Type: TokenType_StringLiteral, Value: "hello\"world"}}, true}, // This would cause an error
+		"empty_string":              {`""`, []Token{{Type: TokenType_StringLiteral, Value: "", Raw: `""`}}, false},
+		"simple_string":             {`"hello"`, []Token{{Type: TokenType_StringLiteral, Value: "hello", Raw: `"hello"`}}, false},
+		"string_with_spaces":        {`"hello world"`, []Token{{Type: TokenType_StringLiteral, Value: "hello world", Raw: `"hello world"`}}, false},
+		"string_with_special_chars": {`"hello!@#$%^&*()"`, []Token{{Type: TokenType_StringLiteral, Value: "hello!@#$%^&*()", Raw: `"hello!@#$%^&*()"`}}, false},
+		"string_with_quote_inside":  {`"hello\"world"`, []Token{{Type: TokenType_StringLiteral, Value: "hello\"world", Raw: `"hello\"world"`}}, false},
+		"string_with_escapes":       {`"a\nb\tc\rd\\e"`, []Token{{Type: TokenType_StringLiteral, Value: "a\nb\tc\rd\\e", Raw: `"a\nb\tc\rd\\e"`}}, false},
+		"string_with_raw_newline":   {"\"a\nb\"", []Token{{Type: TokenType_StringLiteral, Value: "a\nb", Raw: "\"a\nb\""}}, false},
+		"string_with_hex_escape":    {`"\x41\x0c\x87"`, []Token{{Type: TokenType_StringLiteral, Value: "Aᶜ♥", Raw: `"\x41\x0c\x87"`}}, false},
+		"string_with_unicode":       {`"\u{48}\u{2605}\u{1F431}"`, []Token{{Type: TokenType_StringLiteral, Value: "H★🐱", Raw: `"\u{48}\u{2605}\u{1F431}"`}}, false},
+		"string_unknown_escape":     {`"\q"`, nil, true},
+		"string_short_hex_escape":   {`"\x4"`, nil, true},
+		"string_unicode_no_braces":  {`"\u48"`, nil, true},
+		"string_unicode_empty":      {`"\u{}"`, nil, true},
+		"string_unicode_too_large":  {`"\u{110000}"`, nil, true},
+		"string_escape_at_eof":      {`"abc\`, nil, true},
 
 		// Test labels
 		"simple_label":          {"hello", []Token{{Type: TokenType_Label, Value: "hello"}}, false},
@@ -163,6 +187,53 @@ func TestGetToken(t *testing.T) {
 		"bool_in_sentence":      {"true false", []Token{{Type: TokenType_BooleanLiteral, Value: "true"}, {Type: TokenType_BooleanLiteral, Value: "false"}}, false},
 		"potential_boolean_not": {"not", []Token{{Type: TokenType_Label, Value: "not"}}, false}, // not is not a boolean literal
 
+		// Test unary operators
+		"bang":           {"!ready", []Token{{Type: TokenType_Bang}, {Type: TokenType_Label, Value: "ready"}}, false},
+		"bang_equal":     {"!=", []Token{{Type: TokenType_BangEqual}}, false},
+		"double_bang":    {"!!", []Token{{Type: TokenType_Bang}, {Type: TokenType_Bang}}, false},
+		"tilde":          {"~0x0f", []Token{{Type: TokenType_Tilde}, {Type: TokenType_NumberLiteral, Value: "0x0f"}}, false},
+		"negated_number": {"-5", []Token{{Type: TokenType_Minus}, {Type: TokenType_NumberLiteral, Value: "5"}}, false},
+
+		// Test logical operators
+		"and":            {"a && b", []Token{{Type: TokenType_Label, Value: "a"}, {Type: TokenType_AmpersandAmpersand}, {Type: TokenType_Label, Value: "b"}}, false},
+		"or":             {"a||b", []Token{{Type: TokenType_Label, Value: "a"}, {Type: TokenType_PipePipe}, {Type: TokenType_Label, Value: "b"}}, false},
+		"mixed_and_or":   {"&|", []Token{{Type: TokenType_Ampersand}, {Type: TokenType_Pipe}}, false},
+
+		// Test arithmetic and bitwise operators
+		"percent":         {"a%b", []Token{{Type: TokenType_Label, Value: "a"}, {Type: TokenType_Percent}, {Type: TokenType_Label, Value: "b"}}, false},
+		"caret":           {"^", []Token{{Type: TokenType_Caret}}, false},
+		"caret_caret":     {"^^^", []Token{{Type: TokenType_CaretCaret}, {Type: TokenType_Caret}}, false},
+		"backslash":       {`\`, []Token{{Type: TokenType_Backslash}}, false},
+		"ampersand":       {"a & b", []Token{{Type: TokenType_Label, Value: "a"}, {Type: TokenType_Ampersand}, {Type: TokenType_Label, Value: "b"}}, false},
+		"pipe":            {"a | b", []Token{{Type: TokenType_Label, Value: "a"}, {Type: TokenType_Pipe}, {Type: TokenType_Label, Value: "b"}}, false},
+		"shift_left":      {"<<", []Token{{Type: TokenType_LessThanLessThan}}, false},
+		"shift_right":     {">>", []Token{{Type: TokenType_GreaterThanGreaterThan}}, false},
+		"shift_logical":   {">>>", []Token{{Type: TokenType_GreaterThanGreaterThanGreaterThan}}, false},
+		"rotate_left":     {"<<>", []Token{{Type: TokenType_LessThanLessThanGreaterThan}}, false},
+		"rotate_right":    {">><", []Token{{Type: TokenType_GreaterThanGreaterThanLessThan}}, false},
+		"shift_then_less": {"<<<", []Token{{Type: TokenType_LessThanLessThan}, {Type: TokenType_LessThan}}, false},
+		"comparisons":     {"< <= > >= == !=", []Token{{Type: TokenType_LessThan}, {Type: TokenType_LessThanEqual}, {Type: TokenType_GreaterThan}, {Type: TokenType_GreaterThanEqual}, {Type: TokenType_EqualEqual}, {Type: TokenType_BangEqual}}, false},
+
+		// Test compound assignment operators
+		"plus_equal":        {"x += 1", []Token{{Type: TokenType_Label, Value: "x"}, {Type: TokenType_PlusEqual}, {Type: TokenType_NumberLiteral, Value: "1"}}, false},
+		"minus_equal":       {"-=", []Token{{Type: TokenType_MinusEqual}}, false},
+		"slash_equal":       {"/=", []Token{{Type: TokenType_ForwardSlashEqual}}, false},
+		"slash_at_eof":      {"/", []Token{{Type: TokenType_ForwardSlash}}, false},
+		"backslash_equal":   {`\=`, []Token{{Type: TokenType_BackslashEqual}}, false},
+		"caret_caret_equal": {"^^=", []Token{{Type: TokenType_CaretCaretEqual}}, false},
+		"shift_equal":       {"<<= >>= >>>=", []Token{{Type: TokenType_LessThanLessThanEqual}, {Type: TokenType_GreaterThanGreaterThanEqual}, {Type: TokenType_GreaterThanGreaterThanGreaterThanEqual}}, false},
+		"rotate_equal":      {"<<>= >><=", []Token{{Type: TokenType_LessThanLessThanGreaterThanEqual}, {Type: TokenType_GreaterThanGreaterThanLessThanEqual}}, false},
+		"equal_then_minus":  {"=-1", []Token{{Type: TokenType_Equal}, {Type: TokenType_Minus}, {Type: TokenType_NumberLiteral, Value: "1"}}, false},
+
+		// Test ranges
+		"range":          {"0..9", []Token{{Type: TokenType_NumberLiteral, Value: "0"}, {Type: TokenType_PeriodPeriod}, {Type: TokenType_NumberLiteral, Value: "9"}}, false},
+		"range_labels":   {"a..b", []Token{{Type: TokenType_Label, Value: "a"}, {Type: TokenType_PeriodPeriod}, {Type: TokenType_Label, Value: "b"}}, false},
+		"range_decimal":  {"0.5..1", []Token{{Type: TokenType_NumberLiteral, Value: "0.5"}, {Type: TokenType_PeriodPeriod}, {Type: TokenType_NumberLiteral, Value: "1"}}, false},
+		"range_hex":      {"0x0..0xf", []Token{{Type: TokenType_NumberLiteral, Value: "0x0"}, {Type: TokenType_PeriodPeriod}, {Type: TokenType_NumberLiteral, Value: "0xf"}}, false},
+
+		// Test conditional expressions
+		"conditional": {"a ? 1 : 2", []Token{{Type: TokenType_Label, Value: "a"}, {Type: TokenType_Question}, {Type: TokenType_NumberLiteral, Value: "1"}, {Type: TokenType_Colon}, {Type: TokenType_NumberLiteral, Value: "2"}}, false},
+
 		// Test multiple tokens
 		"mixed_tokens": {"hello 42 world", []Token{
 			{Type: TokenType_Label, Value: "hello"},
@@ -202,7 +273,7 @@ func TestGetToken(t *testing.T) {
 					assert.NoError(t, err, "Unexpected error when tokenizing %q", tt.input)
 					break
 				}
-				tokens = append(tokens, token)
+				tokens = append(tokens, withoutPos(token))
 			}
 
 			if tt.hasError {
@@ -214,17 +285,58 @@ func TestGetToken(t *testing.T) {
 	}
 }
 
+// withoutPos clears a token's position so tests can compare just its type and value.
+func withoutPos(tok Token) Token {
+	tok.Pos = Position{}
+	return tok
+}
+
+func TestTokenPositions(t *testing.T) {
+	lexer := NewFile("game.pixie", "x num = 1\n// comment\n  print(\"héllo\", x)")
+
+	expected := []Position{
+		{File: "game.pixie", Line: 1, Column: 1, Offset: 0},
+		{File: "game.pixie", Line: 1, Column: 3, Offset: 2},
+		{File: "game.pixie", Line: 1, Column: 7, Offset: 6},
+		{File: "game.pixie", Line: 1, Column: 9, Offset: 8},
+		{File: "game.pixie", Line: 3, Column: 3, Offset: 23},
+		{File: "game.pixie", Line: 3, Column: 8, Offset: 28},
+		{File: "game.pixie", Line: 3, Column: 9, Offset: 29},
+		{File: "game.pixie", Line: 3, Column: 16, Offset: 37},
+		{File: "game.pixie", Line: 3, Column: 18, Offset: 39},
+		{File: "game.pixie", Line: 3, Column: 19, Offset: 40},
+	}
+
+	for i, pos := range expected {
+		if i == 4 {
+			// Peeking reports the same position as getting
+			peekToken, err := lexer.PeekToken()
+			require.NoError(t, err)
+			require.Equal(t, pos, peekToken.Pos)
+		}
+
+		token, err := lexer.GetToken()
+		require.NoError(t, err)
+		require.Equal(t, pos, token.Pos, "token %d (%s)", i, token)
+	}
+
+	_, err := lexer.GetToken()
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, "game.pixie:3:18", expected[8].String())
+	require.Equal(t, "1:2", Position{Line: 1, Column: 2}.String())
+}
+
 func TestPeekToken(t *testing.T) {
 	t.Run("peek_same_as_get_single_token", func(t *testing.T) {
 		lexer := New("hello")
 
 		peekToken, err := lexer.PeekToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_Label, Value: "hello"}, peekToken)
+		assert.Equal(t, Token{Type: TokenType_Label, Value: "hello"}, withoutPos(peekToken))
 
 		getToken, err := lexer.GetToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_Label, Value: "hello"}, getToken)
+		assert.Equal(t, Token{Type: TokenType_Label, Value: "hello"}, withoutPos(getToken))
 	})
 
 	t.Run("peek_multiple_calls_same_result", func(t *testing.T) {
@@ -232,11 +344,11 @@ func TestPeekToken(t *testing.T) {
 
 		peekToken1, err := lexer.PeekToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_Label, Value: "hello"}, peekToken1)
+		assert.Equal(t, Token{Type: TokenType_Label, Value: "hello"}, withoutPos(peekToken1))
 
 		peekToken2, err := lexer.PeekToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_Label, Value: "hello"}, peekToken2)
+		assert.Equal(t, Token{Type: TokenType_Label, Value: "hello"}, withoutPos(peekToken2))
 		assert.Equal(t, peekToken1, peekToken2)
 	})
 
@@ -246,22 +358,22 @@ func TestPeekToken(t *testing.T) {
 		// Peek at first token
 		peekToken, err := lexer.PeekToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_Label, Value: "hello"}, peekToken)
+		assert.Equal(t, Token{Type: TokenType_Label, Value: "hello"}, withoutPos(peekToken))
 
 		// Get first token
 		getToken, err := lexer.GetToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_Label, Value: "hello"}, getToken)
+		assert.Equal(t, Token{Type: TokenType_Label, Value: "hello"}, withoutPos(getToken))
 
 		// Peek at second token
 		peekToken2, err := lexer.PeekToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_Label, Value: "world"}, peekToken2)
+		assert.Equal(t, Token{Type: TokenType_Label, Value: "world"}, withoutPos(peekToken2))
 
 		// Get second token
 		getToken2, err := lexer.GetToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_Label, Value: "world"}, getToken2)
+		assert.Equal(t, Token{Type: TokenType_Label, Value: "world"}, withoutPos(getToken2))
 	})
 
 	t.Run("peek_eof", func(t *testing.T) {
@@ -289,14 +401,14 @@ func TestErrorHandling(t *testing.T) {
 		lexer := New(`"hello`)
 
 		_, err := lexer.GetToken()
-		assert.Equal(t, errUnexpectedEOF, err)
+		assert.ErrorIs(t, err, errUnexpectedEOF)
 	})
 
 	t.Run("unterminated_string_literal_with_content", func(t *testing.T) {
 		lexer := New(`"hello world`)
 
 		_, err := lexer.GetToken()
-		assert.Equal(t, errUnexpectedEOF, err)
+		assert.ErrorIs(t, err, errUnexpectedEOF)
 	})
 
 	t.Run("invalid_rune_error", func(t *testing.T) {
@@ -333,17 +445,68 @@ func TestErrorHandling(t *testing.T) {
 		lexer := New(`"hello $`)
 
 		_, err := lexer.GetToken()
-		assert.Equal(t, errUnexpectedEOF, err)
+		assert.ErrorIs(t, err, errUnexpectedEOF)
+	})
+
+	t.Run("error_position", func(t *testing.T) {
+		lexer := NewFile("game.pixie", "x num = 1\nprint(x $)")
+
+		var err error
+		for err == nil {
+			_, err = lexer.GetToken()
+		}
+		assert.ErrorIs(t, err, errInvalidRune)
+		assert.Equal(t, "game.pixie:2:9: invalid rune: $", Locate(err, Position{}).Error())
 	})
 }
 
+func TestLocate(t *testing.T) {
+	pos := Position{File: "game.pixie", Line: 2, Column: 5, Offset: 14}
+	fallback := Position{File: "game.pixie", Line: 1, Column: 1}
+	errBase := errors.New("unexpected token")
+
+	err := fmt.Errorf("failed to parse statement: %w", Errorf(pos, "%w %q", errBase, "Comma"))
+	located := Locate(err, fallback)
+	assert.Equal(t, `game.pixie:2:5: failed to parse statement: unexpected token "Comma"`, located.Error())
+	assert.ErrorIs(t, located, errBase)
+
+	var lexErr *Error
+	require.ErrorAs(t, located, &lexErr)
+	assert.Equal(t, pos, lexErr.Pos)
+
+	assert.Equal(t, "game.pixie:1:1: failed", Locate(errors.New("failed"), fallback).Error())
+	assert.Same(t, located, Locate(located, fallback))
+
+	errSentinel := errors.New("invalid type assign")
+	joined := Locate(fmt.Errorf("%w: %w", errSentinel, Errorf(pos, "%w %q", errBase, "Comma")), fallback)
+	assert.Equal(t, `game.pixie:2:5: invalid type assign: unexpected token "Comma"`, joined.Error())
+	assert.ErrorIs(t, joined, errSentinel)
+	assert.NoError(t, Locate(nil, fallback))
+}
+
+func TestPrimary(t *testing.T) {
+	pos := Position{File: "game.pixie", Line: 2, Column: 5, Offset: 14}
+	fallback := Position{File: "game.pixie", Line: 1, Column: 1}
+	errBase := errors.New("unexpected token")
+
+	err := fmt.Errorf("failed to parse statement: %w", Errorf(pos, "%w %q", errBase, "Comma"))
+	primary := Primary(err, fallback)
+	assert.Equal(t, `game.pixie:2:5: unexpected token "Comma"`, primary.Error())
+	assert.ErrorIs(t, primary, errBase)
+
+	assert.Equal(t, "game.pixie:1:1: failed", Primary(errors.New("failed"), fallback).Error())
+
+	located := Locate(err, fallback)
+	assert.Same(t, located, Primary(fmt.Errorf("failed to import: %w", located), fallback))
+}
+
 func TestEdgeCasesAndUnicode(t *testing.T) {
 	t.Run("unicode_in_labels", func(t *testing.T) {
 		lexer := New("héllo")
 
 		token, err := lexer.GetToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_Label, Value: "héllo"}, token)
+		assert.Equal(t, Token{Type: TokenType_Label, Value: "héllo"}, withoutPos(token))
 	})
 
 	t.Run("unicode_numbers", func(t *testing.T) {
@@ -352,7 +515,7 @@ func TestEdgeCasesAndUnicode(t *testing.T) {
 
 		token, err := lexer.GetToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_NumberLiteral, Value: "٠١٢٣٤٥٦٧٨٩"}, token)
+		assert.Equal(t, Token{Type: TokenType_NumberLiteral, Value: "٠١٢٣٤٥٦٧٨٩"}, withoutPos(token))
 	})
 
 	t.Run("unicode_in_strings", func(t *testing.T) {
@@ -360,7 +523,7 @@ func TestEdgeCasesAndUnicode(t *testing.T) {
 
 		token, err := lexer.GetToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_StringLiteral, Value: "héllo wörld"}, token)
+		assert.Equal(t, Token{Type: TokenType_StringLiteral, Value: "héllo wörld", Raw: `"héllo wörld"`}, withoutPos(token))
 	})
 
 	t.Run("emoji_in_strings", func(t *testing.T) {
@@ -368,7 +531,7 @@ func TestEdgeCasesAndUnicode(t *testing.T) {
 
 		token, err := lexer.GetToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_StringLiteral, Value: "hello 🌍 world"}, token)
+		assert.Equal(t, Token{Type: TokenType_StringLiteral, Value: "hello 🌍 world", Raw: `"hello 🌍 world"`}, withoutPos(token))
 	})
 
 	t.Run("tabs_and_newlines_as_whitespace", func(t *testing.T) {
@@ -376,11 +539,11 @@ func TestEdgeCasesAndUnicode(t *testing.T) {
 
 		token1, err := lexer.GetToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_Label, Value: "hello"}, token1)
+		assert.Equal(t, Token{Type: TokenType_Label, Value: "hello"}, withoutPos(token1))
 
 		token2, err := lexer.GetToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_Label, Value: "world"}, token2)
+		assert.Equal(t, Token{Type: TokenType_Label, Value: "world"}, withoutPos(token2))
 	})
 
 	t.Run("long_number_literal", func(t *testing.T) {
@@ -389,7 +552,7 @@ func TestEdgeCasesAndUnicode(t *testing.T) {
 
 		token, err := lexer.GetToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_NumberLiteral, Value: longNumber}, token)
+		assert.Equal(t, Token{Type: TokenType_NumberLiteral, Value: longNumber}, withoutPos(token))
 	})
 
 	t.Run("long_label", func(t *testing.T) {
@@ -398,7 +561,7 @@ func TestEdgeCasesAndUnicode(t *testing.T) {
 
 		token, err := lexer.GetToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_Label, Value: longLabel}, token)
+		assert.Equal(t, Token{Type: TokenType_Label, Value: longLabel}, withoutPos(token))
 	})
 
 	t.Run("long_string_literal", func(t *testing.T) {
@@ -408,7 +571,7 @@ func TestEdgeCasesAndUnicode(t *testing.T) {
 		token, err := lexer.GetToken()
 		assert.NoError(t, err)
 		expected := "This is a very long string with many characters that should be handled properly by the lexer"
-		assert.Equal(t, Token{Type: TokenType_StringLiteral, Value: expected}, token)
+		assert.Equal(t, Token{Type: TokenType_StringLiteral, Value: expected, Raw: longString}, withoutPos(token))
 	})
 
 	t.Run("multiple_whitespace_types", func(t *testing.T) {
@@ -416,11 +579,11 @@ func TestEdgeCasesAndUnicode(t *testing.T) {
 
 		token1, err := lexer.GetToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_Label, Value: "hello"}, token1)
+		assert.Equal(t, Token{Type: TokenType_Label, Value: "hello"}, withoutPos(token1))
 
 		token2, err := lexer.GetToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_Label, Value: "world"}, token2)
+		assert.Equal(t, Token{Type: TokenType_Label, Value: "world"}, withoutPos(token2))
 	})
 
 	t.Run("zero_and_negative_concept", func(t *testing.T) {
@@ -428,15 +591,15 @@ func TestEdgeCasesAndUnicode(t *testing.T) {
 
 		token1, err := lexer.GetToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_NumberLiteral, Value: "0"}, token1)
+		assert.Equal(t, Token{Type: TokenType_NumberLiteral, Value: "0"}, withoutPos(token1))
 
 		token2, err := lexer.GetToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_Minus}, token2)
+		assert.Equal(t, Token{Type: TokenType_Minus}, withoutPos(token2))
 
 		token3, err := lexer.GetToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_NumberLiteral, Value: "5"}, token3)
+		assert.Equal(t, Token{Type: TokenType_NumberLiteral, Value: "5"}, withoutPos(token3))
 	})
 
 	t.Run("consecutive_strings", func(t *testing.T) {
@@ -444,11 +607,11 @@ func TestEdgeCasesAndUnicode(t *testing.T) {
 
 		token1, err := lexer.GetToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_StringLiteral, Value: "first"}, token1)
+		assert.Equal(t, Token{Type: TokenType_StringLiteral, Value: "first", Raw: `"first"`}, withoutPos(token1))
 
 		token2, err := lexer.GetToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_StringLiteral, Value: "second"}, token2)
+		assert.Equal(t, Token{Type: TokenType_StringLiteral, Value: "second", Raw: `"second"`}, withoutPos(token2))
 	})
 
 	t.Run("mixed_unicode_identifiers", func(t *testing.T) {
@@ -456,15 +619,15 @@ func TestEdgeCasesAndUnicode(t *testing.T) {
 
 		token1, err := lexer.GetToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_Label, Value: "variable_123"}, token1)
+		assert.Equal(t, Token{Type: TokenType_Label, Value: "variable_123"}, withoutPos(token1))
 
 		token2, err := lexer.GetToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_Label, Value: "café"}, token2)
+		assert.Equal(t, Token{Type: TokenType_Label, Value: "café"}, withoutPos(token2))
 
 		token3, err := lexer.GetToken()
 		assert.NoError(t, err)
-		assert.Equal(t, Token{Type: TokenType_Label, Value: "résumé"}, token3)
+		assert.Equal(t, Token{Type: TokenType_Label, Value: "résumé"}, withoutPos(token3))
 	})
 }
 
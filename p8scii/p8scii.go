@@ -0,0 +1,115 @@
+// Package p8scii converts between PICO-8's P8SCII character set and the unicode text
+// PICO-8 writes it as in .p8 files.
+package p8scii
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const variationSelector = '\uFE0F' // variationSelector follows the emoji glyphs in .p8 text files
+
+var (
+	// p8sciiChars maps every P8SCII byte to the text PICO-8 uses for it in .p8 files.
+	// Control codes 1-15 and the glyphs from 16 upwards are written as unicode characters,
+	// while the printable ASCII range maps to itself.
+	p8sciiChars = buildP8SCIIChars()
+
+	// p8sciiBytes is the inverse of p8sciiChars.
+	p8sciiBytes = buildP8SCIIBytes()
+)
+
+var (
+	ErrNotP8SCII = errors.New("character has no P8SCII encoding") // ErrNotP8SCII is returned when text cannot be stored in a cart
+)
+
+func buildP8SCIIChars() (chars [256]string) {
+	var table []string
+	table = append(table, "\x00")
+	table = append(table, strings.Split("¹²³⁴⁵⁶⁷⁸", "")...)
+	table = append(table, "\t", "\n", "ᵇ", "ᶜ", "\r", "ᵉ", "ᶠ")
+	table = append(table, strings.Split("▮■□⁙⁘‖◀▶「」¥•、。゛゜", "")...)
+	for b := 0x20; b < 0x7f; b++ {
+		table = append(table, string(rune(b)))
+	}
+	table = append(table, "○")
+	table = append(table,
+		"█", "▒", "🐱", "⬇️", "░", "✽", "●", "♥", "☉", "웃", "⌂", "⬅️", "😐", "♪", "🅾️", "◆",
+		"…", "➡️", "★", "⧗", "⬆️", "ˇ", "∧", "❎", "▤", "▥",
+	)
+	table = append(table, strings.Split("あいうえおかきくけこさしすせそたちつてとなにぬねのはひふへほまみむめもやゆよらりるれろわをんっゃゅょ", "")...)
+	table = append(table, strings.Split("アイウエオカキクケコサシスセソタチツテトナニヌネノハヒフヘホマミムメモヤユヨラリルレロワヲンッャュョ", "")...)
+	table = append(table, "◜", "◝")
+
+	if len(table) != len(chars) {
+		panic(fmt.Sprintf("p8scii: P8SCII table has %d entries", len(table)))
+	}
+	copy(chars[:], table)
+	return chars
+}
+
+func buildP8SCIIBytes() map[string]byte {
+	bytes := make(map[string]byte, len(p8sciiChars))
+	for b, s := range p8sciiChars {
+		bytes[s] = byte(b)
+	}
+	return bytes
+}
+
+// String returns the .p8 text form of a single P8SCII character.
+func String(b byte) string {
+	return p8sciiChars[b]
+}
+
+// Byte returns the P8SCII character for the text at the start of s and the number
+// of bytes of s it used. Glyphs written with or without a trailing emoji variation
+// selector are both accepted.
+func Byte(s string) (b byte, size int, err error) {
+	r, size := utf8.DecodeRuneInString(s)
+	if size == 0 {
+		err = fmt.Errorf("%w: empty string", ErrNotP8SCII)
+		return
+	}
+
+	rest := s[size:]
+	if strings.HasPrefix(rest, string(variationSelector)) {
+		if b, ok := p8sciiBytes[string(r)+string(variationSelector)]; ok {
+			return b, size + len(string(variationSelector)), nil
+		}
+	}
+
+	if b, ok := p8sciiBytes[string(r)]; ok {
+		return b, size, nil
+	}
+	if b, ok := p8sciiBytes[string(r)+string(variationSelector)]; ok {
+		return b, size, nil
+	}
+
+	err = fmt.Errorf("%w: %q", ErrNotP8SCII, r)
+	return
+}
+
+// Encode converts .p8 text into the P8SCII bytes PICO-8 stores in memory.
+func Encode(text string) (out []byte, err error) {
+	out = make([]byte, 0, len(text))
+	for len(text) > 0 {
+		b, size, err := Byte(text)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+		text = text[size:]
+	}
+	return out, nil
+}
+
+// Decode converts P8SCII bytes into .p8 text.
+func Decode(data []byte) string {
+	var sb strings.Builder
+	for _, b := range data {
+		sb.WriteString(p8sciiChars[b])
+	}
+	return sb.String()
+}
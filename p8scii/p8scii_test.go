@@ -0,0 +1,25 @@
+package p8scii
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_P8SCII(t *testing.T) {
+	text := "print(\"⬅️➡️ 🅾️❎ ♥ あア ◝\")\n"
+	data, err := Encode(text)
+	require.NoError(t, err)
+	require.Equal(t, []byte("print(\""), data[:7])
+	require.Equal(t, byte(139), data[7])
+	require.Equal(t, byte(145), data[8])
+	require.Equal(t, text, Decode(data))
+
+	// Glyphs without their variation selector are accepted too
+	data, err = Encode("⬅")
+	require.NoError(t, err)
+	require.Equal(t, []byte{139}, data)
+
+	_, err = Encode("€")
+	require.ErrorIs(t, err, ErrNotP8SCII)
+}
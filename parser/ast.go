@@ -1,6 +1,9 @@
 package parser
 
-import "pixie/shared"
+import (
+	"pixie/lexer"
+	"pixie/shared"
+)
 
 const (
 	NodeType_Undefined = iota
@@ -9,6 +12,16 @@ const (
 	NodeType_StmtVarDeclare
 	NodeType_StmtVarAssign
 	NodeType_StmtObjDefine
+	NodeType_StmtImport
+	NodeType_StmtIf
+	NodeType_StmtWhile
+	NodeType_StmtFor
+	NodeType_StmtForEach
+	NodeType_StmtFunction
+	NodeType_StmtReturn
+	NodeType_StmtBreak
+	NodeType_StmtContinue
+	NodeType_StmtExpr
 	NodeType_ExprBlock
 	NodeType_ExprNumber
 	NodeType_ExprString
@@ -19,10 +32,15 @@ const (
 	NodeType_ExprIndex
 	NodeType_ExprPropertyAccess
 	NodeType_ExprBinary
+	NodeType_ExprUnary
+	NodeType_ExprCall
+	NodeType_ExprMemberCall
+	NodeType_ExprConditional
 )
 
 type Node interface {
 	Type() int
+	Position() lexer.Position
 }
 
 type Stmt interface {
@@ -41,6 +59,16 @@ func (StmtCallFunction) Type() int { return NodeType_StmtCallFunction }
 func (StmtVarDeclare) Type() int   { return NodeType_StmtVarDeclare }
 func (StmtVarAssign) Type() int    { return NodeType_StmtVarAssign }
 func (StmtObjDefine) Type() int    { return NodeType_StmtObjDefine }
+func (StmtImport) Type() int       { return NodeType_StmtImport }
+func (StmtIf) Type() int           { return NodeType_StmtIf }
+func (StmtWhile) Type() int        { return NodeType_StmtWhile }
+func (StmtFor) Type() int          { return NodeType_StmtFor }
+func (StmtForEach) Type() int      { return NodeType_StmtForEach }
+func (StmtFunction) Type() int     { return NodeType_StmtFunction }
+func (StmtReturn) Type() int       { return NodeType_StmtReturn }
+func (StmtBreak) Type() int        { return NodeType_StmtBreak }
+func (StmtContinue) Type() int     { return NodeType_StmtContinue }
+func (StmtExpr) Type() int         { return NodeType_StmtExpr }
 func (ExprBlock) Type() int        { return NodeType_ExprBlock }
 func (ExprNumber) Type() int       { return NodeType_ExprNumber }
 func (ExprString) Type() int       { return NodeType_ExprString }
@@ -51,6 +79,41 @@ func (ExprVariable) Type() int     { return NodeType_ExprVariable }
 func (ExprIndex) Type() int        { return NodeType_ExprIndex }
 func (ExprPropertyAccess) Type() int { return NodeType_ExprPropertyAccess }
 func (ExprBinary) Type() int      { return NodeType_ExprBinary }
+func (ExprUnary) Type() int       { return NodeType_ExprUnary }
+func (ExprCall) Type() int        { return NodeType_ExprCall }
+func (ExprMemberCall) Type() int  { return NodeType_ExprMemberCall }
+func (ExprConditional) Type() int { return NodeType_ExprConditional }
+
+// Position returns where the node starts in the source
+func (n StmtBlock) Position() lexer.Position          { return n.Pos }
+func (n StmtCallFunction) Position() lexer.Position   { return n.Pos }
+func (n StmtVarDeclare) Position() lexer.Position     { return n.Pos }
+func (n StmtVarAssign) Position() lexer.Position      { return n.Pos }
+func (n StmtObjDefine) Position() lexer.Position      { return n.Pos }
+func (n StmtImport) Position() lexer.Position         { return n.Pos }
+func (n StmtIf) Position() lexer.Position             { return n.Pos }
+func (n StmtWhile) Position() lexer.Position          { return n.Pos }
+func (n StmtFor) Position() lexer.Position            { return n.Pos }
+func (n StmtForEach) Position() lexer.Position        { return n.Pos }
+func (n StmtFunction) Position() lexer.Position       { return n.Pos }
+func (n StmtReturn) Position() lexer.Position         { return n.Pos }
+func (n StmtBreak) Position() lexer.Position          { return n.Pos }
+func (n StmtContinue) Position() lexer.Position       { return n.Pos }
+func (n StmtExpr) Position() lexer.Position           { return n.Pos }
+func (n ExprBlock) Position() lexer.Position          { return n.Pos }
+func (n ExprNumber) Position() lexer.Position         { return n.Pos }
+func (n ExprString) Position() lexer.Position         { return n.Pos }
+func (n ExprBoolean) Position() lexer.Position        { return n.Pos }
+func (n ExprList) Position() lexer.Position           { return n.Pos }
+func (n ExprTable) Position() lexer.Position          { return n.Pos }
+func (n ExprVariable) Position() lexer.Position       { return n.Pos }
+func (n ExprIndex) Position() lexer.Position          { return n.Left.Position() }
+func (n ExprPropertyAccess) Position() lexer.Position { return n.Left.Position() }
+func (n ExprBinary) Position() lexer.Position         { return n.Left.Position() }
+func (n ExprUnary) Position() lexer.Position          { return n.Pos }
+func (n ExprCall) Position() lexer.Position           { return n.Pos }
+func (n ExprMemberCall) Position() lexer.Position     { return n.Callee.Position() }
+func (n ExprConditional) Position() lexer.Position    { return n.Condition.Position() }
 
 // Ensures all statements implement the Stmt interface
 func (StmtBlock) Stmt()        {}
@@ -58,6 +121,16 @@ func (StmtCallFunction) Stmt() {}
 func (StmtVarDeclare) Stmt()   {}
 func (StmtVarAssign) Stmt()    {}
 func (StmtObjDefine) Stmt()    {}
+func (StmtImport) Stmt()       {}
+func (StmtIf) Stmt()           {}
+func (StmtWhile) Stmt()        {}
+func (StmtFor) Stmt()          {}
+func (StmtForEach) Stmt()      {}
+func (StmtFunction) Stmt()     {}
+func (StmtReturn) Stmt()       {}
+func (StmtBreak) Stmt()        {}
+func (StmtContinue) Stmt()     {}
+func (StmtExpr) Stmt()         {}
 
 // Ensures all expressions implement the Expr interface
 func (ExprBlock) Expr()    {}
@@ -70,55 +143,154 @@ func (ExprVariable) Expr() {}
 func (ExprIndex) Expr()    {}
 func (ExprPropertyAccess) Expr() {}
 func (ExprBinary) Expr()   {}
+func (ExprUnary) Expr()    {}
+func (ExprCall) Expr()     {}
+func (ExprMemberCall) Expr() {}
+func (ExprConditional) Expr() {}
 
 type StmtBlock struct {
-	Stmts []Stmt
+	Stmts    []Stmt
+	Pos      lexer.Position
+	Comments []lexer.Comment // Every comment in the source; only set on the block returned by Parse
 }
 
 type StmtCallFunction struct {
 	FunctionName string
 	Args         []Expr
+	Pos          lexer.Position
 }
 
 type StmtVarDeclare struct {
 	VariableName string
 	DataType     shared.DataType
 	Expr         Expr
+	Pos          lexer.Position
 }
 
+// StmtVarAssign assigns an expression to Target, which is the variable VariableName or an
+// element or field reached from it, as in grid[y][x] or p.name. Operator is the binary
+// operator of a compound assignment, TokenType_Plus for +=, or TokenType_Undefined for a
+// plain =.
 type StmtVarAssign struct {
 	VariableName string
+	Target       Expr
+	Operator     int
 	Expr         Expr
+	Pos          lexer.Position
 }
 
 type FieldTypePair struct {
 	Field string
 	Type  shared.DataType
+	Pos   lexer.Position
 }
 
 type StmtObjDefine struct {
 	Name   string
 	Fields []FieldTypePair
+	Pos    lexer.Position
+}
+
+type StmtImport struct {
+	Path string
+	Pos  lexer.Position
+}
+
+// StmtIf runs Then when Cond is true. Otherwise it runs Else, which is nil, another
+// StmtIf for an else if, or a StmtBlock for a final else.
+type StmtIf struct {
+	Cond Expr
+	Then StmtBlock
+	Else Stmt
+	Pos  lexer.Position
+}
+
+// StmtWhile runs Body for as long as Cond is true.
+type StmtWhile struct {
+	Cond Expr
+	Body StmtBlock
+	Pos  lexer.Position
+}
+
+// StmtFor runs Body with Variable counting from Start up to and including End, going up
+// by Step, or by 1 when Step is nil.
+type StmtFor struct {
+	Variable    string
+	VariablePos lexer.Position
+	Start       Expr
+	End         Expr
+	Step        Expr
+	Body        StmtBlock
+	Pos         lexer.Position
+}
+
+// StmtForEach runs Body once for each element of Collection. A single variable is bound
+// to each element of a list or each key of a map. Two variables are bound to the index and
+// element of a list, or the key and value of a map.
+type StmtForEach struct {
+	Variables   []string
+	VariablePos lexer.Position
+	Collection  Expr
+	Body        StmtBlock
+	Pos         lexer.Position
+}
+
+// StmtFunction declares a function. ReturnType is nil for a function that returns nothing.
+type StmtFunction struct {
+	Name       string
+	Params     []FieldTypePair
+	ReturnType shared.DataType
+	Body       StmtBlock
+	Pos        lexer.Position
+}
+
+// StmtReturn returns from the enclosing function. Expr is nil when no value is returned.
+type StmtReturn struct {
+	Expr Expr
+	Pos  lexer.Position
+}
+
+// StmtBreak leaves the innermost loop.
+type StmtBreak struct {
+	Pos lexer.Position
+}
+
+// StmtContinue skips to the next iteration of the innermost loop.
+type StmtContinue struct {
+	Pos lexer.Position
+}
+
+// StmtExpr is an expression used as a statement for its side effects. Only calls can be
+// statements, as in enemies[0].update().
+type StmtExpr struct {
+	Expr Expr
+	Pos  lexer.Position
 }
 
 type ExprBlock struct {
 	Value Expr
+	Pos   lexer.Position
 }
 
 type ExprNumber struct {
 	Value string
+	Pos   lexer.Position
 }
 
 type ExprString struct {
 	Value string
+	Raw   string // Raw is the literal as written in the source, used by the formatter
+	Pos   lexer.Position
 }
 
 type ExprBoolean struct {
 	Value string
+	Pos   lexer.Position
 }
 
 type ExprList struct {
 	Values []Expr
+	Pos    lexer.Position
 }
 
 type TablePair struct {
@@ -128,10 +300,12 @@ type TablePair struct {
 
 type ExprTable struct {
 	Pairs []TablePair
+	Pos   lexer.Position
 }
 
 type ExprVariable struct {
 	Name string
+	Pos  lexer.Position
 }
 
 type ExprIndex struct {
@@ -149,3 +323,33 @@ type ExprBinary struct {
 	Operator int
 	Right    Expr
 }
+
+// ExprUnary is an operator applied to a single operand: - negates a number, ! is logical
+// not and ~ is bitwise not.
+type ExprUnary struct {
+	Operator int
+	Operand  Expr
+	Pos      lexer.Position
+}
+
+// ExprCall calls a function for its result, as in hp = max(hp - 1, 0).
+type ExprCall struct {
+	FunctionName string
+	Args         []Expr
+	Pos          lexer.Position
+}
+
+// ExprMemberCall calls a function stored in a property or element, as in
+// enemies[0].update(dt).
+type ExprMemberCall struct {
+	Callee Expr
+	Args   []Expr
+}
+
+// ExprConditional is Then when Condition is true and Else otherwise, as in
+// col = alive ? 7 : 5.
+type ExprConditional struct {
+	Condition Expr
+	Then      Expr
+	Else      Expr
+}
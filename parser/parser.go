@@ -6,6 +6,7 @@ import (
 	"io"
 	"pixie/lexer"
 	"pixie/shared"
+	"strings"
 )
 
 func New(lexer *lexer.Lexer) *Parser {
@@ -14,128 +15,763 @@ func New(lexer *lexer.Lexer) *Parser {
 	}
 }
 
+var errUnexpectedEOF = errors.New("unexpected end of file")
+
 type Parser struct {
-	lexer *lexer.Lexer
+	lexer  *lexer.Lexer
+	errors ErrorList // The syntax errors found so far
+	depth  int       // How many braced blocks the statement being parsed is in
+}
+
+// ErrorList is every syntax error found in a source, in the order they were found.
+type ErrorList []*lexer.Error
+
+func (l ErrorList) Error() string {
+	messages := make([]string, len(l))
+	for i, err := range l {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Unwrap lets errors.Is and errors.As look at every error in the list.
+func (l ErrorList) Unwrap() []error {
+	errs := make([]error, len(l))
+	for i, err := range l {
+		errs[i] = err
+	}
+	return errs
 }
 
+// Parse parses the whole input into a block of statements. After a syntax error the parser
+// skips to the next statement and carries on, so a single run finds every error. Errors
+// are returned as an ErrorList of *lexer.Error values, each reporting where the mistake is.
 func (p *Parser) Parse() (node Node, err error) {
-	return p.parseBlock()
+	block := p.parseBlock()
+	if len(p.errors) > 0 {
+		return nil, p.errors
+	}
+
+	block.Comments = p.lexer.Comments()
+	return block, nil
 }
 
-func (p *Parser) parseBlock() (block StmtBlock, err error) {
+func (p *Parser) parseBlock() (block StmtBlock) {
 	var stmts []Stmt
+	var pos lexer.Position
 
 	for {
 		// Check the next token and see if it's EOF.
-		_, err = p.lexer.PeekToken()
+		tok, err := p.lexer.PeekToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			p.synchronize(err, lexer.Position{})
+			continue
+		}
+		if !pos.IsValid() {
+			pos = tok.Pos
+		}
+
+		// Parse the next statement.
+		stmt, err := p.parseStmt()
+		if err != nil {
+			p.synchronize(err, tok.Pos)
+			continue
+		}
+		stmts = append(stmts, stmt)
+	}
+
+	return StmtBlock{
+		Stmts: stmts,
+		Pos:   pos,
+	}
+}
+
+// synchronize records a syntax error and skips ahead to where the next statement most likely
+// starts: the first label on a later line than the error, or the closing brace of the
+// block being parsed. start is where the failed statement began; it is always skipped, so
+// parsing moves on even when the statement failed before consuming anything.
+func (p *Parser) synchronize(err error, start lexer.Position) {
+	var primary *lexer.Error
+	if errors.Is(err, io.EOF) {
+		primary = &lexer.Error{Pos: p.lexer.Pos(), Err: errUnexpectedEOF}
+	} else {
+		primary = lexer.Primary(err, p.lexer.Pos())
+	}
+	p.addError(primary)
+
+	depth := 0
+	for {
+		tok, err := p.lexer.PeekToken()
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			// The lexer skips what it could not scan, so an invalid token is a mistake of its own
+			p.addError(lexer.Primary(err, p.lexer.Pos()))
+			continue
+		}
+
+		if tok.Pos != start && depth == 0 {
+			if tok.Type == lexer.TokenType_CloseBrace && p.depth > 0 {
+				return
+			}
+			if tok.Type == lexer.TokenType_Label && tok.Pos.Line > primary.Pos.Line {
+				return
+			}
+		}
+
+		switch tok.Type {
+		case lexer.TokenType_OpenBrace:
+			depth++
+		case lexer.TokenType_CloseBrace:
+			// A stray closing brace at the top level is skipped like any other token
+			if depth > 0 {
+				depth--
+			}
+		}
+		if _, err = p.lexer.GetToken(); err != nil {
+			return
+		}
+	}
+}
+
+// addError records a syntax error. An error at the same place as the one before is left
+// out, as it is most likely caused by the same mistake.
+func (p *Parser) addError(err *lexer.Error) {
+	if n := len(p.errors); n > 0 && p.errors[n-1].Pos == err.Pos {
+		return
+	}
+	p.errors = append(p.errors, err)
+}
+
+// parseBlockBraced parses statements between braces, as in the branches of an if
+// statement. The block's position is that of its opening brace.
+func (p *Parser) parseBlockBraced() (block StmtBlock, err error) {
+	tokOpen, err := p.lexer.GetToken()
+	if err != nil {
+		err = fmt.Errorf("failed to get open brace token: %w", err)
+		return
+	}
+	if tokOpen.Type != lexer.TokenType_OpenBrace {
+		err = lexer.Errorf(tokOpen.Pos, "expected '{', got %q", tokOpen.String())
+		return
+	}
+
+	p.depth++
+	defer func() {
+		p.depth--
+	}()
+
+	// Errors in the statements of the block are recorded and skipped, like at the top level
+	var stmts []Stmt
+	for {
+		var tok lexer.Token
+		tok, err = p.lexer.PeekToken()
 		if errors.Is(err, io.EOF) {
+			err = lexer.Errorf(tokOpen.Pos, "'{' is never closed")
+			return
+		}
+		if err != nil {
+			p.synchronize(err, lexer.Position{})
+			continue
+		}
+
+		if tok.Type == lexer.TokenType_CloseBrace {
+			_, err = p.lexer.GetToken() // consume '}'
+			if err != nil {
+				err = fmt.Errorf("failed to consume close brace token: %w", err)
+				return
+			}
+			break
+		}
+
+		var stmt Stmt
+		stmt, err = p.parseStmt()
+		if err != nil {
+			p.synchronize(err, tok.Pos)
+			continue
+		}
+		stmts = append(stmts, stmt)
+	}
+
+	return StmtBlock{
+		Stmts: stmts,
+		Pos:   tokOpen.Pos,
+	}, nil
+}
+
+func (p *Parser) parseStmt() (stmt Stmt, err error) {
+	tok, err := p.lexer.PeekToken()
+	if err != nil {
+		err = fmt.Errorf("failed to peek token: %w", err)
+		return
+	}
+
+	switch tok.Type {
+	case lexer.TokenType_Label:
+		switch tok.Value {
+		case shared.Keyword_If:
+			stmt, err = p.parseStmtIf()
+			if err != nil {
+				err = fmt.Errorf("failed to parse if statement: %w", err)
+				return
+			}
+			return stmt, nil
+		case shared.Keyword_While:
+			stmt, err = p.parseStmtWhile()
+			if err != nil {
+				err = fmt.Errorf("failed to parse while statement: %w", err)
+				return
+			}
+			return stmt, nil
+		case shared.Keyword_For:
+			stmt, err = p.parseStmtFor()
+			if err != nil {
+				err = fmt.Errorf("failed to parse for statement: %w", err)
+				return
+			}
+			return stmt, nil
+		case shared.Keyword_Function:
+			stmt, err = p.parseStmtFunction()
+			if err != nil {
+				err = fmt.Errorf("failed to parse function statement: %w", err)
+				return
+			}
+			return stmt, nil
+		case shared.Keyword_Return:
+			stmt, err = p.parseStmtReturn()
+			if err != nil {
+				err = fmt.Errorf("failed to parse return statement: %w", err)
+				return
+			}
+			return stmt, nil
+		case shared.Keyword_Break:
+			_, err = p.lexer.GetToken() // consume 'break'
+			if err != nil {
+				err = fmt.Errorf("failed to consume break token: %w", err)
+				return
+			}
+			return StmtBreak{Pos: tok.Pos}, nil
+		case shared.Keyword_Continue:
+			_, err = p.lexer.GetToken() // consume 'continue'
+			if err != nil {
+				err = fmt.Errorf("failed to consume continue token: %w", err)
+				return
+			}
+			return StmtContinue{Pos: tok.Pos}, nil
+		}
+
+		stmt, err = p.parseStmtLabel()
+		if err != nil {
+			err = fmt.Errorf("failed to parse label: %w", err)
+			return
+		}
+		return stmt, nil
+	}
+
+	// Every statement starts with a label
+	err = lexer.Errorf(tok.Pos, "expected statement, got %q", tok.String())
+	return
+}
+
+func (p *Parser) parseStmtLabel() (stmt Stmt, err error) {
+	tokLabel, err := p.lexer.GetToken()
+	if err != nil {
+		err = fmt.Errorf("failed to get label token: %w", err)
+		return
+	}
+
+	tokNext, err := p.lexer.PeekToken()
+	if err != nil {
+		err = fmt.Errorf("failed to peek token: %w", err)
+		return
+	}
+
+	switch tokNext.Type {
+	case lexer.TokenType_OpenParan:
+		stmt, err = p.parseStmtCallFunction(tokLabel)
+		if err != nil {
+			err = fmt.Errorf("failed to parse statement call function: %w", err)
+			return
+		}
+		return stmt, nil
+	case lexer.TokenType_Label:
+		if tokNext.Value == shared.Keyword_Object {
+			stmt, err = p.parseStmtObjDefine(tokLabel)
+			if err != nil {
+				err = fmt.Errorf("failed to parse statement object define: %w", err)
+				return
+			}
+			return stmt, err
+		}
+
+		stmt, err = p.parseStmtVarDeclare(tokLabel)
+		if err != nil {
+			err = fmt.Errorf("failed to parse statement variable declare: %w", err)
+			return
+		}
+		return stmt, nil
+	case lexer.TokenType_StringLiteral:
+		if tokLabel.Value != shared.Keyword_Import {
+			err = lexer.Errorf(tokLabel.Pos, "expected label statement, got %q %q", tokLabel.String(), tokNext.String())
+			return
+		}
+
+		stmt, err = p.parseStmtImport(tokLabel)
+		if err != nil {
+			err = fmt.Errorf("failed to parse statement import: %w", err)
+			return
+		}
+		return stmt, nil
+	case lexer.TokenType_OpenBracket, lexer.TokenType_Period:
+		stmt, err = p.parseStmtAccess(tokLabel)
+		if err != nil {
+			err = fmt.Errorf("failed to parse statement access: %w", err)
+			return
+		}
+		return stmt, nil
+	case lexer.TokenType_Equal:
+		stmt, err = p.parseStmtVarAssign(tokLabel, ExprVariable{Name: tokLabel.Value, Pos: tokLabel.Pos})
+		if err != nil {
+			err = fmt.Errorf("failed to parse statement variable assign: %w", err)
+			return
+		}
+		return stmt, nil
+	default:
+		if _, ok := compoundOperators[tokNext.Type]; ok {
+			stmt, err = p.parseStmtVarAssign(tokLabel, ExprVariable{Name: tokLabel.Value, Pos: tokLabel.Pos})
+			if err != nil {
+				err = fmt.Errorf("failed to parse statement variable assign: %w", err)
+				return
+			}
+			return stmt, nil
+		}
+
+		err = lexer.Errorf(tokLabel.Pos, "expected label statement, got %q %q", tokLabel.String(), tokNext.String())
+		return
+	}
+}
+
+// parseStmtIf parses an if statement along with any else if and else branches.
+func (p *Parser) parseStmtIf() (stmt StmtIf, err error) {
+	tokIf, err := p.lexer.GetToken()
+	if err != nil {
+		err = fmt.Errorf("failed to get if token: %w", err)
+		return
+	}
+
+	cond, err := p.parseExpr()
+	if err != nil {
+		err = fmt.Errorf("failed to parse condition: %w", err)
+		return
+	}
+
+	then, err := p.parseBlockBraced()
+	if err != nil {
+		err = fmt.Errorf("failed to parse if block: %w", err)
+		return
+	}
+
+	stmt = StmtIf{
+		Cond: cond,
+		Then: then,
+		Pos:  tokIf.Pos,
+	}
+
+	tok, err := p.lexer.PeekToken()
+	if errors.Is(err, io.EOF) {
+		return stmt, nil
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to peek token: %w", err)
+		return
+	}
+	if tok.Type != lexer.TokenType_Label || tok.Value != shared.Keyword_Else {
+		return stmt, nil
+	}
+
+	_, err = p.lexer.GetToken() // consume 'else'
+	if err != nil {
+		err = fmt.Errorf("failed to consume else token: %w", err)
+		return
+	}
+
+	tok, err = p.lexer.PeekToken()
+	if err != nil {
+		err = fmt.Errorf("failed to peek token: %w", err)
+		return
+	}
+	if tok.Type == lexer.TokenType_Label && tok.Value == shared.Keyword_If {
+		if stmt.Else, err = p.parseStmtIf(); err != nil {
+			err = fmt.Errorf("failed to parse else if statement: %w", err)
+		}
+		return
+	}
+
+	if stmt.Else, err = p.parseBlockBraced(); err != nil {
+		err = fmt.Errorf("failed to parse else block: %w", err)
+	}
+	return
+}
+
+// parseStmtWhile parses a while loop.
+func (p *Parser) parseStmtWhile() (stmt StmtWhile, err error) {
+	tokWhile, err := p.lexer.GetToken()
+	if err != nil {
+		err = fmt.Errorf("failed to get while token: %w", err)
+		return
+	}
+
+	cond, err := p.parseExpr()
+	if err != nil {
+		err = fmt.Errorf("failed to parse condition: %w", err)
+		return
+	}
+
+	body, err := p.parseBlockBraced()
+	if err != nil {
+		err = fmt.Errorf("failed to parse while block: %w", err)
+		return
+	}
+
+	return StmtWhile{
+		Cond: cond,
+		Body: body,
+		Pos:  tokWhile.Pos,
+	}, nil
+}
+
+// parseStmtFor parses a for loop. A numeric loop counts over a range, as in
+// for i in start..end step step { ... } where the step is optional; any other loop
+// iterates over a list or map, as in for i, v in list { ... }.
+func (p *Parser) parseStmtFor() (stmt Stmt, err error) {
+	tokFor, err := p.lexer.GetToken()
+	if err != nil {
+		err = fmt.Errorf("failed to get for token: %w", err)
+		return
+	}
+
+	var variables []string
+	var variablePos lexer.Position
+	for {
+		var tokVariable lexer.Token
+		tokVariable, err = p.lexer.GetToken()
+		if err != nil {
+			err = fmt.Errorf("failed to get loop variable token: %w", err)
+			return
+		}
+		if tokVariable.Type != lexer.TokenType_Label {
+			err = lexer.Errorf(tokVariable.Pos, "expected loop variable, got %q", tokVariable.String())
+			return
+		}
+		if _, ok := shared.IllegalKeywords[tokVariable.Value]; ok {
+			err = lexer.Errorf(tokVariable.Pos, "variable name %q is illegal", tokVariable.Value)
+			return
+		}
+		if len(variables) == 0 {
+			variablePos = tokVariable.Pos
+		}
+		variables = append(variables, tokVariable.Value)
+
+		var tok lexer.Token
+		tok, err = p.lexer.PeekToken()
+		if err != nil {
+			err = fmt.Errorf("failed to peek token: %w", err)
+			return
+		}
+		if tok.Type != lexer.TokenType_Comma || len(variables) == 2 {
 			break
 		}
+		_, err = p.lexer.GetToken() // consume ','
+		if err != nil {
+			err = fmt.Errorf("failed to consume comma token: %w", err)
+			return
+		}
+	}
+
+	tokIn, err := p.lexer.GetToken()
+	if err != nil {
+		err = fmt.Errorf("failed to get in token: %w", err)
+		return
+	}
+	if tokIn.Type != lexer.TokenType_Label || tokIn.Value != shared.Keyword_In {
+		err = lexer.Errorf(tokIn.Pos, "expected %q, got %q", shared.Keyword_In, tokIn.String())
+		return
+	}
+
+	start, err := p.parseExpr()
+	if err != nil {
+		err = fmt.Errorf("failed to parse range or collection: %w", err)
+		return
+	}
+
+	tok, err := p.lexer.PeekToken()
+	if err != nil {
+		err = fmt.Errorf("failed to peek token: %w", err)
+		return
+	}
+	if tok.Type != lexer.TokenType_PeriodPeriod {
+		forEach := StmtForEach{
+			Variables:   variables,
+			VariablePos: variablePos,
+			Collection:  start,
+			Pos:         tokFor.Pos,
+		}
+		if forEach.Body, err = p.parseBlockBraced(); err != nil {
+			err = fmt.Errorf("failed to parse for block: %w", err)
+			return
+		}
+		return forEach, nil
+	}
+
+	if len(variables) != 1 {
+		err = lexer.Errorf(variablePos, "a for loop over a range has one variable, got %d", len(variables))
+		return
+	}
+	_, err = p.lexer.GetToken() // consume '..'
+	if err != nil {
+		err = fmt.Errorf("failed to consume range token: %w", err)
+		return
+	}
+
+	forRange := StmtFor{
+		Variable:    variables[0],
+		VariablePos: variablePos,
+		Start:       start,
+		Pos:         tokFor.Pos,
+	}
+	if forRange.End, err = p.parseExpr(); err != nil {
+		err = fmt.Errorf("failed to parse range end: %w", err)
+		return
+	}
+
+	tok, err = p.lexer.PeekToken()
+	if err != nil {
+		err = fmt.Errorf("failed to peek token: %w", err)
+		return
+	}
+	if tok.Type == lexer.TokenType_Label && tok.Value == shared.Keyword_Step {
+		_, err = p.lexer.GetToken() // consume 'step'
+		if err != nil {
+			err = fmt.Errorf("failed to consume step token: %w", err)
+			return
+		}
+		if forRange.Step, err = p.parseExpr(); err != nil {
+			err = fmt.Errorf("failed to parse step: %w", err)
+			return
+		}
+	}
+
+	if forRange.Body, err = p.parseBlockBraced(); err != nil {
+		err = fmt.Errorf("failed to parse for block: %w", err)
+		return
+	}
+	return forRange, nil
+}
+
+// parseStmtFunction parses a function declaration, as in
+// fn name(a num, b str) bool { ... } where the return type is optional.
+func (p *Parser) parseStmtFunction() (stmt StmtFunction, err error) {
+	tokFn, err := p.lexer.GetToken()
+	if err != nil {
+		err = fmt.Errorf("failed to get fn token: %w", err)
+		return
+	}
+
+	tokName, err := p.lexer.GetToken()
+	if err != nil {
+		err = fmt.Errorf("failed to get function name token: %w", err)
+		return
+	}
+	if tokName.Type != lexer.TokenType_Label {
+		err = lexer.Errorf(tokName.Pos, "expected function name, got %q", tokName.String())
+		return
+	}
+	if _, ok := shared.IllegalKeywords[tokName.Value]; ok {
+		err = lexer.Errorf(tokName.Pos, "function name %q is illegal", tokName.Value)
+		return
+	}
+
+	if err = p.lexer.ConsumeToken(lexer.TokenType_OpenParan); err != nil {
+		err = fmt.Errorf("failed to consume open paran: %w", err)
+		return
+	}
+
+	params := make([]FieldTypePair, 0)
+	tokNext, err := p.lexer.PeekToken()
+	if err != nil {
+		err = fmt.Errorf("failed to peek token: %w", err)
+		return
+	}
+	for tokNext.Type != lexer.TokenType_CloseParan {
+		var tokParam lexer.Token
+		tokParam, err = p.lexer.GetToken()
+		if err != nil {
+			err = fmt.Errorf("failed to get parameter name token: %w", err)
+			return
+		}
+		if tokParam.Type != lexer.TokenType_Label {
+			err = lexer.Errorf(tokParam.Pos, "expected parameter name, got %q", tokParam.String())
+			return
+		}
+		if _, ok := shared.IllegalKeywords[tokParam.Value]; ok {
+			err = lexer.Errorf(tokParam.Pos, "parameter name %q is illegal", tokParam.Value)
+			return
+		}
+
+		var paramType shared.DataType
+		paramType, err = p.parseDataType()
+		if err != nil {
+			err = fmt.Errorf("failed to parse parameter type: %w", err)
+			return
+		}
+		params = append(params, FieldTypePair{
+			Field: tokParam.Value,
+			Type:  paramType,
+			Pos:   tokParam.Pos,
+		})
+
+		tokNext, err = p.lexer.PeekToken()
 		if err != nil {
 			err = fmt.Errorf("failed to peek token: %w", err)
 			return
 		}
+		switch tokNext.Type {
+		case lexer.TokenType_CloseParan:
+		case lexer.TokenType_Comma:
+			_, err = p.lexer.GetToken() // consume ','
+			if err != nil {
+				err = fmt.Errorf("failed to consume comma token: %w", err)
+				return
+			}
+			// The parameters may end with a trailing comma
+			tokNext, err = p.lexer.PeekToken()
+			if err != nil {
+				err = fmt.Errorf("failed to peek token: %w", err)
+				return
+			}
+		default:
+			err = lexer.Errorf(tokNext.Pos, "unexpected token %q", tokNext.String())
+			return
+		}
+	}
+	_, err = p.lexer.GetToken() // consume ')'
+	if err != nil {
+		err = fmt.Errorf("failed to consume close paran token: %w", err)
+		return
+	}
 
-		// Parse the next statement.
-		var stmt Stmt
-		stmt, err = p.parseStmt()
-		if err != nil {
-			err = fmt.Errorf("failed to parse statement: %w", err)
-			return
-		}
+	stmt = StmtFunction{
+		Name:   tokName.Value,
+		Params: params,
+		Pos:    tokFn.Pos,
+	}
 
-		if stmt != nil {
-			stmts = append(stmts, stmt)
+	tokNext, err = p.lexer.PeekToken()
+	if err != nil {
+		err = fmt.Errorf("failed to peek token: %w", err)
+		return
+	}
+	if tokNext.Type != lexer.TokenType_OpenBrace {
+		if stmt.ReturnType, err = p.parseDataType(); err != nil {
+			err = fmt.Errorf("failed to parse return type: %w", err)
+			return
 		}
 	}
 
-	return StmtBlock{
-		Stmts: stmts,
-	}, nil
+	if stmt.Body, err = p.parseBlockBraced(); err != nil {
+		err = fmt.Errorf("failed to parse function block: %w", err)
+		return
+	}
+	return stmt, nil
 }
 
-func (p *Parser) parseStmt() (stmt Stmt, err error) {
+// parseStmtReturn parses a return statement. The value is left out when the block ends
+// straight after return.
+func (p *Parser) parseStmtReturn() (stmt StmtReturn, err error) {
+	tokReturn, err := p.lexer.GetToken()
+	if err != nil {
+		err = fmt.Errorf("failed to get return token: %w", err)
+		return
+	}
+	stmt = StmtReturn{Pos: tokReturn.Pos}
+
 	tok, err := p.lexer.PeekToken()
+	if errors.Is(err, io.EOF) {
+		return stmt, nil
+	}
 	if err != nil {
 		err = fmt.Errorf("failed to peek token: %w", err)
 		return
 	}
-
-	switch tok.Type {
-	case lexer.TokenType_Label:
-		stmt, err = p.parseStmtLabel()
-		if err != nil {
-			err = fmt.Errorf("failed to parse label: %w", err)
-			return
-		}
+	if tok.Type == lexer.TokenType_CloseBrace {
 		return stmt, nil
 	}
 
-	return nil, nil
+	if stmt.Expr, err = p.parseExpr(); err != nil {
+		err = fmt.Errorf("failed to parse return value: %w", err)
+		return
+	}
+	return stmt, nil
 }
 
-func (p *Parser) parseStmtLabel() (stmt Stmt, err error) {
-	tokLabel, err := p.lexer.GetToken()
+func (p *Parser) parseStmtCallFunction(tokLabel lexer.Token) (stmt StmtCallFunction, err error) {
+	exprs, err := p.parseArgs()
 	if err != nil {
-		err = fmt.Errorf("failed to get label token: %w", err)
 		return
 	}
 
-	tokNext, err := p.lexer.PeekToken()
+	return StmtCallFunction{
+		FunctionName: tokLabel.Value,
+		Args:         exprs,
+		Pos:          tokLabel.Pos,
+	}, nil
+}
+
+// consumeClosing consumes the next token if it is of tokenType, the token closing a comma
+// separated list, reporting whether it did.
+func (p *Parser) consumeClosing(tokenType int) (closed bool, err error) {
+	tok, err := p.lexer.PeekToken()
 	if err != nil {
 		err = fmt.Errorf("failed to peek token: %w", err)
 		return
 	}
+	if tok.Type != tokenType {
+		return false, nil
+	}
 
-	switch tokNext.Type {
-	case lexer.TokenType_OpenParan:
-		stmt, err = p.parseStmtCallFunction(tokLabel)
-		if err != nil {
-			err = fmt.Errorf("failed to parse statement call function: %w", err)
-			return
-		}
-		return stmt, nil
-	case lexer.TokenType_Label:
-		if tokNext.Value == shared.Keyword_Object {
-			stmt, err = p.parseStmtObjDefine(tokLabel)
-			if err != nil {
-				err = fmt.Errorf("failed to parse statement object define: %w", err)
-				return
-			}
-			return stmt, err
-		}
-
-		stmt, err = p.parseStmtVarDeclare(tokLabel)
-		if err != nil {
-			err = fmt.Errorf("failed to parse statement variable declare: %w", err)
-			return
-		}
-		return stmt, nil
-	case lexer.TokenType_Equal:
-		stmt, err = p.parseStmtVarAssign(tokLabel)
-		if err != nil {
-			err = fmt.Errorf("failed to parse statement variable assign: %w", err)
-			return
-		}
-		return stmt, nil
-	default:
-		err = fmt.Errorf("expected label statement, got %q %q", tokLabel.String(), tokNext.String())
+	if _, err = p.lexer.GetToken(); err != nil {
+		err = fmt.Errorf("failed to get closing token: %w", err)
 		return
 	}
+	return true, nil
 }
 
-func (p *Parser) parseStmtCallFunction(tokLabel lexer.Token) (stmt StmtCallFunction, err error) {
+// parseArgs parses the parenthesised, comma separated arguments of a function call.
+func (p *Parser) parseArgs() (exprs []Expr, err error) {
 	// Consume the open paran token.
 	if _, err = p.lexer.GetToken(); err != nil {
 		err = fmt.Errorf("failed to get open paran token: %w", err)
 		return
 	}
 
-	exprs := make([]Expr, 0)
+	exprs = make([]Expr, 0)
 	var expr Expr
 	var tokNext lexer.Token
-parseStmtCallFunctionLoop:
 	for {
+		var closed bool
+		closed, err = p.consumeClosing(lexer.TokenType_CloseParan)
+		if err != nil {
+			err = fmt.Errorf("failed to consume close paran: %w", err)
+			return
+		}
+		if closed {
+			break
+		}
+
 		expr, err = p.parseExpr()
 		if err != nil {
 			err = fmt.Errorf("failed to parse expression: %w", err)
@@ -152,12 +788,7 @@ parseStmtCallFunctionLoop:
 
 		switch tokNext.Type {
 		case lexer.TokenType_CloseParan:
-			_, err = p.lexer.GetToken()
-			if err != nil {
-				err = fmt.Errorf("failed to get close paran token: %w", err)
-				return
-			}
-			break parseStmtCallFunctionLoop
+			continue // consumed at the top of the loop
 		case lexer.TokenType_Comma:
 			_, err = p.lexer.GetToken()
 			if err != nil {
@@ -166,25 +797,22 @@ parseStmtCallFunctionLoop:
 			}
 			continue
 		default:
-			err = fmt.Errorf("unexpected token %q", tokNext.String())
+			err = lexer.Errorf(tokNext.Pos, "unexpected token %q", tokNext.String())
 			return
 		}
 	}
 
-	return StmtCallFunction{
-		FunctionName: tokLabel.Value,
-		Args:         exprs,
-	}, nil
+	return exprs, nil
 }
 
 func (p *Parser) parseStmtVarDeclare(tokLabel lexer.Token) (stmt StmtVarDeclare, err error) {
 	if len(tokLabel.Value) == 0 {
-		err = fmt.Errorf("variable name is empty")
+		err = lexer.Errorf(tokLabel.Pos, "variable name is empty")
 		return
 	}
 
 	if _, ok := shared.IllegalKeywords[tokLabel.Value]; ok {
-		err = fmt.Errorf("variable name %q is illegal", tokLabel.Value)
+		err = lexer.Errorf(tokLabel.Pos, "variable name %q is illegal", tokLabel.Value)
 		return
 	}
 
@@ -205,6 +833,7 @@ func (p *Parser) parseStmtVarDeclare(tokLabel lexer.Token) (stmt StmtVarDeclare,
 				VariableName: tokLabel.Value,
 				DataType:     dataType,
 				Expr:         nil,
+				Pos:          tokLabel.Pos,
 			}, nil
 		}
 		err = fmt.Errorf("failed to peek equal token: %w", err)
@@ -229,17 +858,18 @@ func (p *Parser) parseStmtVarDeclare(tokLabel lexer.Token) (stmt StmtVarDeclare,
 		VariableName: tokLabel.Value,
 		DataType:     dataType,
 		Expr:         expr,
+		Pos:          tokLabel.Pos,
 	}, nil
 }
 
 func (p *Parser) parseStmtObjDefine(tokLabel lexer.Token) (stmt StmtObjDefine, err error) {
 	if len(tokLabel.Value) == 0 {
-		err = fmt.Errorf("object name is empty")
+		err = lexer.Errorf(tokLabel.Pos, "object name is empty")
 		return
 	}
 
 	if _, ok := shared.IllegalKeywords[tokLabel.Value]; ok {
-		err = fmt.Errorf("variable name %q is illegal", tokLabel.Value)
+		err = lexer.Errorf(tokLabel.Pos, "variable name %q is illegal", tokLabel.Value)
 		return
 	}
 
@@ -251,7 +881,7 @@ func (p *Parser) parseStmtObjDefine(tokLabel lexer.Token) (stmt StmtObjDefine, e
 	}
 
 	if tokObj.Value != shared.Keyword_Object {
-		err = fmt.Errorf("expected \"obj\" got %q", tokObj.Value)
+		err = lexer.Errorf(tokObj.Pos, "expected \"obj\" got %q", tokObj.Value)
 		return
 	}
 
@@ -272,7 +902,7 @@ parseStmtObjDefine:
 			return
 		}
 		if tokFieldName.Type != lexer.TokenType_Label {
-			err = fmt.Errorf("expected label, got %q", tokFieldName.String())
+			err = lexer.Errorf(tokFieldName.Pos, "expected label, got %q", tokFieldName.String())
 			return
 		}
 
@@ -288,6 +918,7 @@ parseStmtObjDefine:
 		fields = append(fields, FieldTypePair{
 			Field: tokFieldName.Value,
 			Type:  fieldType,
+			Pos:   tokFieldName.Pos,
 		})
 
 		tokNext, err = p.lexer.PeekToken()
@@ -307,7 +938,7 @@ parseStmtObjDefine:
 		case lexer.TokenType_Label:
 			continue
 		default:
-			err = fmt.Errorf("unexpected token %q", tokNext.String())
+			err = lexer.Errorf(tokNext.Pos, "unexpected token %q", tokNext.String())
 			return
 		}
 	}
@@ -315,6 +946,30 @@ parseStmtObjDefine:
 	return StmtObjDefine{
 		Name:   tokLabel.Value,
 		Fields: fields,
+		Pos:    tokLabel.Pos,
+	}, nil
+}
+
+func (p *Parser) parseStmtImport(tokImport lexer.Token) (stmt StmtImport, err error) {
+	tokPath, err := p.lexer.GetToken()
+	if err != nil {
+		err = fmt.Errorf("failed to get path token: %w", err)
+		return
+	}
+
+	if tokPath.Type != lexer.TokenType_StringLiteral {
+		err = lexer.Errorf(tokPath.Pos, "expected string literal, got %q", tokPath.String())
+		return
+	}
+
+	if len(tokPath.Value) == 0 {
+		err = lexer.Errorf(tokPath.Pos, "import path is empty")
+		return
+	}
+
+	return StmtImport{
+		Path: tokPath.Value,
+		Pos:  tokImport.Pos,
 	}, nil
 }
 
@@ -326,12 +981,12 @@ func (p *Parser) parseDataType() (dataType shared.DataType, err error) {
 	}
 
 	if tokLabel.Type != lexer.TokenType_Label {
-		err = fmt.Errorf("expected label, got %q", lexer.TokenTypeString[tokLabel.Type])
+		err = lexer.Errorf(tokLabel.Pos, "expected label, got %q", lexer.TokenTypeString[tokLabel.Type])
 		return
 	}
 
 	if len(tokLabel.Value) == 0 {
-		err = fmt.Errorf("data type is empty")
+		err = lexer.Errorf(tokLabel.Pos, "data type is empty")
 		return
 	}
 
@@ -372,7 +1027,7 @@ func (p *Parser) parseDataTypeList() (dataType shared.List, err error) {
 	}
 
 	if tokOpenBracket.Type != lexer.TokenType_OpenBracket {
-		err = fmt.Errorf("expected open bracket, got %q", tokOpenBracket.String())
+		err = lexer.Errorf(tokOpenBracket.Pos, "expected open bracket, got %q", tokOpenBracket.String())
 		return
 	}
 
@@ -391,7 +1046,7 @@ func (p *Parser) parseDataTypeList() (dataType shared.List, err error) {
 	}
 
 	if tokCloseBracket.Type != lexer.TokenType_CloseBracket {
-		err = fmt.Errorf("expected close bracket, got %q", tokCloseBracket.String())
+		err = lexer.Errorf(tokCloseBracket.Pos, "expected close bracket, got %q", tokCloseBracket.String())
 		return
 	}
 
@@ -439,17 +1094,76 @@ func (p *Parser) parseDataTypeMap() (dataType shared.Map, err error) {
 	}, nil
 }
 
-func (p *Parser) parseStmtVarAssign(tokLabel lexer.Token) (stmt StmtVarAssign, err error) {
+// compoundOperators maps the compound assignment tokens to the binary operator they apply.
+var compoundOperators = map[int]int{
+	lexer.TokenType_PlusEqual:                              lexer.TokenType_Plus,
+	lexer.TokenType_MinusEqual:                             lexer.TokenType_Minus,
+	lexer.TokenType_AsteriskEqual:                          lexer.TokenType_Asterisk,
+	lexer.TokenType_ForwardSlashEqual:                      lexer.TokenType_ForwardSlash,
+	lexer.TokenType_PercentEqual:                           lexer.TokenType_Percent,
+	lexer.TokenType_CaretEqual:                             lexer.TokenType_Caret,
+	lexer.TokenType_BackslashEqual:                         lexer.TokenType_Backslash,
+	lexer.TokenType_AmpersandEqual:                         lexer.TokenType_Ampersand,
+	lexer.TokenType_PipeEqual:                              lexer.TokenType_Pipe,
+	lexer.TokenType_CaretCaretEqual:                        lexer.TokenType_CaretCaret,
+	lexer.TokenType_LessThanLessThanEqual:                  lexer.TokenType_LessThanLessThan,
+	lexer.TokenType_GreaterThanGreaterThanEqual:            lexer.TokenType_GreaterThanGreaterThan,
+	lexer.TokenType_GreaterThanGreaterThanGreaterThanEqual: lexer.TokenType_GreaterThanGreaterThanGreaterThan,
+	lexer.TokenType_LessThanLessThanGreaterThanEqual:       lexer.TokenType_LessThanLessThanGreaterThan,
+	lexer.TokenType_GreaterThanGreaterThanLessThanEqual:    lexer.TokenType_GreaterThanGreaterThanLessThan,
+}
+
+// parseStmtAccess parses a statement that starts with indexing or property access on the
+// variable tokLabel names: either an assignment to the element or property, or a call of it.
+func (p *Parser) parseStmtAccess(tokLabel lexer.Token) (stmt Stmt, err error) {
+	target, err := p.parseExprPostfixOps(ExprVariable{
+		Name: tokLabel.Value,
+		Pos:  tokLabel.Pos,
+	})
+	if err != nil {
+		err = fmt.Errorf("failed to parse assignment target: %w", err)
+		return
+	}
+
+	call, isCall := target.(ExprMemberCall)
+	if !isCall {
+		return p.parseStmtVarAssign(tokLabel, target)
+	}
+
+	tokNext, err := p.lexer.PeekToken()
+	if errors.Is(err, io.EOF) {
+		return StmtExpr{Expr: call, Pos: tokLabel.Pos}, nil
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to peek token: %w", err)
+		return
+	}
+	if _, ok := compoundOperators[tokNext.Type]; ok || tokNext.Type == lexer.TokenType_Equal {
+		err = lexer.Errorf(tokNext.Pos, "cannot assign to the result of a call")
+		return
+	}
+	return StmtExpr{Expr: call, Pos: tokLabel.Pos}, nil
+}
+
+// parseStmtVarAssign parses an assignment to target, the variable tokLabel names or an
+// element or property reached through it, either a plain = or a compound assignment such
+// as +=.
+func (p *Parser) parseStmtVarAssign(tokLabel lexer.Token, target Expr) (stmt StmtVarAssign, err error) {
 	if len(tokLabel.Value) == 0 {
-		err = fmt.Errorf("variable name is empty")
+		err = lexer.Errorf(tokLabel.Pos, "variable name is empty")
 		return
 	}
 
-	// Consume the equal token
-	if _, err = p.lexer.GetToken(); err != nil {
+	// Consume the equal or compound assignment token
+	tokAssign, err := p.lexer.GetToken()
+	if err != nil {
 		err = fmt.Errorf("failed to consume equal token: %w", err)
 		return
 	}
+	if _, ok := compoundOperators[tokAssign.Type]; !ok && tokAssign.Type != lexer.TokenType_Equal {
+		err = lexer.Errorf(tokAssign.Pos, "expected assignment, got %q", tokAssign.String())
+		return
+	}
 
 	var expr Expr
 	expr, err = p.parseExpr()
@@ -460,18 +1174,147 @@ func (p *Parser) parseStmtVarAssign(tokLabel lexer.Token) (stmt StmtVarAssign, e
 
 	return StmtVarAssign{
 		VariableName: tokLabel.Value,
+		Target:       target,
+		Operator:     compoundOperators[tokAssign.Type],
 		Expr:         expr,
+		Pos:          tokLabel.Pos,
 	}, nil
 }
 
 func (p *Parser) parseExpr() (expr Expr, err error) {
 	// Parse binary expression with precedence
-	expr, err = p.parseExprWithPrecedence(0)
+	expr, err = p.parseExprWithPrecedence(precedenceLowest)
+	if err != nil {
+		return
+	}
+	return p.parseExprConditional(expr)
+}
+
+// parseExprConditional parses the branches of a conditional expression if a ? follows
+// condition. It binds looser than any operator, and a conditional in the else branch
+// groups to the right, so a ? 1 : b ? 2 : 3 picks one of three values.
+func (p *Parser) parseExprConditional(condition Expr) (expr Expr, err error) {
+	tok, err := p.lexer.PeekToken()
+	if errors.Is(err, io.EOF) {
+		return condition, nil
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to peek token: %w", err)
+		return
+	}
+	if tok.Type != lexer.TokenType_Question {
+		return condition, nil
+	}
+
+	_, err = p.lexer.GetToken() // consume '?'
+	if err != nil {
+		err = fmt.Errorf("failed to consume question token: %w", err)
+		return
+	}
+
+	conditional := ExprConditional{Condition: condition}
+	if conditional.Then, err = p.parseExpr(); err != nil {
+		err = fmt.Errorf("failed to parse true branch: %w", err)
+		return
+	}
+	if err = p.lexer.ConsumeToken(lexer.TokenType_Colon); err != nil {
+		err = fmt.Errorf("failed to consume colon: %w", err)
+		return
+	}
+	if conditional.Else, err = p.parseExpr(); err != nil {
+		err = fmt.Errorf("failed to parse false branch: %w", err)
+		return
+	}
+	return conditional, nil
+}
+
+// parseExprUnary parses an operand of a binary expression: a power expression with any
+// number of unary operators in front of it. Unary operators bind tighter than every
+// binary operator except ^, so -a * b is (-a) * b but -a^2 is -(a^2), and looser than
+// indexing and property access, so -a[0] is -(a[0]).
+func (p *Parser) parseExprUnary() (expr Expr, err error) {
+	tok, err := p.lexer.PeekToken()
+	if err != nil {
+		err = fmt.Errorf("failed to peek token: %w", err)
+		return
+	}
+
+	switch tok.Type {
+	case lexer.TokenType_Minus, lexer.TokenType_Bang, lexer.TokenType_Tilde:
+		_, err = p.lexer.GetToken() // consume the operator
+		if err != nil {
+			err = fmt.Errorf("failed to consume operator token: %w", err)
+			return
+		}
+
+		var operand Expr
+		operand, err = p.parseExprUnary()
+		if err != nil {
+			err = fmt.Errorf("failed to parse operand of unary operator: %w", err)
+			return
+		}
+
+		return ExprUnary{
+			Operator: tok.Type,
+			Operand:  operand,
+			Pos:      tok.Pos,
+		}, nil
+	}
+
+	return p.parseExprPower()
+}
+
+// parseExprPower parses a postfix expression raised to an optional power. ^ binds tighter
+// than the unary operators and is right associative, so 2^3^2 is 2^(3^2), and its
+// exponent may itself be negated, as in 2^-1.
+func (p *Parser) parseExprPower() (expr Expr, err error) {
+	expr, err = p.parseExprPostfix()
+	if err != nil {
+		return
+	}
+
+	tok, err := p.lexer.PeekToken()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return expr, nil
+		}
+		err = fmt.Errorf("failed to peek token: %w", err)
+		return
+	}
+	if tok.Type != lexer.TokenType_Caret {
+		return expr, nil
+	}
+
+	_, err = p.lexer.GetToken() // consume '^'
+	if err != nil {
+		err = fmt.Errorf("failed to consume caret token: %w", err)
+		return
+	}
+
+	exponent, err := p.parseExprUnary()
+	if err != nil {
+		err = fmt.Errorf("failed to parse exponent: %w", err)
+		return
+	}
+
+	return ExprBinary{
+		Left:     expr,
+		Operator: lexer.TokenType_Caret,
+		Right:    exponent,
+	}, nil
+}
+
+// parseExprPostfix parses a base expression followed by any indexing and property access.
+func (p *Parser) parseExprPostfix() (expr Expr, err error) {
+	expr, err = p.parseExprBase()
 	if err != nil {
 		return expr, err
 	}
+	return p.parseExprPostfixOps(expr)
+}
 
-	// Then handle any indexing operations
+// parseExprPostfixOps parses any indexing, property access and calls following expr.
+func (p *Parser) parseExprPostfixOps(expr Expr) (Expr, error) {
 	for {
 		tok, err := p.lexer.PeekToken()
 		if err != nil {
@@ -500,7 +1343,7 @@ func (p *Parser) parseExpr() (expr Expr, err error) {
 			}
 
 			if tokCloseBracket.Type != lexer.TokenType_CloseBracket {
-				return expr, fmt.Errorf("expected ']', got %q", tokCloseBracket.String())
+				return expr, lexer.Errorf(tokCloseBracket.Pos, "expected ']', got %q", tokCloseBracket.String())
 			}
 
 			_, err = p.lexer.GetToken() // consume ']'
@@ -525,13 +1368,31 @@ func (p *Parser) parseExpr() (expr Expr, err error) {
 			}
 
 			if tokLabel.Type != lexer.TokenType_Label {
-				return expr, fmt.Errorf("expected label after '.', got %q", tokLabel.String())
+				return expr, lexer.Errorf(tokLabel.Pos, "expected label after '.', got %q", tokLabel.String())
 			}
 
 			expr = ExprPropertyAccess{
 				Left:     expr,
 				Property: tokLabel.Value,
 			}
+		case lexer.TokenType_OpenParan:
+			// Handle calls of a function stored in a property or element; other calls are
+			// parsed along with their function name
+			switch expr.(type) {
+			case ExprIndex, ExprPropertyAccess:
+			default:
+				return expr, nil
+			}
+
+			args, err := p.parseArgs()
+			if err != nil {
+				return expr, fmt.Errorf("failed to parse call arguments: %w", err)
+			}
+
+			expr = ExprMemberCall{
+				Callee: expr,
+				Args:   args,
+			}
 		default:
 			return expr, nil
 		}
@@ -541,13 +1402,19 @@ func (p *Parser) parseExpr() (expr Expr, err error) {
 // Operator precedence levels
 const (
 	precedenceLowest = iota
+	precedenceOr         // ||
+	precedenceAnd        // &&
 	precedenceComparison // ==, !=, <, <=, >, >=
+	precedenceBitOr      // |
+	precedenceBitXor     // ^^
+	precedenceBitAnd     // &
+	precedenceShift      // <<, >>, >>>, <<>, >><
 	precedenceSum        // +, -
-	precedenceProduct    // *, /
+	precedenceProduct    // *, /, \, %
 )
 
 func (p *Parser) parseExprWithPrecedence(precedence int) (expr Expr, err error) {
-	expr, err = p.parseExprBase()
+	expr, err = p.parseExprUnary()
 	if err != nil {
 		return
 	}
@@ -589,12 +1456,25 @@ func (p *Parser) parseExprWithPrecedence(precedence int) (expr Expr, err error)
 
 func (p *Parser) getPrecedence(tokenType int) int {
 	switch tokenType {
+	case lexer.TokenType_PipePipe:
+		return precedenceOr
+	case lexer.TokenType_AmpersandAmpersand:
+		return precedenceAnd
 	case lexer.TokenType_EqualEqual, lexer.TokenType_BangEqual, lexer.TokenType_LessThan,
 		 lexer.TokenType_LessThanEqual, lexer.TokenType_GreaterThan, lexer.TokenType_GreaterThanEqual:
 		return precedenceComparison
+	case lexer.TokenType_Pipe:
+		return precedenceBitOr
+	case lexer.TokenType_CaretCaret:
+		return precedenceBitXor
+	case lexer.TokenType_Ampersand:
+		return precedenceBitAnd
+	case lexer.TokenType_LessThanLessThan, lexer.TokenType_GreaterThanGreaterThan, lexer.TokenType_GreaterThanGreaterThanGreaterThan,
+		lexer.TokenType_LessThanLessThanGreaterThan, lexer.TokenType_GreaterThanGreaterThanLessThan:
+		return precedenceShift
 	case lexer.TokenType_Plus, lexer.TokenType_Minus:
 		return precedenceSum
-	case lexer.TokenType_Asterisk, lexer.TokenType_ForwardSlash:
+	case lexer.TokenType_Asterisk, lexer.TokenType_ForwardSlash, lexer.TokenType_Backslash, lexer.TokenType_Percent:
 		return precedenceProduct
 	default:
 		return precedenceLowest
@@ -651,7 +1531,7 @@ func (p *Parser) parseExprBase() (expr Expr, err error) {
 		}
 
 		// Return as an expression block
-		return ExprBlock{Value: expr}, nil
+		return ExprBlock{Value: expr, Pos: tok.Pos}, nil
 	case lexer.TokenType_OpenBracket:
 		expr, err = p.parseExprList()
 		if err != nil {
@@ -675,7 +1555,7 @@ func (p *Parser) parseExprBase() (expr Expr, err error) {
 		return expr, nil
 	}
 
-	err = fmt.Errorf("expected expression, got %q", tok.String())
+	err = lexer.Errorf(tok.Pos, "expected expression, got %q", tok.String())
 	return
 }
 
@@ -686,11 +1566,12 @@ func (p *Parser) parseExprNumberLiteral() (expr ExprNumber, err error) {
 		return
 	}
 	if len(tok.Value) == 0 {
-		err = fmt.Errorf("token value is empty")
+		err = lexer.Errorf(tok.Pos, "token value is empty")
 		return
 	}
 
 	expr.Value = tok.Value
+	expr.Pos = tok.Pos
 	return expr, nil
 }
 
@@ -701,7 +1582,7 @@ func (p *Parser) parseExprStringLiteral() (expr ExprString, err error) {
 		return
 	}
 
-	return ExprString{Value: tok.Value}, nil
+	return ExprString{Value: tok.Value, Raw: tok.Raw, Pos: tok.Pos}, nil
 }
 
 func (p *Parser) parseExprBooleanLiteral() (expr ExprBoolean, err error) {
@@ -712,12 +1593,13 @@ func (p *Parser) parseExprBooleanLiteral() (expr ExprBoolean, err error) {
 	}
 
 	if tok.Value != "true" && tok.Value != "false" {
-		err = fmt.Errorf("expected boolean, got: %v", tok.String())
+		err = lexer.Errorf(tok.Pos, "expected boolean, got: %v", tok.String())
 		return
 	}
 
 	return ExprBoolean{
 		Value: tok.Value,
+		Pos:   tok.Pos,
 	}, nil
 }
 
@@ -729,16 +1611,25 @@ func (p *Parser) parseExprList() (expr ExprList, err error) {
 		return
 	}
 	if tokOpenBracket.Type != lexer.TokenType_OpenBracket {
-		err = fmt.Errorf("expected open bracket, got %q", tokOpenBracket.String())
+		err = lexer.Errorf(tokOpenBracket.Pos, "expected open bracket, got %q", tokOpenBracket.String())
 		return
 	}
 
-	// parse the inside expressions
+	// parse the inside expressions, which may be followed by a trailing comma
 	exprs := make([]Expr, 0)
 	var listExpr Expr
 	var tokNext lexer.Token
-parseExprListLoop:
 	for {
+		var closed bool
+		closed, err = p.consumeClosing(lexer.TokenType_CloseBracket)
+		if err != nil {
+			err = fmt.Errorf("failed to consume close bracket: %w", err)
+			return
+		}
+		if closed {
+			break
+		}
+
 		listExpr, err = p.parseExpr()
 		if err != nil {
 			err = fmt.Errorf("failed to parse expression: %w", err)
@@ -755,12 +1646,7 @@ parseExprListLoop:
 
 		switch tokNext.Type {
 		case lexer.TokenType_CloseBracket:
-			_, err = p.lexer.GetToken()
-			if err != nil {
-				err = fmt.Errorf("failed to get close paran token: %w", err)
-				return
-			}
-			break parseExprListLoop
+			continue // consumed at the top of the loop
 		case lexer.TokenType_Comma:
 			_, err = p.lexer.GetToken()
 			if err != nil {
@@ -769,29 +1655,44 @@ parseExprListLoop:
 			}
 			continue
 		default:
-			err = fmt.Errorf("unexpected token %q", tokNext.String())
+			err = lexer.Errorf(tokNext.Pos, "unexpected token %q", tokNext.String())
 			return
 		}
 	}
 
 	return ExprList{
 		Values: exprs,
+		Pos:    tokOpenBracket.Pos,
 	}, nil
 }
 
 func (p *Parser) parseExprTable() (expr ExprTable, err error) {
 	// Consume open brace
-	if err = p.lexer.ConsumeToken(lexer.TokenType_OpenBrace); err != nil {
+	tokOpenBrace, err := p.lexer.GetToken()
+	if err != nil {
 		err = fmt.Errorf("failed to consume open brace token: %w", err)
 		return
 	}
+	if tokOpenBrace.Type != lexer.TokenType_OpenBrace {
+		err = lexer.Errorf(tokOpenBrace.Pos, "expected open brace, got %q", tokOpenBrace.String())
+		return
+	}
 
 	pairs := make([]TablePair, 0)
 
-	// Parse inside fo table
+	// Parse inside fo table, where the pairs may be followed by a trailing comma
 	var tokNext lexer.Token
-parseExprMapLoop:
 	for {
+		var closed bool
+		closed, err = p.consumeClosing(lexer.TokenType_CloseBrace)
+		if err != nil {
+			err = fmt.Errorf("failed to consume close brace: %w", err)
+			return
+		}
+		if closed {
+			break
+		}
+
 		// Parse key expression
 		var keyExpr Expr
 		keyExpr, err = p.parseExpr()
@@ -828,12 +1729,7 @@ parseExprMapLoop:
 
 		switch tokNext.Type {
 		case lexer.TokenType_CloseBrace:
-			_, err = p.lexer.GetToken()
-			if err != nil {
-				err = fmt.Errorf("failed to get close brace token: %w", err)
-				return
-			}
-			break parseExprMapLoop
+			continue // consumed at the top of the loop
 		case lexer.TokenType_Comma:
 			if err = p.lexer.ConsumeToken(lexer.TokenType_Comma); err != nil {
 				err = fmt.Errorf("failed to consume comma token: %w", err)
@@ -841,12 +1737,13 @@ parseExprMapLoop:
 			}
 			continue
 		default:
-			err = fmt.Errorf("unexpected token %q", tokNext.String())
+			err = lexer.Errorf(tokNext.Pos, "unexpected token %q", tokNext.String())
 			return
 		}
 	}
 	return ExprTable{
 		Pairs: pairs,
+		Pos:   tokOpenBrace.Pos,
 	}, nil
 }
 
@@ -858,11 +1755,31 @@ func (p *Parser) parseExprLabel() (expr Expr, err error) {
 	}
 
 	if len(tokLabel.Value) == 0 {
-		err = fmt.Errorf("label is empty")
+		err = lexer.Errorf(tokLabel.Pos, "label is empty")
 		return
 	}
 
+	// A label followed by an open paran is a function call.
+	tokNext, err := p.lexer.PeekToken()
+	if err != nil && !errors.Is(err, io.EOF) {
+		err = fmt.Errorf("failed to peek token: %w", err)
+		return
+	}
+	if err == nil && tokNext.Type == lexer.TokenType_OpenParan {
+		var args []Expr
+		if args, err = p.parseArgs(); err != nil {
+			err = fmt.Errorf("failed to parse call arguments: %w", err)
+			return
+		}
+		return ExprCall{
+			FunctionName: tokLabel.Value,
+			Args:         args,
+			Pos:          tokLabel.Pos,
+		}, nil
+	}
+
 	return ExprVariable{
 		Name: tokLabel.Value,
+		Pos:  tokLabel.Pos,
 	}, nil
 }
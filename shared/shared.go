@@ -11,6 +11,16 @@ const (
 	Keyword_True     = "true"
 	Keyword_False    = "false"
 	Keyword_Local    = "local"
+	Keyword_Import   = "import"
+	Keyword_If       = "if"
+	Keyword_Else     = "else"
+	Keyword_While    = "while"
+	Keyword_For      = "for"
+	Keyword_In       = "in"
+	Keyword_Step     = "step"
+	Keyword_Break    = "break"
+	Keyword_Continue = "continue"
+	Keyword_Return   = "return"
 )
 
 var (
@@ -23,5 +33,15 @@ var (
 		Keyword_Map:      {},
 		Keyword_True:     {},
 		Keyword_False:    {},
+		Keyword_Import:   {},
+		Keyword_If:       {},
+		Keyword_Else:     {},
+		Keyword_While:    {},
+		Keyword_For:      {},
+		Keyword_In:       {},
+		Keyword_Step:     {},
+		Keyword_Break:    {},
+		Keyword_Continue: {},
+		Keyword_Return:   {},
 	}
 )
@@ -0,0 +1,173 @@
+// Package sourcemap maps generated Lua back to the pixie source it was compiled from.
+// A map is written next to the generated file as JSON, so errors PICO-8 reports by
+// Lua line can be traced to the pixie statement that produced the line.
+//
+// The JSON form is compact: every mapping is an array of five numbers, the Lua line and
+// column followed by an index into "sources" and the pixie line and column.
+//
+//	{"version":1,"file":"game.p8","sources":["game.pixie"],"tabs":[1],"mappings":[[1,1,0,1,1]]}
+package sourcemap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"pixie/lexer"
+	"sort"
+	"strings"
+)
+
+const (
+	// Version is the version of the JSON format written by Bytes.
+	Version = 1
+	// Ext is appended to the name of a generated file to name its map.
+	Ext = ".map"
+
+	tabSeparator = "-->8" // tabSeparator is the line PICO-8 starts a new code tab with
+)
+
+var (
+	ErrInvalidMap = errors.New("invalid source map") // ErrInvalidMap is returned when a map cannot be decoded
+)
+
+// Mapping ties a location in the generated Lua to the pixie source it came from.
+type Mapping struct {
+	Line   int            // Lua line, starting at 1
+	Column int            // Lua column counted in runes, starting at 1
+	Source lexer.Position // Where the Lua from this point on was generated from
+}
+
+// Map is the source map of a single generated Lua file.
+type Map struct {
+	File     string    // Name of the generated file
+	Tabs     []int     // Lua line each PICO-8 code tab starts on, so Tabs[0] is always 1
+	Mappings []Mapping // Mappings sorted by Lua line and column
+}
+
+// New creates the map for the Lua written to file. The Lua is scanned for code tab
+// separators so PICO-8's tab relative line numbers can be looked up.
+func New(file, lua string, mappings []Mapping) *Map {
+	m := &Map{
+		File:     file,
+		Tabs:     []int{1},
+		Mappings: mappings,
+	}
+	for i, line := range strings.Split(lua, "\n") {
+		if strings.TrimRight(line, "\r") == tabSeparator {
+			m.Tabs = append(m.Tabs, i+2)
+		}
+	}
+	return m
+}
+
+type jsonMap struct {
+	Version  int      `json:"version"`
+	File     string   `json:"file"`
+	Sources  []string `json:"sources"`
+	Tabs     []int    `json:"tabs"`
+	Mappings [][5]int `json:"mappings"`
+}
+
+// Bytes returns the map encoded as JSON.
+func (m *Map) Bytes() []byte {
+	out := jsonMap{
+		Version:  Version,
+		File:     m.File,
+		Sources:  make([]string, 0),
+		Tabs:     m.Tabs,
+		Mappings: make([][5]int, 0, len(m.Mappings)),
+	}
+
+	sources := make(map[string]int)
+	for _, mapping := range m.Mappings {
+		index, ok := sources[mapping.Source.File]
+		if !ok {
+			index = len(out.Sources)
+			sources[mapping.Source.File] = index
+			out.Sources = append(out.Sources, mapping.Source.File)
+		}
+		out.Mappings = append(out.Mappings, [5]int{mapping.Line, mapping.Column, index, mapping.Source.Line, mapping.Source.Column})
+	}
+
+	data, _ := json.Marshal(out)
+	return append(data, '\n')
+}
+
+// Read decodes a map from r.
+func Read(r io.Reader) (m *Map, err error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		err = fmt.Errorf("failed to read source map: %w", err)
+		return
+	}
+	return Parse(src)
+}
+
+// Parse decodes a map from its JSON encoding.
+func Parse(src []byte) (m *Map, err error) {
+	var in jsonMap
+	if err = json.Unmarshal(src, &in); err != nil {
+		err = fmt.Errorf("%w: %s", ErrInvalidMap, err)
+		return
+	}
+	if in.Version != Version {
+		err = fmt.Errorf("%w: unsupported version %d", ErrInvalidMap, in.Version)
+		return
+	}
+
+	m = &Map{
+		File:     in.File,
+		Tabs:     in.Tabs,
+		Mappings: make([]Mapping, 0, len(in.Mappings)),
+	}
+	if len(m.Tabs) == 0 {
+		m.Tabs = []int{1}
+	}
+	for _, mapping := range in.Mappings {
+		if mapping[2] < 0 || mapping[2] >= len(in.Sources) {
+			err = fmt.Errorf("%w: source index %d out of range", ErrInvalidMap, mapping[2])
+			return
+		}
+		m.Mappings = append(m.Mappings, Mapping{
+			Line:   mapping[0],
+			Column: mapping[1],
+			Source: lexer.Position{File: in.Sources[mapping[2]], Line: mapping[3], Column: mapping[4]},
+		})
+	}
+	return m, nil
+}
+
+// Lookup returns the pixie position the Lua at line and column was generated from. A
+// column of 0 looks up the line as a whole, returning the first position on it.
+func (m *Map) Lookup(line, column int) (pos lexer.Position, ok bool) {
+	if column <= 0 {
+		column = 1
+		// Prefer the first mapping on the line over one carried over from a previous line.
+		i := sort.Search(len(m.Mappings), func(i int) bool {
+			return m.Mappings[i].Line >= line
+		})
+		if i < len(m.Mappings) && m.Mappings[i].Line == line {
+			return m.Mappings[i].Source, true
+		}
+	}
+
+	// Find the last mapping at or before the location.
+	i := sort.Search(len(m.Mappings), func(i int) bool {
+		mapping := m.Mappings[i]
+		return mapping.Line > line || mapping.Line == line && mapping.Column > column
+	})
+	if i == 0 {
+		return lexer.Position{}, false
+	}
+	return m.Mappings[i-1].Source, true
+}
+
+// LookupTab is Lookup for a line number relative to a PICO-8 code tab, as PICO-8 reports
+// them in error messages.
+func (m *Map) LookupTab(tab, line int) (pos lexer.Position, ok bool) {
+	if tab < 0 || tab >= len(m.Tabs) {
+		return lexer.Position{}, false
+	}
+	return m.Lookup(m.Tabs[tab]+line-1, 0)
+}
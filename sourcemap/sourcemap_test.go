@@ -0,0 +1,83 @@
+package sourcemap
+
+import (
+	"pixie/lexer"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func pos(file string, line, column int) lexer.Position {
+	return lexer.Position{File: file, Line: line, Column: column}
+}
+
+func testMap() *Map {
+	lua := "x = 1\nprint(x)\n-->8\ny = 2\n"
+	return New("game.p8", lua, []Mapping{
+		{Line: 1, Column: 1, Source: pos("game.pixie", 1, 1)},
+		{Line: 1, Column: 5, Source: pos("game.pixie", 1, 9)},
+		{Line: 2, Column: 1, Source: pos("game.pixie", 3, 1)},
+		{Line: 2, Column: 7, Source: pos("game.pixie", 3, 7)},
+		{Line: 4, Column: 1, Source: pos("lib.pixie", 1, 1)},
+	})
+}
+
+func Test_RoundTrip(t *testing.T) {
+	m := testMap()
+	require.Equal(t, []int{1, 4}, m.Tabs)
+
+	decoded, err := Parse(m.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, m, decoded)
+}
+
+func Test_Lookup(t *testing.T) {
+	m := testMap()
+
+	tests := map[string]struct {
+		line, column int
+		expected     lexer.Position
+		ok           bool
+	}{
+		"whole_line":          {line: 2, column: 0, expected: pos("game.pixie", 3, 1), ok: true},
+		"exact_column":        {line: 2, column: 7, expected: pos("game.pixie", 3, 7), ok: true},
+		"between_columns":     {line: 1, column: 3, expected: pos("game.pixie", 1, 1), ok: true},
+		"after_last_column":   {line: 2, column: 9, expected: pos("game.pixie", 3, 7), ok: true},
+		"unmapped_line":       {line: 3, column: 0, expected: pos("game.pixie", 3, 7), ok: true},
+		"before_any_mappings": {line: 0, column: 0, ok: false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			actual, ok := m.Lookup(test.line, test.column)
+			require.Equal(t, test.ok, ok)
+			require.Equal(t, test.expected, actual)
+		})
+	}
+}
+
+func Test_LookupTab(t *testing.T) {
+	m := testMap()
+
+	actual, ok := m.LookupTab(1, 1)
+	require.True(t, ok)
+	require.Equal(t, pos("lib.pixie", 1, 1), actual)
+
+	_, ok = m.LookupTab(2, 1)
+	require.False(t, ok)
+}
+
+func Test_ParseInvalid(t *testing.T) {
+	tests := map[string]string{
+		"not_json":     "not json",
+		"version":      `{"version":2}`,
+		"source_index": `{"version":1,"sources":[],"mappings":[[1,1,0,1,1]]}`,
+	}
+
+	for name, src := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(src))
+			require.ErrorIs(t, err, ErrInvalidMap)
+		})
+	}
+}